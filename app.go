@@ -19,6 +19,7 @@ import (
 type DialogService interface {
 	OpenDirectory(ctx context.Context, opts runtime.OpenDialogOptions) (string, error)
 	OpenFile(ctx context.Context, opts runtime.OpenDialogOptions) (string, error)
+	SaveFile(ctx context.Context, opts runtime.SaveDialogOptions) (string, error)
 }
 
 type WailsDialogService struct{}
@@ -31,15 +32,29 @@ func (w *WailsDialogService) OpenFile(ctx context.Context, opts runtime.OpenDial
 	return runtime.OpenFileDialog(ctx, opts)
 }
 
+func (w *WailsDialogService) SaveFile(ctx context.Context, opts runtime.SaveDialogOptions) (string, error) {
+	return runtime.SaveFileDialog(ctx, opts)
+}
+
 type App struct {
 	ctx        context.Context
 	db         *sqlx.DB
 	pkRegex    *regexp.Regexp
 	logger     *slog.Logger
-	unlocked   bool
 	rootDBName string
 	rootPath   string
 	dialog     DialogService
+	// receives every emitted event, with or without a Wails runtime
+	onEvent func(WailsEmitType, any)
+	// consulted by the authorizer of every connection of db
+	policy statementPolicy
+	// the databases of the current root, attached by db on demand
+	registry dbRegistry
+	// watches the root folder; watchDone closes once its events are handled
+	watcher   folderWatcher
+	watchDone chan struct{}
+	// which files below the root are stored as databases
+	scan scanSettings
 }
 
 type CustomAppConfig struct {
@@ -47,19 +62,40 @@ type CustomAppConfig struct {
 	Logger              *slog.Logger
 	AttachDetachEnabled bool
 	DialogService
+	EventHandler func(WailsEmitType, any)
+	// refuse writes to every database
+	ReadOnly bool
+	// the statement rules, DefaultPolicyConfig when nil. AttachDetachEnabled
+	// and ReadOnly are applied on top.
+	Policy *PolicyConfig
+	// which files below the root are stored, DefaultScanConfig when nil
+	Scan *ScanConfig
 }
 
 func NewApp(cfg *CustomAppConfig) *App {
 	if cfg.RootDBName == "" {
 		cfg.RootDBName = "main"
 	}
-	return &App{
+	app := &App{
 		rootDBName: cfg.RootDBName,
 		logger:     cfg.Logger,
-		unlocked:   cfg.AttachDetachEnabled,
 		pkRegex:    pkRegex,
 		dialog:     cfg.DialogService,
+		onEvent:    cfg.EventHandler,
+	}
+	policy := DefaultPolicyConfig()
+	if cfg.Policy != nil {
+		policy = *cfg.Policy
 	}
+	policy.AllowAttach = policy.AllowAttach || cfg.AttachDetachEnabled
+	policy.ReadOnly = policy.ReadOnly || cfg.ReadOnly
+	app.policy.configure(policy)
+	scan := DefaultScanConfig()
+	if cfg.Scan != nil {
+		scan = *cfg.Scan
+	}
+	app.scan.configure(scan)
+	return app
 }
 
 func (a *App) startup(ctx context.Context) {
@@ -69,15 +105,19 @@ func (a *App) startup(ctx context.Context) {
 	if err := os.MkdirAll(filepath.Dir(dbPath), SafePermissions); err != nil {
 		panic(err)
 	}
-	db := sqlx.MustOpen(SQLITE_DRIVER, dbPath)
+	db := a.openAuthorizedDB(dbPath)
 	db.MustExec("PRAGMA journal_mode=WAL;")
-
-	db.MustExec(buildScriptContent)
 	a.db = db
+
+	if _, err := a.execTrusted(buildScriptContent); err != nil {
+		panic(err)
+	}
+	a.upgradeMetadataDB()
 	a.logger.Info("starting app")
 }
 
 func (a *App) shutdown(ctx context.Context) {
+	a.stopWatching()
 	if a.db != nil {
 		// This is the ONLY place db.Close() should be called.
 		if err := a.db.Close(); err != nil {
@@ -86,41 +126,49 @@ func (a *App) shutdown(ctx context.Context) {
 	}
 }
 
+// adds the columns later versions introduced to the metadata tables of an
+// existing install
+func (a *App) upgradeMetadataDB() {
+	var hasReadOnly bool
+	a.db.Get(&hasReadOnly, "SELECT COUNT(*) > 0 FROM pragma_table_info('dbs') WHERE name = 'read_only';")
+	if !hasReadOnly {
+		if _, err := a.execTrusted("ALTER TABLE dbs ADD COLUMN read_only INTEGER NOT NULL DEFAULT 0;"); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// registers the databases stored for the current root; connections attach
+// them when a statement names them. Those whose file is gone are marked
+// missing.
 func (a *App) attachMainDBs() error {
 	type dbInfo struct {
-		Name string `db:"name"`
-		Path string `db:"path"`
+		Name     string `db:"name"`
+		Path     string `db:"path"`
+		ReadOnly bool   `db:"read_only"`
 	}
 	var rows []dbInfo
-	if err := a.db.Select(&rows, "SELECT name, path from main.dbs WHERE root = ?;", a.rootPath); err != nil {
+	if err := a.db.Select(&rows, "SELECT name, path, read_only from main.dbs WHERE root = ?;", a.rootPath); err != nil {
 		a.logger.Error(err.Error())
 		return err
 	}
 	for _, row := range rows {
-		attachQuery := fmt.Sprintf("ATTACH '%s' AS %s;", row.Path, row.Name)
-		if _, err := a.db.Exec(attachQuery); err != nil {
-			a.logger.Error(err.Error(), slog.String("filename", row.Path))
-			return err
+		a.policy.set(row.Name, row.ReadOnly)
+		if _, err := os.Stat(row.Path); errors.Is(err, os.ErrNotExist) {
+			a.logger.Warn("database file is missing", slog.String("name", row.Name), slog.String("filename", row.Path))
+			a.registry.markMissing(row.Name, row.Path, row.ReadOnly)
+			continue
 		}
+		a.registry.register(row.Name, row.Path, row.ReadOnly)
 	}
 	return nil
 }
 
 func (a *App) detachDBs() error {
-	var dbNames []string
-	if err := a.db.Select(&dbNames, "SELECT name FROM pragma_database_list;"); err != nil {
-		return err
-	}
-	var detachQueries []string
-	for _, dbName := range dbNames {
-		if dbName != "main" {
-			detachQueries = append(detachQueries, fmt.Sprintf("DETACH DATABASE '%s'", dbName))
-		}
-	}
-	query := strings.Join(detachQueries, "; ")
-	if _, err := a.db.Exec(query); err != nil {
-		return err
-	}
+	a.stopWatching()
+	a.registry.clear()
+	a.policy.clear()
+	a.releaseDetached()
 	return nil
 }
 
@@ -171,8 +219,17 @@ type EmitEvent struct {
 	Msg  string        `json:"msg,omitempty"`
 }
 
+// reports whether a.ctx is a Wails runtime context; events emitted outside
+// of one (tests, headless use) would abort the process
+func (a *App) canEmit() bool {
+	return a.ctx != nil && a.ctx.Value("events") != nil
+}
+
 func (a *App) newResult(err error, results any, emit *EmitEvent) AppResult {
-	if emit != nil {
+	if emit != nil && a.onEvent != nil {
+		a.onEvent(emit.Type, map[string]string{"msg": emit.Msg})
+	}
+	if emit != nil && a.canEmit() {
 		runtime.EventsEmit(
 			a.ctx,
 			emit.Type.String(),
@@ -187,48 +244,73 @@ func (a *App) newResult(err error, results any, emit *EmitEvent) AppResult {
 }
 
 func (a *App) emit(emitType WailsEmitType, emitMsg string) {
+	a.emitData(emitType, map[string]string{"msg": emitMsg})
+}
+
+func (a *App) emitData(emitType WailsEmitType, data any) {
+	if a.onEvent != nil {
+		a.onEvent(emitType, data)
+	}
+	if !a.canEmit() {
+		return
+	}
 	runtime.EventsEmit(
 		a.ctx,
 		emitType.String(),
-		map[string]string{"msg": emitMsg},
+		data,
 	)
 }
 
+// registers the database file at path as name and stores it for the current
+// root. A name already in use leaves the file out. A file put back where a
+// read-only database was pruned from is read-only as well.
 func (a *App) storeDB(name string, path string, appCreated bool) error {
-	attachQuery := fmt.Sprintf("ATTACH '%s' AS %s;", path, name)
-	if _, err := a.db.Exec(attachQuery); err != nil {
-		if strings.Contains(err.Error(), "already in use") {
-			return nil
-		}
+	if _, ok := a.registry.lookup(name); ok || strings.EqualFold(name, "main") || strings.EqualFold(name, "temp") {
+		return nil
+	}
+	readOnly := a.registry.prunedReadOnly(path)
+	a.registry.register(name, path, readOnly)
+	a.policy.set(name, readOnly)
+	err := a.checkDBFile(name)
+	if err == nil {
+		_, err = a.execTrusted("INSERT OR IGNORE INTO main.dbs (name, path, root, app_created, read_only) VALUES (?,?,?,?,?);", name, path, a.rootPath, appCreated, readOnly)
+	}
+	if err != nil {
+		a.registry.unregister(name)
+		a.policy.set(name, false)
 		if appCreated {
 			os.Remove(path)
 		} // Clean up the created file
 		return err
 	}
-	if _, err := a.db.Exec("INSERT OR IGNORE INTO main.dbs (name, path, root, app_created) VALUES (?,?,?,?);", name, path, a.rootPath, appCreated); err != nil {
-		a.db.Exec(fmt.Sprintf("DETACH DATABASE '%s';", name))
-		if appCreated {
-			os.Remove(path)
-		}
+	return nil
+}
+
+// reads the schema of a registered database, failing when its file is
+// missing or not a database
+func (a *App) checkDBFile(name string) error {
+	pool, err := a.registry.pool(name)
+	if err != nil {
 		return err
 	}
-	return nil
+	var count int
+	return pool.Get(&count, "SELECT COUNT(*) FROM sqlite_master;")
 }
 
 func (a *App) GetRootPath() AppResult {
 	return a.newResult(nil, map[string]any{"root": a.rootPath}, nil)
 }
 
+// returns the name the database file at path is registered as
 func (a *App) getSQLiteDBName(path string) (string, error) {
-	var internalDBName string
 	if path == "" {
 		return "", errors.New("path cannot be empty")
 	}
-	query := fmt.Sprintf("SELECT name FROM pragma_database_list WHERE file = '%s' LIMIT 1;", path)
-	if err := a.db.Get(&internalDBName, query); err != nil {
-		return "", err
+	db, ok := a.registry.byPath(path)
+	if !ok {
+		return "", fmt.Errorf("no database is registered for %s", path)
 	}
-	return internalDBName, nil
+	return db.Name, nil
 }
 
 // gets list of dbs from main.dbs
@@ -240,35 +322,57 @@ func (a *App) getStoredDBs() ([]models.DB, error) {
 	return mainDbs, nil
 }
 
+// gets the stored dbs that are registered, as the registry names them.
+// Those whose file is missing are logged and left out.
+func (a *App) getRegisteredDBs() ([]models.DB, error) {
+	stored, err := a.getStoredDBs()
+	if err != nil {
+		return nil, err
+	}
+	dbs := make([]models.DB, 0, len(stored))
+	for _, db := range stored {
+		registered, ok := a.registry.lookup(db.Name)
+		if !ok {
+			a.logger.Warn("skipping database that is not attached", slog.String("name", db.Name), slog.String("filename", db.Path))
+			continue
+		}
+		db.Name, db.Path = registered.Name, registered.Path
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
 func (a *App) getSQLiteDBNames() ([]string, error) {
-	var names []string
-	dbs, err := a.getStoredDBs()
+	dbs, err := a.getRegisteredDBs()
 	if err != nil {
 		return []string{}, err
 	}
+	names := make([]string, 0, len(dbs))
 	for _, db := range dbs {
-		name, err := a.getSQLiteDBName(db.Path)
-		if err != nil {
-			return []string{}, err
-		}
-		names = append(names, name)
+		names = append(names, db.Name)
 	}
 	return names, nil
 }
 
-// returns the list of all tables for given sqlite db name
+// returns the list of all tables for given sqlite db name, read through the
+// database's own pool when it is registered
 func (a *App) getTableList(dbName string) ([]string, error) {
 	var tables []string
-	query := fmt.Sprintf("SELECT name FROM %s.sqlite_master WHERE type='table';", dbName)
-	if err := a.db.Select(&tables, query); err != nil {
+	var q sqlx.Queryer = a.db
+	query := fmt.Sprintf("SELECT name FROM %s WHERE type='table';", quoteQualified(dbName, "sqlite_master"))
+	if pool, err := a.registry.pool(dbName); err == nil {
+		q = pool
+		query = "SELECT name FROM sqlite_master WHERE type='table';"
+	}
+	if err := sqlx.Select(q, &tables, query); err != nil {
 		return tables, nil
 	}
 	return tables, nil
 }
 
-func (a *App) getTableData(tableName string) (Dataframe, error) {
+func (a *App) getTableData(dbName string, tableName string) (Dataframe, error) {
 	var res Dataframe
-	query := fmt.Sprintf("SELECT * FROM %s;", tableName)
+	query := fmt.Sprintf("SELECT * FROM %s;", quoteQualified(dbName, tableName))
 	rows, err := a.db.Queryx(query)
 	if err != nil {
 		return res, err
@@ -284,10 +388,13 @@ func (a *App) getTableData(tableName string) (Dataframe, error) {
 	return res, nil
 }
 
-func (a *App) getColumns(tblName string) ([]string, error) {
+// returns the column names of tblName; an empty dbName searches main and
+// the databases the connection has attached, which are all of them while
+// they fit in SQLITE_LIMIT_ATTACHED
+func (a *App) getColumns(dbName string, tblName string) ([]string, error) {
 	var names []string
-	query := fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", tblName)
-	if err := a.db.Select(&names, query); err != nil {
+	query := fmt.Sprintf("SELECT name FROM %s;", schemaPragma(dbName, "pragma_table_info"))
+	if err := a.db.Select(&names, query, tblName); err != nil {
 		return names, err
 	}
 	return names, nil
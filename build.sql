@@ -4,6 +4,7 @@ CREATE TABLE IF NOT EXISTS dbs (
   path VARCHAR NOT NULL,
   root VARCHAR NOT NULL,
   app_created INTEGER NOT NULL DEFAULT 0,
+  read_only INTEGER NOT NULL DEFAULT 0,
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   UNIQUE (name, root)
 );
@@ -11,4 +12,9 @@ CREATE TABLE IF NOT EXISTS dbs (
 CREATE TABLE IF NOT EXISTS current_db (
     id INTEGER PRIMARY KEY,
     current_db TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS export_presets (
+    name TEXT PRIMARY KEY,
+    options TEXT NOT NULL
 );
\ No newline at end of file
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	bundleManifestName    = "manifest.json"
+	bundleManifestVersion = 1
+)
+
+type BundleEntry struct {
+	Alias        string `json:"alias"`
+	OriginalPath string `json:"originalPath"`
+	File         string `json:"file"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	UserVersion  int64  `json:"userVersion"`
+	AppCreated   bool   `json:"appCreated"`
+}
+
+// BundleManifest is stored as manifest.json next to the database files of a
+// workspace bundle and is what importBundle restores from
+type BundleManifest struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Root      string        `json:"root"`
+	Databases []BundleEntry `json:"databases"`
+}
+
+// copies src into w, returning the number of bytes written and their sha256
+func copyWithChecksum(w io.Writer, src io.Reader) (int64, string, error) {
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), src)
+	return n, hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// writes a consistent snapshot of every stored database plus a manifest into
+// a zip at zipPath. Snapshots are taken with VACUUM INTO, so databases stay
+// usable while the bundle is written.
+func (a *App) exportBundle(zipPath string) (manifest BundleManifest, err error) {
+	manifest = BundleManifest{
+		Version:   bundleManifestVersion,
+		CreatedAt: time.Now().UTC(),
+		Root:      a.rootPath,
+	}
+	dbs, err := a.getRegisteredDBs()
+	if err != nil {
+		return manifest, err
+	}
+	tmpDir, err := os.MkdirTemp("", "sqlitegui-bundle")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		return manifest, err
+	}
+	defer func() {
+		if closeErr := zipFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// a bundle missing databases is worse than none
+			os.Remove(zipPath)
+		}
+	}()
+	zipWriter := zip.NewWriter(zipFile)
+
+	for _, db := range dbs {
+		schemaName, err := a.getSQLiteDBName(db.Path)
+		if err != nil {
+			return manifest, fmt.Errorf("%s is not attached: %w", db.Name, err)
+		}
+		snapshotPath := filepath.Join(tmpDir, schemaName+".db")
+		if _, err := a.execTrusted(fmt.Sprintf("VACUUM %s INTO %s;", quoteIdent(schemaName), sqlLiteral(snapshotPath))); err != nil {
+			return manifest, fmt.Errorf("snapshot of %s failed: %w", db.Name, err)
+		}
+		entry := BundleEntry{
+			Alias:        db.Name,
+			OriginalPath: db.Path,
+			File:         path.Join("dbs", db.Name+".db"),
+			AppCreated:   db.App_Created,
+		}
+		if err := a.db.Get(&entry.UserVersion, fmt.Sprintf("PRAGMA %s.user_version;", quoteIdent(schemaName))); err != nil {
+			return manifest, err
+		}
+		snapshot, err := os.Open(snapshotPath)
+		if err != nil {
+			return manifest, err
+		}
+		w, err := zipWriter.Create(entry.File)
+		if err == nil {
+			entry.Size, entry.SHA256, err = copyWithChecksum(w, snapshot)
+		}
+		snapshot.Close()
+		os.Remove(snapshotPath)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Databases = append(manifest.Databases, entry)
+	}
+
+	w, err := zipWriter.Create(bundleManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return manifest, err
+	}
+	return manifest, zipWriter.Close()
+}
+
+func readBundleManifest(r *zip.Reader) (BundleManifest, error) {
+	var manifest BundleManifest
+	file, err := r.Open(bundleManifestName)
+	if err != nil {
+		return manifest, errors.New("not a database bundle: manifest.json is missing")
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Version > bundleManifestVersion {
+		return manifest, fmt.Errorf("bundle version %d is newer than this app supports", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// returns alias, or alias with a numeric suffix if the name is already
+// registered or stored for the current root
+func (a *App) freeDBAlias(alias string) (string, error) {
+	candidate := alias
+	for i := 1; ; i++ {
+		var count int
+		if err := a.db.Get(&count, "SELECT COUNT(*) FROM main.dbs WHERE name = ? AND root = ?;", candidate, a.rootPath); err != nil {
+			return "", err
+		}
+		if _, ok := a.registry.lookup(candidate); !ok && count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d", alias, i)
+	}
+}
+
+// extracts every database of a bundle into the app data folder, verifies its
+// checksum and attaches it to the current root. Returns the attached aliases.
+// Either every database is restored or none is.
+func (a *App) importBundle(zipPath string) (aliases []string, err error) {
+	if err := a.checkWritable(""); err != nil {
+		return nil, err
+	}
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	manifest, err := readBundleManifest(&reader.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			// drop the databases restored before the failure, files included
+			for _, alias := range aliases {
+				a.RemoveDB(alias)
+			}
+			aliases = nil
+		}
+	}()
+	for _, entry := range manifest.Databases {
+		alias, err := a.freeDBAlias(cleanDBName(entry.Alias))
+		if err != nil {
+			return aliases, err
+		}
+		dbPath, err := a.getUniqueDBPath(alias)
+		if err != nil {
+			return aliases, err
+		}
+		src, err := reader.Open(entry.File)
+		if err != nil {
+			return aliases, fmt.Errorf("bundle is missing %s: %w", entry.File, err)
+		}
+		dst, err := os.Create(dbPath)
+		if err != nil {
+			src.Close()
+			return aliases, err
+		}
+		size, checksum, err := copyWithChecksum(dst, src)
+		src.Close()
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && (size != entry.Size || checksum != entry.SHA256) {
+			err = fmt.Errorf("checksum mismatch for %s", entry.File)
+		}
+		if err != nil {
+			os.Remove(dbPath)
+			return aliases, err
+		}
+		if err := a.storeDB(alias, dbPath, true); err != nil {
+			return aliases, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "bundled.db")
+	src, err := sqlx.Open(SQLITE_DRIVER, srcPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	src.MustExec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT); INSERT INTO notes (body) VALUES ('a'), ('b'); PRAGMA user_version = 7;")
+	src.Close()
+
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.zip")
+	manifest, err := test_app.exportBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("exportBundle() error = %v", err)
+	}
+	if len(manifest.Databases) != 1 || manifest.Databases[0].UserVersion != 7 || manifest.Databases[0].SHA256 == "" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	reader, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	stored, err := readBundleManifest(&reader.Reader)
+	reader.Close()
+	if err != nil || stored.Databases[0].Size != manifest.Databases[0].Size {
+		t.Fatalf("readBundleManifest() = %+v, %v", stored, err)
+	}
+
+	aliases, err := test_app.importBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("importBundle() error = %v", err)
+	}
+	if len(aliases) != 1 || aliases[0] == "bundled" {
+		t.Fatalf("expected a renamed alias for the restored copy, got %v", aliases)
+	}
+	var restoredPath string
+	test_app.db.Get(&restoredPath, "SELECT path FROM main.dbs WHERE name = ? AND root = ?;", aliases[0], root)
+	defer os.Remove(restoredPath)
+
+	var count int
+	if err := test_app.db.Get(&count, "SELECT COUNT(*) FROM "+quoteIdent(aliases[0])+".notes;"); err != nil || count != 2 {
+		t.Errorf("restored notes count = %d (%v), want 2", count, err)
+	}
+}
+
+func TestBundleImportRollback(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "source.db")
+	newWatchTestDB(t, srcPath)
+	body, err := os.ReadFile(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+
+	// the second database does not match its checksum
+	bundlePath := filepath.Join(t.TempDir(), "bundle.zip")
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	manifest := BundleManifest{Version: bundleManifestVersion}
+	for _, alias := range []string{"bundle_rollback_first", "bundle_rollback_second"} {
+		entry := BundleEntry{Alias: alias, File: "dbs/" + alias + ".db"}
+		w, _ := zw.Create(entry.File)
+		entry.Size, entry.SHA256, _ = copyWithChecksum(w, bytes.NewReader(body))
+		manifest.Databases = append(manifest.Databases, entry)
+	}
+	manifest.Databases[1].SHA256 = "0"
+	w, _ := zw.Create(bundleManifestName)
+	json.NewEncoder(w).Encode(manifest)
+	zw.Close()
+	f.Close()
+
+	if aliases, err := test_app.importBundle(bundlePath); err == nil || aliases != nil {
+		t.Fatalf("importBundle() = %v, %v, want a checksum error", aliases, err)
+	}
+	var count int
+	test_app.db.Get(&count, "SELECT COUNT(*) FROM main.dbs WHERE root = ?;", root)
+	if _, ok := test_app.registry.lookup("bundle_rollback_first"); ok || count != 0 {
+		t.Errorf("a failed import left %d stored databases", count)
+	}
+	for _, alias := range []string{"bundle_rollback_first", "bundle_rollback_second"} {
+		if _, err := os.Stat(test_app.getNewDBPath(alias)); !os.IsNotExist(err) {
+			t.Errorf("a failed import left the file of %s", alias)
+		}
+	}
+
+	test_app.policy.setReadOnly(true)
+	defer test_app.policy.setReadOnly(false)
+	if _, err := test_app.importBundle(bundlePath); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("importBundle() error = %v in read-only mode", err)
+	}
+}
+
+func TestBundleExportFailure(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "corrupt.db")
+	newWatchTestDB(t, path)
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	// the file is overwritten after it was attached, so its snapshot fails
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bundlePath := filepath.Join(t.TempDir(), "bundle.zip")
+	if _, err := test_app.exportBundle(bundlePath); err == nil {
+		t.Fatal("exportBundle() succeeded on a corrupt database")
+	}
+	if _, err := os.Stat(bundlePath); !os.IsNotExist(err) {
+		t.Errorf("a failed export left %s behind", bundlePath)
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	CLI_FORMAT_TABLE = "table"
+	CLI_FORMAT_JSON  = "json"
+
+	cliExitFailure = 1
+	cliExitUsage   = 2
+)
+
+// returned by commands that already printed their outcome and only need the
+// exit status to signal it, e.g. diff finding differences
+var errCLIFindings = errors.New("findings reported")
+
+var errCLIUsage = errors.New("invalid usage")
+
+// a subcommand of the headless mode, run as `<binary> <name> [flags] [args]`
+type cliCommand struct {
+	summary string
+	run     func(s *cliSession, args []string) error
+}
+
+var cliCommands = map[string]cliCommand{
+	"query":  {"run a statement and print its rows", cliQuery},
+	"import": {"attach a database or bundle, or load a data file", cliImport},
+	"export": {"export the workspace, a table or a query result", cliExport},
+	"dump":   {"write a SQL dump of a database, or of every database as a zip", cliDump},
+	"diff":   {"compare the schemas of two databases, or the rows of two tables with -data", cliDiff},
+	"backup": {"copy a database to PATH, or every database into the folder PATH", cliBackup},
+	"check":  {"run integrity and foreign key checks", cliCheck},
+	"serve":  {"serve the workspace as a JSON API on a loopback address", cliServe},
+	"mcp":    {"speak the Model Context Protocol on stdin and stdout", cliMCP},
+}
+
+// kept apart from cliCommands so the commands can read it without an
+// initialization cycle
+var cliUsage = map[string]string{
+	"query":  "query [-format table|csv|tsv|json|ndjson|md|html|sql] SQL",
+	"import": "import [-db name] [-table name] [-mode append|replace|upsert] [-keys a,b] [-rejects file] [-nested text|flatten|tables] [-record-path $.path] FILE",
+	"export": "export [-format ext] [-db name -table name | -query SQL] FILE",
+	"dump":   "dump [-db name] [-schema-only] [-data-only] [FILE]",
+	"diff":   "diff [-format table|json] [-data] [-keys a,b] [-limit n] FROM TO",
+	"backup": "backup [-db name] PATH",
+	"check":  "check [-format table|json] [-db name]",
+	"serve":  "serve [-addr 127.0.0.1:7464] [-token token] [-read-token token]",
+	"mcp":    "mcp [-allow-writes]",
+}
+
+// reports whether arg names a headless subcommand rather than a GUI flag
+func isCLICommand(arg string) bool {
+	_, ok := cliCommands[arg]
+	return ok || arg == "help"
+}
+
+// holds the state of one headless run. It answers the dialogs of the menu
+// actions with paths given on the command line and collects their events.
+type cliSession struct {
+	app      *App
+	in       io.Reader
+	out      io.Writer
+	errOut   io.Writer
+	root     string
+	readOnly bool
+	paths    []string
+	failure  string
+}
+
+func (s *cliSession) nextPath() (string, error) {
+	if len(s.paths) == 0 {
+		return "", errors.New("no path given")
+	}
+	path := s.paths[0]
+	s.paths = s.paths[1:]
+	return path, nil
+}
+
+func (s *cliSession) OpenDirectory(ctx context.Context, opts runtime.OpenDialogOptions) (string, error) {
+	return s.nextPath()
+}
+
+func (s *cliSession) OpenFile(ctx context.Context, opts runtime.OpenDialogOptions) (string, error) {
+	return s.nextPath()
+}
+
+func (s *cliSession) SaveFile(ctx context.Context, opts runtime.SaveDialogOptions) (string, error) {
+	return s.nextPath()
+}
+
+func (s *cliSession) handleEvent(emitType WailsEmitType, data any) {
+	switch emitType {
+	case DB_UPLOAD_FAIL, DB_EXPORT_FAIL, IMPORT_DB_FAIL, OPEN_FOLDER_FAIL:
+		if msg, ok := data.(map[string]string); ok {
+			s.failure = msg["msg"]
+		} else {
+			s.failure = fmt.Sprint(data)
+		}
+	}
+}
+
+// runs a dialog-driven menu action with paths as the dialog answers
+func (s *cliSession) runDialogAction(action func(), paths ...string) error {
+	s.paths = paths
+	s.failure = ""
+	action()
+	if s.failure != "" {
+		return errors.New(s.failure)
+	}
+	return nil
+}
+
+func (s *cliSession) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(s.errOut)
+	fs.StringVar(&s.root, "root", "", "folder of databases to open instead of the app's own databases")
+	fs.BoolVar(&s.readOnly, "read-only", false, "refuse writes to every database")
+	fs.Usage = func() {
+		fmt.Fprintf(s.errOut, "usage: %s\n", cliUsage[name])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parses the flags of a command and opens the workspace it runs against
+func (s *cliSession) parse(fs *flag.FlagSet, args []string, nargs ...int) error {
+	if err := fs.Parse(args); err != nil {
+		return errCLIUsage
+	}
+	if len(nargs) > 0 && !slices.Contains(nargs, fs.NArg()) {
+		fs.Usage()
+		return errCLIUsage
+	}
+	if s.readOnly {
+		s.app.policy.setReadOnly(true)
+	}
+	if s.root == "" {
+		if s.app.rootPath == "main" {
+			return nil
+		}
+		return s.app.SetupMain().Err
+	}
+	root, err := filepath.Abs(s.root)
+	if err != nil {
+		return err
+	}
+	if s.app.rootPath == root {
+		// already open from an earlier command of this session
+		return nil
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", s.root)
+	}
+	s.app.rootPath = root
+	if err := s.app.attachMainDBs(); err != nil {
+		return err
+	}
+	return s.app.attachDBsFromFolder(root)
+}
+
+func (s *cliSession) printJSON(v any) error {
+	enc := json.NewEncoder(s.out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// aligns query results in columns for reading in a terminal
+type tableRowWriter struct {
+	tw   *tabwriter.Writer
+	opts CSVOptions
+	row  []string
+}
+
+func newTableRowWriter(w io.Writer) *tableRowWriter {
+	opts, _ := CSVOptions{Null: "NULL"}.normalize()
+	return &tableRowWriter{tw: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0), opts: opts}
+}
+
+func (t *tableRowWriter) writeCells(cells []string) error {
+	_, err := io.WriteString(t.tw, strings.Join(cells, "\t")+"\n")
+	return err
+}
+
+func (t *tableRowWriter) WriteHeader(cols []string) error {
+	rule := make([]string, len(cols))
+	for i, col := range cols {
+		rule[i] = strings.Repeat("-", max(len(col), 3))
+	}
+	if err := t.writeCells(cols); err != nil {
+		return err
+	}
+	return t.writeCells(rule)
+}
+
+func (t *tableRowWriter) WriteRow(values []any) error {
+	t.row = t.row[:0]
+	for _, v := range values {
+		text, _ := t.opts.formatValue(v)
+		text = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(text)
+		t.row = append(t.row, text)
+	}
+	return t.writeCells(t.row)
+}
+
+func (t *tableRowWriter) Close() error {
+	return t.tw.Flush()
+}
+
+func (s *cliSession) rowWriter(format string) (rowWriter, error) {
+	if format == CLI_FORMAT_TABLE {
+		return newTableRowWriter(s.out), nil
+	}
+	return newRowWriter(s.out, normalizeExportFormat(format), ExportOptions{Table: defaultExportTable})
+}
+
+func splitList(list string) []string {
+	var items []string
+	for item := range strings.SplitSeq(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func cliQuery(s *cliSession, args []string) error {
+	fs := s.flagSet("query")
+	format := fs.String("format", CLI_FORMAT_TABLE, "output format")
+	if err := s.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errCLIUsage
+	}
+	query := strings.Join(fs.Args(), " ")
+	if query == "-" {
+		body, err := io.ReadAll(s.in)
+		if err != nil {
+			return err
+		}
+		query = string(body)
+	}
+	query = cleanQuery(query)
+	stmts, err := s.app.classifyQuery(query)
+	if err != nil {
+		return err
+	}
+	if len(stmts) > 1 && readonlyStatements(stmts) {
+		// only one result set can be streamed
+		return fmt.Errorf("the query holds %d read statements, run them one at a time", len(stmts))
+	}
+	if len(stmts) != 1 || !stmts[0].Readonly {
+		res := s.app.Query(QueryRequest{Query: query})
+		if res.Err != nil {
+			return res.Err
+		}
+		if *format == CLI_FORMAT_JSON {
+			return s.printJSON(res.Results)
+		}
+		fmt.Fprintf(s.out, "%v rows affected\n", res.Results.(map[string]any)["rowsAffected"])
+		return nil
+	}
+	w, err := s.rowWriter(*format)
+	if err != nil {
+		return err
+	}
+	_, err = streamRows(s.app.db, query, w)
+	return err
+}
+
+func cliImport(s *cliSession, args []string) error {
+	fs := s.flagSet("import")
+	db := fs.String("db", "", "attached database to import into; a new database when empty")
+	table := fs.String("table", "", "table to import into")
+	mode := fs.String("mode", "", "append, replace or upsert into an existing table")
+	keys := fs.String("keys", "", "comma separated upsert key columns")
+	rejects := fs.String("rejects", "", "file receiving rows the table refuses")
+	nested := fs.String("nested", "", "how nested JSON is stored: text, flatten or tables")
+	recordPath := fs.String("record-path", "", "JSONPath of the records in a JSON file")
+	if err := s.parse(fs, args, 1); err != nil {
+		return err
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, ext := parseFile(path)
+	ext = normalizeExportFormat(strings.ToLower(ext))
+	var res AppResult
+	switch {
+	case s.app.scan.current().isDBFile(path):
+		return s.runDialogAction(s.app.importDB, path)
+	case ext == ".zip":
+		return s.runDialogAction(s.app.importBundleDB, path)
+	case *db != "" && *table != "" && (*mode != "" || func() bool {
+		_, err := tableColumns(s.app.db, tableRef{DB: *db, Table: *table})
+		return err == nil
+	}()):
+		res = s.app.ImportIntoTable(TableImportRequest{Path: path, DB: *db, Table: *table, Mode: *mode, Keys: splitList(*keys), RejectPath: *rejects})
+	case ext == FORMAT_CSV || ext == FORMAT_TSV:
+		res = s.app.ImportCSV(CSVImportRequest{Path: path, DB: *db, Table: *table})
+	case ext == FORMAT_JSON || ext == FORMAT_NDJSON:
+		res = s.app.ImportJSON(JSONImportRequest{Path: path, DB: *db, Table: *table, Options: JSONImportOptions{Nested: *nested, RecordPath: *recordPath}})
+	case ext == FORMAT_SQL:
+		res = s.app.RunSQLScript(SQLScriptRequest{Path: path, DB: *db})
+	case ext == FORMAT_PARQUET && *db != "":
+		res = s.app.ImportParquet(ParquetImportRequest{Path: path, DB: *db, Table: *table})
+	default:
+		return s.runDialogAction(s.app.uploadDB, path)
+	}
+	if res.Err != nil {
+		return res.Err
+	}
+	return s.printJSON(res.Results)
+}
+
+// maps an export format flag or file extension to the format exportDB takes
+func workspaceExportFormat(format string) (string, bool) {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "db", "sqlite":
+		return ".db", true
+	case "csv", "json", "parquet", "xlsx", "sql":
+		return "." + strings.TrimPrefix(strings.ToLower(format), "."), true
+	case "zip", "bundle":
+		return "", true
+	}
+	return "", false
+}
+
+func cliExport(s *cliSession, args []string) error {
+	fs := s.flagSet("export")
+	format := fs.String("format", "", "output format; taken from the file extension when empty")
+	db := fs.String("db", "", "database of the table to export")
+	table := fs.String("table", "", "table to export")
+	query := fs.String("query", "", "query whose result is exported")
+	if err := s.parse(fs, args, 1); err != nil {
+		return err
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		_, *format = parseFile(path)
+	}
+	var res AppResult
+	switch {
+	case *query != "":
+		res = s.app.ExportQueryResult(*query, *format, path)
+	case *table != "":
+		res = s.app.ExportTable(*db, *table, *format, path)
+	default:
+		exportFormat, ok := workspaceExportFormat(*format)
+		if !ok {
+			return fmt.Errorf("cannot export the workspace as %q", *format)
+		}
+		return s.runDialogAction(func() { s.app.exportDB(exportFormat) }, path)
+	}
+	return res.Err
+}
+
+func cliDump(s *cliSession, args []string) error {
+	fs := s.flagSet("dump")
+	db := fs.String("db", "", "database to dump; every database into a zip when empty")
+	schemaOnly := fs.Bool("schema-only", false, "dump only the schema")
+	dataOnly := fs.Bool("data-only", false, "dump only the rows")
+	if err := s.parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	opts := DumpOptions{SchemaOnly: *schemaOnly, DataOnly: *dataOnly}
+	if fs.NArg() == 0 {
+		if *db == "" {
+			return errors.New("dumping every database needs a zip file path")
+		}
+		return s.app.dumpDB(s.out, *db, opts)
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return s.app.ExportSQLDump(DumpRequest{DB: *db, Path: path, SchemaOnly: *schemaOnly, DataOnly: *dataOnly}).Err
+}
+
+func cliDiff(s *cliSession, args []string) error {
+	fs := s.flagSet("diff")
+	format := fs.String("format", CLI_FORMAT_TABLE, "output format: table or json")
+	data := fs.Bool("data", false, "compare the rows of two tables given as db.table")
+	keys := fs.String("keys", "", "comma separated columns matching rows; the primary key when empty")
+	limit := fs.Int("limit", 0, "most row differences to list")
+	if err := s.parse(fs, args, 2); err != nil {
+		return err
+	}
+	if !*data {
+		res := s.app.DiffSchemas(fs.Arg(0), fs.Arg(1))
+		if res.Err != nil {
+			return res.Err
+		}
+		diff := res.Results.(SchemaDiff)
+		if *format == CLI_FORMAT_JSON {
+			if err := s.printJSON(diff); err != nil {
+				return err
+			}
+		} else if diff.Empty() {
+			fmt.Fprintln(s.out, "schemas match")
+		} else {
+			fmt.Fprintln(s.out, strings.TrimRight(diff.Migration, "\n"))
+		}
+		if !diff.Empty() {
+			return errCLIFindings
+		}
+		return nil
+	}
+
+	res := s.app.DiffTableData(TableDiffRequest{From: fs.Arg(0), To: fs.Arg(1), Keys: splitList(*keys), Limit: *limit})
+	if res.Err != nil {
+		return res.Err
+	}
+	diff := res.Results.(TableDataDiff)
+	if *format == CLI_FORMAT_JSON {
+		if err := s.printJSON(diff); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(s.out, "%d inserted, %d deleted, %d changed\n", diff.Inserted, diff.Deleted, diff.Changed)
+		for _, row := range diff.Rows {
+			key, _ := json.Marshal(row.Key)
+			fmt.Fprintf(s.out, "%s\t%s\n", row.Kind, key)
+		}
+	}
+	if diff.Inserted+diff.Deleted+diff.Changed > 0 {
+		return errCLIFindings
+	}
+	return nil
+}
+
+// copies an attached database to a new file at path with VACUUM INTO, which
+// is consistent even while the database is in use
+func (a *App) backupDB(dbName string, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	_, err := a.execTrusted(fmt.Sprintf("VACUUM %s INTO ?;", quoteIdent(dbName)), path)
+	return err
+}
+
+func cliBackup(s *cliSession, args []string) error {
+	fs := s.flagSet("backup")
+	db := fs.String("db", "", "database to back up; every database when empty")
+	if err := s.parse(fs, args, 1); err != nil {
+		return err
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *db != "" {
+		return s.app.backupDB(*db, path)
+	}
+	names, err := s.app.getSQLiteDBNames()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, SafePermissions); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.app.backupDB(name, filepath.Join(path, name+".db")); err != nil {
+			return fmt.Errorf("backing up %s: %w", name, err)
+		}
+		fmt.Fprintln(s.out, filepath.Join(path, name+".db"))
+	}
+	return nil
+}
+
+type DBCheck struct {
+	DB          string   `json:"db"`
+	Integrity   []string `json:"integrity"`
+	ForeignKeys []string `json:"foreignKeys"`
+}
+
+func (c DBCheck) OK() bool {
+	return len(c.ForeignKeys) == 0 && slices.Equal(c.Integrity, []string{"ok"})
+}
+
+// runs integrity_check and foreign_key_check against an attached database
+func (a *App) checkDB(dbName string) (DBCheck, error) {
+	check := DBCheck{DB: dbName, ForeignKeys: []string{}}
+	if err := a.db.Select(&check.Integrity, fmt.Sprintf("PRAGMA %s.integrity_check;", quoteIdent(dbName))); err != nil {
+		return check, err
+	}
+	type fkViolation struct {
+		Table  string `db:"table"`
+		RowID  *int64 `db:"rowid"`
+		Parent string `db:"parent"`
+		FKID   int    `db:"fkid"`
+	}
+	var violations []fkViolation
+	if err := a.db.Select(&violations, fmt.Sprintf("PRAGMA %s.foreign_key_check;", quoteIdent(dbName))); err != nil {
+		return check, err
+	}
+	for _, v := range violations {
+		row := "?"
+		if v.RowID != nil {
+			row = fmt.Sprint(*v.RowID)
+		}
+		check.ForeignKeys = append(check.ForeignKeys, fmt.Sprintf("%s row %s references missing %s row", v.Table, row, v.Parent))
+	}
+	return check, nil
+}
+
+func cliCheck(s *cliSession, args []string) error {
+	fs := s.flagSet("check")
+	format := fs.String("format", CLI_FORMAT_TABLE, "output format: table or json")
+	db := fs.String("db", "", "database to check; every database when empty")
+	if err := s.parse(fs, args, 0); err != nil {
+		return err
+	}
+	names := []string{*db}
+	if *db == "" {
+		var err error
+		if names, err = s.app.getSQLiteDBNames(); err != nil {
+			return err
+		}
+	}
+	checks := make([]DBCheck, 0, len(names))
+	failed := false
+	for _, name := range names {
+		check, err := s.app.checkDB(name)
+		if err != nil {
+			return fmt.Errorf("checking %s: %w", name, err)
+		}
+		failed = failed || !check.OK()
+		checks = append(checks, check)
+	}
+	if *format == CLI_FORMAT_JSON {
+		if err := s.printJSON(checks); err != nil {
+			return err
+		}
+	} else {
+		for _, check := range checks {
+			if check.OK() {
+				fmt.Fprintf(s.out, "%s: ok\n", check.DB)
+				continue
+			}
+			for _, problem := range slices.Concat(check.Integrity, check.ForeignKeys) {
+				if problem != "ok" {
+					fmt.Fprintf(s.out, "%s: %s\n", check.DB, problem)
+				}
+			}
+		}
+	}
+	if failed {
+		return errCLIFindings
+	}
+	return nil
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\nFlags go before the arguments. Every command takes -root FOLDER to work on a folder of databases instead of the app's own, and -read-only to refuse writes.\n\n", filepath.Base(os.Args[0]))
+	names := slices.Sorted(func(yield func(string) bool) {
+		for name := range cliCommands {
+			if !yield(name) {
+				return
+			}
+		}
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, cliCommands[name].summary)
+	}
+	tw.Flush()
+}
+
+// runs a headless command against the app's metadata database and returns
+// the process exit status
+func runCLI(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCLIUsage(stdout)
+		return 0
+	}
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		printCLIUsage(stderr)
+		return cliExitUsage
+	}
+	s := &cliSession{in: stdin, out: stdout, errOut: stderr}
+	s.app = NewApp(&CustomAppConfig{
+		Logger:        slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+		DialogService: s,
+		EventHandler:  s.handleEvent,
+	})
+	s.app.startup(context.Background())
+	defer s.app.shutdown(s.app.ctx)
+	return s.exitCode(cmd.run(s, args[1:]))
+}
+
+func (s *cliSession) exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errCLIUsage):
+		return cliExitUsage
+	case errors.Is(err, errCLIFindings):
+		return cliExitFailure
+	}
+	fmt.Fprintf(s.errOut, "error: %v\n", err)
+	return cliExitFailure
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// runs a headless command against test_app with root as the open folder
+func runTestCLI(t *testing.T, root string, args ...string) (int, string, string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	s := &cliSession{app: test_app, out: &out, errOut: &errOut}
+	prevDialog, prevEvent := test_app.dialog, test_app.onEvent
+	test_app.dialog, test_app.onEvent = s, s.handleEvent
+	defer func() {
+		test_app.dialog, test_app.onEvent = prevDialog, prevEvent
+	}()
+	cmd := cliCommands[args[0]]
+	code := s.exitCode(cmd.run(s, append([]string{"-root", root}, args[1:]...)))
+	return code, out.String(), errOut.String()
+}
+
+func TestCLI(t *testing.T) {
+	root := t.TempDir()
+	for name, script := range map[string]string{
+		"shop": `CREATE TABLE items (id INTEGER PRIMARY KEY, sku TEXT NOT NULL, qty INTEGER);
+			INSERT INTO items VALUES (1, 'a', 1), (2, 'b', NULL);`,
+		"shop_copy": `CREATE TABLE items (id INTEGER PRIMARY KEY, sku TEXT NOT NULL);
+			INSERT INTO items VALUES (1, 'a'), (3, 'c');`,
+	} {
+		db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, name+".db"))
+		db.MustExec(script)
+		db.Close()
+	}
+	prevRoot := test_app.rootPath
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+
+	t.Run("QueryTable", func(t *testing.T) {
+		code, out, errOut := runTestCLI(t, root, "query", "SELECT id, qty FROM shop.items ORDER BY id")
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 4 || strings.Fields(lines[0])[1] != "qty" || strings.Fields(lines[3])[1] != "NULL" {
+			t.Errorf("unexpected table output:\n%s", out)
+		}
+	})
+
+	t.Run("QueryJSON", func(t *testing.T) {
+		code, out, errOut := runTestCLI(t, root, "query", "-format", "json", "SELECT sku FROM shop.items ORDER BY id")
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		var rows []map[string]any
+		if err := json.Unmarshal([]byte(out), &rows); err != nil || len(rows) != 2 || rows[1]["sku"] != "b" {
+			t.Errorf("unexpected JSON output %q: %v", out, err)
+		}
+	})
+
+	t.Run("QueryStatements", func(t *testing.T) {
+		code, out, errOut := runTestCLI(t, root, "query", "SELECT 1; SELECT 2;")
+		if code == 0 || strings.Contains(out, "rows affected") || !strings.Contains(errOut, "one at a time") {
+			t.Errorf("multiple reads exit %d, output %q, %q", code, out, errOut)
+		}
+		code, out, errOut = runTestCLI(t, root, "query", "UPDATE shop.items SET qty = 2 WHERE id = 2")
+		if code != 0 || out != "1 rows affected\n" {
+			t.Errorf("write exit %d, output %q, %q", code, out, errOut)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "items.csv")
+		if code, _, errOut := runTestCLI(t, root, "export", "-db", "shop", "-table", "items", path); code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		body, err := os.ReadFile(path)
+		if err != nil || !strings.HasPrefix(string(body), "id,sku,qty") {
+			t.Errorf("unexpected export %q: %v", body, err)
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "more.csv")
+		os.WriteFile(path, []byte("id,sku,qty\n4,d,7\n"), 0644)
+		code, _, errOut := runTestCLI(t, root, "import", "-db", "shop", "-table", "items", "-mode", IMPORT_APPEND, path)
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		var count int
+		test_app.db.Get(&count, "SELECT COUNT(*) FROM shop.items;")
+		if count != 3 {
+			t.Errorf("got %d rows after import, want 3", count)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		code, out, _ := runTestCLI(t, root, "diff", "shop", "shop_copy")
+		if code != cliExitFailure || !strings.Contains(out, `CREATE TABLE "_new_items"`) {
+			t.Errorf("schema diff exit %d, output %q", code, out)
+		}
+		code, out, _ = runTestCLI(t, root, "diff", "-data", "-keys", "id", "shop_copy.items", "shop_copy.items")
+		if code != 0 || !strings.HasPrefix(out, "0 inserted, 0 deleted, 0 changed") {
+			t.Errorf("data diff exit %d, output %q", code, out)
+		}
+	})
+
+	t.Run("Dump", func(t *testing.T) {
+		code, out, errOut := runTestCLI(t, root, "dump", "-db", "shop_copy", "-data-only")
+		if code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		if !strings.Contains(out, "INSERT INTO") || strings.Contains(out, "CREATE TABLE") {
+			t.Errorf("unexpected dump:\n%s", out)
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "backups")
+		if code, _, errOut := runTestCLI(t, root, "backup", dir); code != 0 {
+			t.Fatalf("exit %d: %s", code, errOut)
+		}
+		db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(dir, "shop_copy.db"))
+		defer db.Close()
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM items;"); err != nil || count != 2 {
+			t.Errorf("backup has %d rows (%v), want 2", count, err)
+		}
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		code, out, errOut := runTestCLI(t, root, "check", "-db", "shop")
+		if code != 0 || strings.TrimSpace(out) != "shop: ok" {
+			t.Errorf("check exit %d, output %q %s", code, out, errOut)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		if code, _, _ := runTestCLI(t, root, "diff", "shop"); code != cliExitUsage {
+			t.Errorf("diff with one database exited %d, want %d", code, cliExitUsage)
+		}
+		var out bytes.Buffer
+		if code := runCLI([]string{"nope"}, strings.NewReader(""), &out, &out); code != cliExitUsage || !strings.Contains(out.String(), "unknown command") {
+			t.Errorf("unknown command exited %d: %s", code, out.String())
+		}
+	})
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite attaches at most SQLITE_LIMIT_ATTACHED databases (10 by default) to
+// one connection, so the databases of a folder are registered rather than
+// attached up front. Each connection of the app attaches the registered
+// databases a statement names just before it runs and detaches the least
+// recently used ones to make room. Free slots go to the other registered
+// databases, so unqualified names find them as long as they all fit.
+// Reading a single database goes through a read-only pool of its own instead.
+
+// the most single-database pools kept open at once
+const maxDBPools = 16
+
+// a database the app's connections can attach
+type registeredDB struct {
+	Name     string
+	Path     string
+	ReadOnly bool
+	// changes whenever the entry does, so connections reattach it
+	version int
+	// the file found at Path when it was registered, nil if there was none
+	file os.FileInfo
+}
+
+type dbPool struct {
+	db      *sqlx.DB
+	version int
+	lastUse int
+}
+
+// every database stored for the current root, by lower-case name
+type dbRegistry struct {
+	mu  sync.Mutex
+	dbs map[string]registeredDB
+	// stored databases whose file was not found; attaching them would
+	// create an empty one
+	missing map[string]registeredDB
+	// the read-only flags of pruned databases by path, so a file copied or
+	// moved back in its place gets the flag again
+	pruned  map[string]bool
+	pools   map[string]*dbPool
+	version int
+	clock   int
+}
+
+func (r *dbRegistry) register(name string, path string, readOnly bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dbs == nil {
+		r.dbs = map[string]registeredDB{}
+	}
+	r.version++
+	delete(r.missing, strings.ToLower(name))
+	file, _ := os.Stat(path)
+	// its pool may be open on the file the entry had before
+	r.closePool(strings.ToLower(name))
+	r.dbs[strings.ToLower(name)] = registeredDB{Name: name, Path: path, ReadOnly: readOnly, version: r.version, file: file}
+}
+
+// reports whether another file has taken the place of the registered one
+func (db registeredDB) replaced() bool {
+	file, err := os.Stat(db.Path)
+	return err == nil && db.file != nil && !os.SameFile(db.file, file)
+}
+
+// remembers the read-only flag of a database pruned from path
+func (r *dbRegistry) prune(path string, readOnly bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pruned == nil {
+		r.pruned = map[string]bool{}
+	}
+	r.pruned[path] = readOnly
+}
+
+// reports whether the database last pruned from path was read-only
+func (r *dbRegistry) prunedReadOnly(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pruned[path]
+}
+
+// remembers a stored database whose file is gone, until it comes back
+func (r *dbRegistry) markMissing(name string, path string, readOnly bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing == nil {
+		r.missing = map[string]registeredDB{}
+	}
+	r.missing[strings.ToLower(name)] = registeredDB{Name: name, Path: path, ReadOnly: readOnly}
+}
+
+func (r *dbRegistry) isMissing(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.missing[strings.ToLower(name)]
+	return ok
+}
+
+// returns the missing database stored at path
+func (r *dbRegistry) missingAt(path string) (registeredDB, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, db := range r.missing {
+		if db.Path == path {
+			return db, true
+		}
+	}
+	return registeredDB{}, false
+}
+
+// returns the registered databases whose file is path or lies inside it
+func (r *dbRegistry) under(path string) []registeredDB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dbs []registeredDB
+	for _, db := range r.dbs {
+		if db.Path == path || strings.HasPrefix(db.Path, path+string(filepath.Separator)) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+func (r *dbRegistry) unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dbs, strings.ToLower(name))
+	r.closePool(strings.ToLower(name))
+}
+
+func (r *dbRegistry) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dbs = nil
+	r.missing = nil
+	r.pruned = nil
+	for key := range r.pools {
+		r.closePool(key)
+	}
+}
+
+func (r *dbRegistry) lookup(name string) (registeredDB, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	db, ok := r.dbs[strings.ToLower(name)]
+	return db, ok
+}
+
+// returns every registered database, by name
+func (r *dbRegistry) all() []registeredDB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dbs := make([]registeredDB, 0, len(r.dbs))
+	for _, db := range r.dbs {
+		dbs = append(dbs, db)
+	}
+	slices.SortFunc(dbs, func(a, b registeredDB) int { return strings.Compare(a.Name, b.Name) })
+	return dbs
+}
+
+func (r *dbRegistry) byPath(path string) (registeredDB, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, db := range r.dbs {
+		if db.Path == path {
+			return db, true
+		}
+	}
+	return registeredDB{}, false
+}
+
+// returns the registered databases among names, without duplicates
+func (r *dbRegistry) resolve(names []string) []registeredDB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dbs []registeredDB
+	for _, name := range names {
+		db, ok := r.dbs[strings.ToLower(name)]
+		if ok && !slices.ContainsFunc(dbs, func(d registeredDB) bool { return d.Name == db.Name }) {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// returns a read-only pool on the file of a registered database, closing the
+// least recently used idle pool when too many are open
+func (r *dbRegistry) pool(name string) (*sqlx.DB, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(name)
+	db, ok := r.dbs[key]
+	if !ok {
+		return nil, fmt.Errorf("database %s is not stored", name)
+	}
+	r.clock++
+	if p, ok := r.pools[key]; ok && p.version == db.version {
+		p.lastUse = r.clock
+		return p.db, nil
+	}
+	r.closePool(key)
+	for len(r.pools) >= maxDBPools {
+		oldest := ""
+		for k, p := range r.pools {
+			if p.db.Stats().InUse == 0 && (oldest == "" || p.lastUse < r.pools[oldest].lastUse) {
+				oldest = k
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		r.closePool(oldest)
+	}
+	pool, err := sqlx.Open(SQLITE_DRIVER, readOnlyURI(db.Path))
+	if err != nil {
+		return nil, err
+	}
+	if r.pools == nil {
+		r.pools = map[string]*dbPool{}
+	}
+	r.pools[key] = &dbPool{db: pool, version: db.version, lastUse: r.clock}
+	return pool, nil
+}
+
+// closes the pool of key, if open; callers hold r.mu
+func (r *dbRegistry) closePool(key string) {
+	if p, ok := r.pools[key]; ok {
+		p.db.Close()
+		delete(r.pools, key)
+	}
+}
+
+// a connection of the app that attaches registered databases on demand
+type managedConn struct {
+	*sqlite3.SQLiteConn
+	app *App
+	// the registered database opened as main, empty for the app's own
+	schema string
+	// the databases this connection attached, least recently used first
+	attached []registeredDB
+	// set while the connection runs one of the app's own statements, which
+	// the attach rule does not cover
+	trusted bool
+	// the rule the authorizer last refused a statement for
+	violation *PolicyError
+	// set by the authorizer when a statement compiled since it was cleared
+	// changes the state of the connection
+	changedConn bool
+	// set while classifyQuery compiles statements it does not run
+	classifying bool
+}
+
+func (c *managedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *managedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.attachFor(ctx, query); err != nil {
+		return nil, err
+	}
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	return stmt, c.explain(err)
+}
+
+func (c *managedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.attachFor(ctx, query); err != nil {
+		return nil, err
+	}
+	res, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	return res, c.explain(err)
+}
+
+func (c *managedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.attachFor(ctx, query); err != nil {
+		return nil, err
+	}
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	return rows, c.explain(err)
+}
+
+func (c *managedConn) attachFor(ctx context.Context, query string) error {
+	if c.schema != "" {
+		// a registered database's own connection sees that database alone
+		return nil
+	}
+	return c.ensure(ctx, c.app.registry.resolve(schemaNames(query)))
+}
+
+func (c *managedConn) index(name string) int {
+	return slices.IndexFunc(c.attached, func(db registeredDB) bool { return strings.EqualFold(db.Name, name) })
+}
+
+// detaches databases that were removed or changed since this connection
+// attached them, then attaches every one of wanted and fills the free slots
+func (c *managedConn) ensure(ctx context.Context, wanted []registeredDB) error {
+	if !c.AutoCommit() {
+		// ATTACH and DETACH are refused inside a transaction
+		for _, db := range wanted {
+			if c.index(db.Name) < 0 {
+				return fmt.Errorf("database %s must be attached before the transaction begins", db.Name)
+			}
+		}
+		return nil
+	}
+	var kept []registeredDB
+	for i, db := range c.attached {
+		if current, ok := c.app.registry.lookup(db.Name); ok && current.version == db.version {
+			kept = append(kept, db)
+		} else if err := c.detach(ctx, db.Name); err != nil {
+			c.attached = append(kept, c.attached[i:]...)
+			return err
+		}
+	}
+	c.attached = kept
+
+	limit := c.GetLimit(sqlite3.SQLITE_LIMIT_ATTACHED)
+	if len(wanted) > limit {
+		return fmt.Errorf("a statement can use at most %d attached databases, this one names %d", limit, len(wanted))
+	}
+	for _, db := range wanted {
+		if i := c.index(db.Name); i >= 0 {
+			// the most recently used go last
+			c.attached = append(slices.Delete(c.attached, i, i+1), db)
+			continue
+		}
+		for len(c.attached) >= limit {
+			i := slices.IndexFunc(c.attached, func(attached registeredDB) bool {
+				return !slices.ContainsFunc(wanted, func(w registeredDB) bool { return w.Name == attached.Name })
+			})
+			if err := c.detach(ctx, c.attached[i].Name); err != nil {
+				return err
+			}
+			c.attached = slices.Delete(c.attached, i, i+1)
+		}
+		if err := c.exec(ctx, attachStatement(db.Path, db.Name, db.ReadOnly)); err != nil {
+			return err
+		}
+		c.attached = append(c.attached, db)
+	}
+	c.fill(ctx, limit)
+	return nil
+}
+
+// attaches registered databases into the free slots of the connection, so
+// unqualified names resolve against them. They count as the least recently
+// used, so they are the first to make room.
+func (c *managedConn) fill(ctx context.Context, limit int) {
+	if len(c.attached) >= limit {
+		return
+	}
+	var filled []registeredDB
+	for _, db := range c.app.registry.all() {
+		if len(c.attached)+len(filled) >= limit {
+			break
+		}
+		if c.index(db.Name) >= 0 {
+			continue
+		}
+		if err := c.exec(ctx, attachStatement(db.Path, db.Name, db.ReadOnly)); err != nil {
+			// statements naming it get the error
+			continue
+		}
+		filled = append(filled, db)
+	}
+	c.attached = append(filled, c.attached...)
+}
+
+func (c *managedConn) detach(ctx context.Context, name string) error {
+	err := c.exec(ctx, fmt.Sprintf("DETACH DATABASE %s;", quoteIdent(name)))
+	if err != nil && strings.HasPrefix(err.Error(), "no such database") {
+		// a statement detached it already
+		return nil
+	}
+	return err
+}
+
+// runs one of the connection's own ATTACH or DETACH statements
+func (c *managedConn) exec(ctx context.Context, query string) error {
+	trusted := c.trusted
+	c.trusted = true
+	defer func() { c.trusted = trusted }()
+	_, err := c.SQLiteConn.ExecContext(ctx, query, nil)
+	return c.explain(err)
+}
+
+// runs fn with the managedConn behind conn
+func withManagedConn(conn *sql.Conn, fn func(c *managedConn) error) error {
+	return conn.Raw(func(driverConn any) error {
+		managed, ok := driverConn.(*managedConn)
+		if !ok {
+			return fmt.Errorf("unexpected connection type %T", driverConn)
+		}
+		return fn(managed)
+	})
+}
+
+// returns a connection of the app with names attached. Transactions need
+// one, as databases cannot be attached once they have begun. Close it after
+// use.
+func (a *App) connWith(ctx context.Context, names ...string) (*sqlx.Conn, error) {
+	conn, err := a.db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = withManagedConn(conn.Conn, func(c *managedConn) error {
+		return c.ensure(ctx, a.registry.resolve(names))
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// closes the app's idle connections so they let go of databases that were
+// removed; busy ones detach them before their next statement
+func (a *App) releaseDetached() {
+	a.db.SetMaxIdleConns(0)
+	a.db.SetMaxIdleConns(2)
+}
+
+// returns the table-valued pragma fn of schema, taking its first argument
+// as a parameter. SQLite ignores a qualifier on pragma functions, so schema
+// is passed as their last argument as well; the qualifier is what tells
+// connections to attach it.
+func schemaPragma(schema string, fn string) string {
+	if schema == "" {
+		return fn + "(?)"
+	}
+	return fmt.Sprintf("%s(?, %s)", quoteQualified(schema, fn), sqlLiteral(schema))
+}
+
+// the BeginTxx of *sqlx.DB and *sqlx.Conn
+type txBeginner interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// returns the index just past the quoted section opened at query[start]
+func skipQuoted(query string, start int) int {
+	closing := closingQuote(query[start])
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != closing {
+			continue
+		}
+		if closing != ']' && i+1 < len(query) && query[i+1] == closing {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(query)
+}
+
+// returns the names query uses as a schema: those qualifying another name,
+// as db in db.table or "my db".sqlite_master, and the one VACUUM targets.
+// Literals and comments are skipped. Table aliases come back too; they only
+// matter when they match a registered database.
+func schemaNames(query string) []string {
+	var names []string
+	var prev string
+	for i := 0; i < len(query); {
+		c := query[i]
+		var name string
+		switch {
+		case c == '\'':
+			i = skipQuoted(query, i)
+			prev = ""
+			continue
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return names
+			}
+			i += end + 1
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return names
+			}
+			i += end + 4
+			continue
+		case c == '"' || c == '`' || c == '[':
+			end := skipQuoted(query, i)
+			closing := string(closingQuote(c))
+			name = strings.ReplaceAll(query[i+1:max(end-1, i+1)], closing+closing, closing)
+			i = end
+		case isIdentByte(c):
+			start := i
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			name = query[start:i]
+			if unicode.IsDigit(rune(name[0])) {
+				// a number, such as 1.5
+				prev = ""
+				continue
+			}
+		default:
+			if !unicode.IsSpace(rune(c)) {
+				prev = ""
+			}
+			i++
+			continue
+		}
+		next := i
+		for next < len(query) && unicode.IsSpace(rune(query[next])) {
+			next++
+		}
+		if (next < len(query) && query[next] == '.') || strings.EqualFold(prev, "VACUUM") {
+			names = append(names, name)
+		}
+		prev = name
+	}
+	return names
+}
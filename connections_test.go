@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSchemaNames(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT * FROM t", nil},
+		{"SELECT * FROM a.t JOIN b . u ON a.t.id = u.id", []string{"a", "b", "a", "t", "u"}},
+		{`SELECT * FROM "my db".t, [x].t, ` + "`y`.t", []string{"my db", "x", "y"}},
+		{`SELECT "a""b".c`, []string{`a"b`}},
+		{"SELECT 'a.b', 1.5 -- c.d\n/* e.f */ FROM g.h", []string{"g"}},
+		{`VACUUM "arch" INTO 'x.db'`, []string{"arch"}},
+	}
+	for _, tt := range tests {
+		if got := schemaNames(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("schemaNames(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestManyDatabases(t *testing.T) {
+	const count = 30
+	root := t.TempDir()
+	for i := range count {
+		db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, fmt.Sprintf("month%02d.db", i)))
+		db.MustExec("CREATE TABLE sales (id INTEGER PRIMARY KEY, amount INTEGER); INSERT INTO sales (amount) VALUES (?);", i)
+		db.Close()
+	}
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	res := test_app.GetNavData()
+	if res.Err != nil || reflect.ValueOf(res.Results).Len() != count+1 {
+		t.Fatalf("GetNavData() listed %d databases, want %d: %v", reflect.ValueOf(res.Results).Len(), count+1, res.Err)
+	}
+
+	// every database is reachable, with no more attached at once than SQLite allows
+	conn, err := test_app.db.Connx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := range count {
+		var amount int
+		if err := conn.GetContext(context.Background(), &amount, fmt.Sprintf("SELECT amount FROM month%02d.sales;", i)); err != nil || amount != i {
+			t.Errorf("month%02d: got %d, %v", i, amount, err)
+		}
+	}
+	var attached int
+	conn.GetContext(context.Background(), &attached, "SELECT COUNT(*) FROM pragma_database_list WHERE name NOT IN ('main', 'temp');")
+	if attached == 0 || attached > 10 {
+		t.Errorf("%d databases attached at once", attached)
+	}
+	var total int
+	if err := conn.GetContext(context.Background(), &total, "SELECT a.amount + b.amount + c.amount FROM month01.sales a, month15.sales b, month29.sales c;"); err != nil || total != 45 {
+		t.Errorf("cross-database query = %d, %v", total, err)
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		// conn attached month03 earlier but has evicted it since
+		tx, err := conn.BeginTxx(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("UPDATE month03.sales SET amount = 0;"); err == nil || !strings.Contains(err.Error(), "before the transaction begins") {
+			t.Errorf("update in a plain transaction: %v", err)
+		}
+		tx.Rollback()
+
+		txConn, err := test_app.connWith(context.Background(), "month03", "month04")
+		if err != nil {
+			t.Fatalf("connWith() error = %v", err)
+		}
+		defer txConn.Close()
+		tx, err = txConn.BeginTxx(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("UPDATE month03.sales SET amount = (SELECT amount FROM month04.sales);"); err != nil {
+			t.Errorf("update with attached databases: %v", err)
+		}
+	})
+
+	t.Run("Reattach", func(t *testing.T) {
+		if res := test_app.SetDBReadOnly(ReadOnlyRequest{DB: "month05", ReadOnly: true}); res.Err != nil {
+			t.Fatalf("SetDBReadOnly() error = %v", res.Err)
+		}
+		if _, err := conn.ExecContext(context.Background(), "DELETE FROM month05.sales;"); err == nil {
+			t.Error("deleted from a read-only database")
+		}
+		test_app.registry.unregister("month06")
+		if _, err := conn.ExecContext(context.Background(), "SELECT * FROM month06.sales;"); err == nil {
+			t.Error("queried a removed database")
+		}
+	})
+}
+
+func TestUnqualifiedNames(t *testing.T) {
+	app := NewApp(&CustomAppConfig{Logger: NewSLogger()})
+	root := t.TempDir()
+	for _, name := range []string{"shop", "crm"} {
+		path := filepath.Join(root, name+".db")
+		db := sqlx.MustOpen(SQLITE_DRIVER, path)
+		db.MustExec(fmt.Sprintf("CREATE TABLE %s_rows (id INTEGER PRIMARY KEY); INSERT INTO %[1]s_rows DEFAULT VALUES;", name))
+		db.Close()
+		app.registry.register(name, path, false)
+	}
+	app.db = app.openAuthorizedDB(":memory:")
+	defer app.db.Close()
+
+	// a fresh connection has attached nothing when the statement arrives
+	conn, err := app.db.Connx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var count int
+	if err := conn.GetContext(context.Background(), &count, "SELECT COUNT(*) FROM shop_rows JOIN crm_rows USING (id);"); err != nil || count != 1 {
+		t.Errorf("unqualified query on a fresh connection = %d, %v", count, err)
+	}
+	if cols, err := app.getColumns("", "crm_rows"); err != nil || !slices.Equal(cols, []string{"id"}) {
+		t.Errorf("getColumns() = %v, %v", cols, err)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	QUOTE_MINIMAL    = "minimal"
+	QUOTE_ALL        = "all"
+	QUOTE_NONNUMERIC = "nonnumeric"
+	QUOTE_NONE       = "none"
+
+	LINE_ENDING_LF   = "lf"
+	LINE_ENDING_CRLF = "crlf"
+
+	BLOB_HEX    = "hex"
+	BLOB_BASE64 = "base64"
+	BLOB_SKIP   = "skip"
+
+	DEFAULT_CSV_PRESET = "default"
+
+	utf8BOM = "\xEF\xBB\xBF"
+)
+
+// CSVOptions describes the dialect of an exported CSV file. The zero value
+// gives comma separated, minimally quoted output with LF line endings.
+type CSVOptions struct {
+	Delimiter  string `json:"delimiter"`
+	Quote      string `json:"quote"`
+	LineEnding string `json:"lineEnding"`
+	SkipHeader bool   `json:"skipHeader"`
+	// written in place of NULL values
+	Null string `json:"null"`
+	Blob string `json:"blob"`
+	// Go time layout for DATETIME/TIMESTAMP values, SQLite's own format when empty
+	DateFormat string `json:"dateFormat"`
+	BOM        bool   `json:"bom"`
+}
+
+type CSVPreset struct {
+	Name    string     `json:"name"`
+	Options CSVOptions `json:"options"`
+	BuiltIn bool       `json:"builtIn"`
+}
+
+var builtInCSVPresets = []CSVPreset{
+	{Name: DEFAULT_CSV_PRESET, BuiltIn: true},
+	{Name: "excel", BuiltIn: true, Options: CSVOptions{LineEnding: LINE_ENDING_CRLF, DateFormat: "2006-01-02 15:04:05", BOM: true}},
+	{Name: "excel-semicolon", BuiltIn: true, Options: CSVOptions{Delimiter: ";", LineEnding: LINE_ENDING_CRLF, DateFormat: "2006-01-02 15:04:05", BOM: true}},
+	{Name: "tsv", BuiltIn: true, Options: CSVOptions{Delimiter: "\t"}},
+}
+
+// fills in defaults and rejects options the writer cannot honour
+func (o CSVOptions) normalize() (CSVOptions, error) {
+	if o.Delimiter == "" {
+		o.Delimiter = ","
+	}
+	if utf8.RuneCountInString(o.Delimiter) != 1 || strings.ContainsAny(o.Delimiter, "\"\r\n") {
+		return o, fmt.Errorf("invalid delimiter %q", o.Delimiter)
+	}
+	if o.Quote == "" {
+		o.Quote = QUOTE_MINIMAL
+	}
+	if !slices.Contains([]string{QUOTE_MINIMAL, QUOTE_ALL, QUOTE_NONNUMERIC, QUOTE_NONE}, o.Quote) {
+		return o, fmt.Errorf("unknown quoting style %q", o.Quote)
+	}
+	if o.LineEnding == "" {
+		o.LineEnding = LINE_ENDING_LF
+	}
+	if o.LineEnding != LINE_ENDING_LF && o.LineEnding != LINE_ENDING_CRLF {
+		return o, fmt.Errorf("unknown line ending %q", o.LineEnding)
+	}
+	if o.Blob == "" {
+		o.Blob = BLOB_HEX
+	}
+	if !slices.Contains([]string{BLOB_HEX, BLOB_BASE64, BLOB_SKIP}, o.Blob) {
+		return o, fmt.Errorf("unknown blob encoding %q", o.Blob)
+	}
+	if o.DateFormat == "" {
+		o.DateFormat = sqlite3.SQLiteTimestampFormats[0]
+	}
+	return o, nil
+}
+
+// formats a scanned value as CSV text; numeric reports whether it is a number
+// for QUOTE_NONNUMERIC
+func (o CSVOptions) formatValue(value any) (text string, numeric bool) {
+	switch v := value.(type) {
+	case nil:
+		return o.Null, false
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case float64:
+		if math.Abs(v) < 1e21 {
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	case []byte:
+		switch o.Blob {
+		case BLOB_BASE64:
+			return base64.StdEncoding.EncodeToString(v), false
+		case BLOB_SKIP:
+			return "", false
+		}
+		return hex.EncodeToString(v), false
+	case string:
+		return v, false
+	case time.Time:
+		return v.Format(o.DateFormat), false
+	}
+	return fmt.Sprint(value), false
+}
+
+type csvRowWriter struct {
+	w       *bufio.Writer
+	opts    CSVOptions
+	eol     string
+	special string
+}
+
+func newCSVRowWriter(w io.Writer, opts CSVOptions) (*csvRowWriter, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	eol := "\n"
+	if opts.LineEnding == LINE_ENDING_CRLF {
+		eol = "\r\n"
+	}
+	return &csvRowWriter{
+		w:       bufio.NewWriter(w),
+		opts:    opts,
+		eol:     eol,
+		special: opts.Delimiter + "\"\r\n",
+	}, nil
+}
+
+func (c *csvRowWriter) writeField(i int, field string, quote bool) error {
+	if i > 0 {
+		c.w.WriteString(c.opts.Delimiter)
+	}
+	needsQuote := strings.ContainsAny(field, c.special)
+	switch c.opts.Quote {
+	case QUOTE_ALL:
+		quote = true
+	case QUOTE_NONE:
+		if needsQuote {
+			return fmt.Errorf("field %q needs quoting but quoting is disabled", field)
+		}
+		quote = false
+	default:
+		quote = quote || needsQuote || strings.HasPrefix(field, " ") || strings.HasPrefix(field, "\t")
+	}
+	if !quote {
+		_, err := c.w.WriteString(field)
+		return err
+	}
+	c.w.WriteByte('"')
+	c.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+	return c.w.WriteByte('"')
+}
+
+func (c *csvRowWriter) WriteHeader(cols []string) error {
+	if c.opts.BOM {
+		c.w.WriteString(utf8BOM)
+	}
+	if c.opts.SkipHeader {
+		return nil
+	}
+	for i, col := range cols {
+		if err := c.writeField(i, col, c.opts.Quote == QUOTE_NONNUMERIC); err != nil {
+			return err
+		}
+	}
+	_, err := c.w.WriteString(c.eol)
+	return err
+}
+
+func (c *csvRowWriter) WriteRow(values []any) error {
+	for i, v := range values {
+		text, numeric := c.opts.formatValue(v)
+		// NULL stays unquoted so it can be told apart from an empty string
+		quote := c.opts.Quote == QUOTE_NONNUMERIC && !numeric && v != nil
+		if err := c.writeField(i, text, quote); err != nil {
+			return err
+		}
+	}
+	_, err := c.w.WriteString(c.eol)
+	return err
+}
+
+func (c *csvRowWriter) Close() error {
+	return c.w.Flush()
+}
+
+// returns the built-in presets followed by the ones saved by the user
+func (a *App) getCSVPresets() ([]CSVPreset, error) {
+	presets := slices.Clone(builtInCSVPresets)
+	type savedPreset struct {
+		Name    string `db:"name"`
+		Options string `db:"options"`
+	}
+	var saved []savedPreset
+	if err := a.db.Select(&saved, "SELECT name, options FROM main.export_presets ORDER BY name;"); err != nil {
+		return nil, err
+	}
+	for _, s := range saved {
+		preset := CSVPreset{Name: s.Name}
+		if err := json.Unmarshal([]byte(s.Options), &preset.Options); err != nil {
+			return nil, fmt.Errorf("invalid preset %s: %w", s.Name, err)
+		}
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
+
+func (a *App) getCSVPreset(name string) (CSVOptions, error) {
+	if name == "" {
+		name = DEFAULT_CSV_PRESET
+	}
+	presets, err := a.getCSVPresets()
+	if err != nil {
+		return CSVOptions{}, err
+	}
+	for _, p := range presets {
+		if p.Name == name {
+			return p.Options, nil
+		}
+	}
+	return CSVOptions{}, fmt.Errorf("unknown CSV preset %q", name)
+}
+
+func (a *App) saveCSVPreset(name string, opts CSVOptions) error {
+	if name == "" {
+		return errors.New(BadRequestError)
+	}
+	for _, p := range builtInCSVPresets {
+		if p.Name == name {
+			return fmt.Errorf("cannot overwrite built-in preset %s", name)
+		}
+	}
+	if _, err := opts.normalize(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	_, err = a.execTrusted(
+		"INSERT INTO main.export_presets (name, options) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET options = excluded.options;",
+		name, string(body),
+	)
+	return err
+}
+
+func (a *App) GetCSVPresets() AppResult {
+	presets, err := a.getCSVPresets()
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"presets": presets}, nil)
+}
+
+func (a *App) SaveCSVPreset(preset CSVPreset) AppResult {
+	if err := a.saveCSVPreset(preset.Name, preset.Options); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"name": preset.Name}, nil)
+}
+
+func (a *App) DeleteCSVPreset(name string) AppResult {
+	res, err := a.execTrusted("DELETE FROM main.export_presets WHERE name = ?;", name)
+	if err == nil {
+		var n int64
+		if n, err = res.RowsAffected(); err == nil && n == 0 {
+			err = fmt.Errorf("no saved preset named %s", name)
+		}
+	}
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"name": name}, nil)
+}
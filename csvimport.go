@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	CSV_TYPE_INTEGER  = "INTEGER"
+	CSV_TYPE_REAL     = "REAL"
+	CSV_TYPE_TEXT     = "TEXT"
+	CSV_TYPE_BOOLEAN  = "BOOLEAN"
+	CSV_TYPE_DATE     = "DATE"
+	CSV_TYPE_DATETIME = "DATETIME"
+
+	ENCODING_UTF8    = "utf-8"
+	ENCODING_UTF16LE = "utf-16le"
+	ENCODING_UTF16BE = "utf-16be"
+	ENCODING_CP1252  = "windows-1252"
+	ENCODING_LATIN1  = "iso-8859-1"
+
+	defaultCSVSampleRows  = 1000
+	defaultCSVPreviewRows = 20
+	defaultCSVBatchSize   = 500
+	// bytes read up front to detect the encoding and delimiter
+	csvSniffSize = 64 * 1024
+	// SQLite's default limit on bound parameters per statement
+	maxSQLVariables = 32766
+)
+
+var (
+	csvDelimiters      = []rune{',', ';', '\t', '|'}
+	csvDateTimeLayouts = []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04",
+		"2006-01-02T15:04",
+		time.RFC3339Nano,
+	}
+)
+
+// a column of the CSV file and how it is imported; Source is its index in
+// the file
+type CSVImportColumn struct {
+	Source int    `json:"source"`
+	Header string `json:"header"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Skip   bool   `json:"skip"`
+}
+
+// what an import would do, returned before anything is written so the
+// columns can be adjusted
+type CSVImportPreview struct {
+	Path        string            `json:"path"`
+	Delimiter   string            `json:"delimiter"`
+	Encoding    string            `json:"encoding"`
+	Header      bool              `json:"header"`
+	Columns     []CSVImportColumn `json:"columns"`
+	Rows        [][]string        `json:"rows"`
+	SampledRows int64             `json:"sampledRows"`
+}
+
+type ImportProgress struct {
+	Table      string `json:"table"`
+	Rows       int64  `json:"rows"`
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"totalBytes"`
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// guesses the text encoding from a byte order mark, falling back to
+// Windows-1252 when the sample is not valid UTF-8
+func detectEncoding(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return ENCODING_UTF16LE
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return ENCODING_UTF16BE
+	}
+	// the sample may end in the middle of a rune, which then starts at most
+	// UTFMax-1 continuation bytes before the end
+	start := len(sample) - 1
+	for start > 0 && start > len(sample)-utf8.UTFMax && !utf8.RuneStart(sample[start]) {
+		start--
+	}
+	if start >= 0 && !utf8.FullRune(sample[start:]) {
+		sample = sample[:start]
+	}
+	if utf8.Valid(sample) {
+		return ENCODING_UTF8
+	}
+	return ENCODING_CP1252
+}
+
+// wraps r so it yields UTF-8 without a byte order mark
+func decodeCSV(r io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case ENCODING_UTF8, "utf8", "":
+		return transform.NewReader(r, unicode.UTF8BOM.NewDecoder()), nil
+	case ENCODING_UTF16LE:
+		return transform.NewReader(r, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()), nil
+	case ENCODING_UTF16BE:
+		return transform.NewReader(r, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()), nil
+	case ENCODING_CP1252, "cp1252":
+		return transform.NewReader(r, charmap.Windows1252.NewDecoder()), nil
+	case ENCODING_LATIN1, "latin1":
+		return transform.NewReader(r, charmap.ISO8859_1.NewDecoder()), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// counts the fields of one line for delimiter, ignoring quoted text
+func countDelimiter(line string, delimiter rune) int {
+	count := 0
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == delimiter && !quoted:
+			count++
+		}
+	}
+	return count
+}
+
+// picks the candidate delimiter that splits the sample lines most
+// consistently, preferring the comma on ties
+func detectDelimiter(sample string) rune {
+	lines := strings.Split(strings.ReplaceAll(sample, "\r\n", "\n"), "\n")
+	if len(lines) > 1 {
+		// the last line may be cut short
+		lines = lines[:len(lines)-1]
+	}
+	best, bestScore := ',', 0
+	for _, d := range csvDelimiters {
+		counts := make(map[int]int)
+		for _, line := range lines {
+			if line != "" {
+				counts[countDelimiter(line, d)]++
+			}
+		}
+		score := 0
+		for n, lines := range counts {
+			if n > 0 && lines*n > score {
+				score = lines * n
+			}
+		}
+		if score > bestScore {
+			best, bestScore = d, score
+		}
+	}
+	return best
+}
+
+// narrows the possible types of a column value by value
+type columnTypeGuess struct {
+	seen                                           bool
+	notInt, notReal, notBool, notDate, notDateTime bool
+}
+
+func hasLeadingZero(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	return len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9'
+}
+
+func (g *columnTypeGuess) add(value string) {
+	if value == "" {
+		return
+	}
+	g.seen = true
+	// values such as zip codes lose their leading zeros as numbers
+	leadingZero := hasLeadingZero(value)
+	if !g.notInt {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil || leadingZero {
+			g.notInt = true
+		}
+	}
+	if !g.notReal {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || leadingZero || f != f || strings.ContainsAny(value, "iInN") {
+			g.notReal = true
+		}
+	}
+	if !g.notBool {
+		lower := strings.ToLower(value)
+		g.notBool = lower != "true" && lower != "false"
+	}
+	if !g.notDate {
+		_, err := time.Parse(time.DateOnly, value)
+		g.notDate = err != nil
+	}
+	if !g.notDateTime {
+		g.notDateTime = !slices.ContainsFunc(csvDateTimeLayouts, func(layout string) bool {
+			_, err := time.Parse(layout, value)
+			return err == nil
+		})
+	}
+}
+
+func (g *columnTypeGuess) Type() string {
+	switch {
+	case !g.seen:
+		return CSV_TYPE_TEXT
+	case !g.notInt:
+		return CSV_TYPE_INTEGER
+	case !g.notReal:
+		return CSV_TYPE_REAL
+	case !g.notBool:
+		return CSV_TYPE_BOOLEAN
+	case !g.notDate:
+		return CSV_TYPE_DATE
+	case !g.notDateTime:
+		return CSV_TYPE_DATETIME
+	}
+	return CSV_TYPE_TEXT
+}
+
+// an open CSV file positioned after its header
+type csvSource struct {
+	file     *os.File
+	counter  *countingReader
+	reader   *csv.Reader
+	header   []string
+	size     int64
+	encoding string
+	delim    rune
+}
+
+func (s *csvSource) Close() error {
+	return s.file.Close()
+}
+
+// opens path and reads its header; empty encoding and delimiter are detected
+func openCSVSource(path string, encoding string, delimiter string, noHeader bool) (*csvSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	src := &csvSource{file: file, counter: &countingReader{r: file}, size: info.Size()}
+	buffered := bufio.NewReaderSize(src.counter, csvSniffSize)
+	sample, _ := buffered.Peek(csvSniffSize)
+	src.encoding = strings.ToLower(encoding)
+	if src.encoding == "" {
+		src.encoding = detectEncoding(sample)
+	}
+	decoded, err := decodeCSV(buffered, src.encoding)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	text := bufio.NewReaderSize(decoded, csvSniffSize)
+	if delimiter == "" {
+		peeked, _ := text.Peek(csvSniffSize)
+		src.delim = detectDelimiter(string(peeked))
+	} else {
+		d, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || d == '"' || d == '\r' || d == '\n' {
+			file.Close()
+			return nil, fmt.Errorf("invalid delimiter %q", delimiter)
+		}
+		src.delim = d
+	}
+	src.reader = csv.NewReader(text)
+	src.reader.Comma = src.delim
+	src.reader.FieldsPerRecord = -1
+	src.reader.LazyQuotes = true
+	src.reader.ReuseRecord = true
+
+	if !noHeader {
+		header, err := src.reader.Read()
+		if err != nil {
+			file.Close()
+			if err == io.EOF {
+				return nil, errors.New("csv file is empty")
+			}
+			return nil, err
+		}
+		src.header = slices.Clone(header)
+	}
+	return src, nil
+}
+
+// names the columns of the file from its header, making them unique
+func csvColumns(header []string, width int) []CSVImportColumn {
+	cols := make([]CSVImportColumn, max(len(header), width))
+	seen := make(map[string]bool)
+	for i := range cols {
+		name := ""
+		if i < len(header) {
+			name = strings.TrimSpace(header[i])
+			cols[i].Header = header[i]
+		}
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		unique := name
+		for n := 2; seen[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[strings.ToLower(unique)] = true
+		cols[i].Source = i
+		cols[i].Name = unique
+	}
+	return cols
+}
+
+// reads up to req.SampleRows rows (all of them when negative) and infers the
+// schema the file would be imported with
+func previewCSV(req CSVImportRequest) (CSVImportPreview, error) {
+	preview := CSVImportPreview{Path: req.Path, Header: !req.NoHeader, Rows: [][]string{}}
+	src, err := openCSVSource(req.Path, req.Encoding, req.Delimiter, req.NoHeader)
+	if err != nil {
+		return preview, err
+	}
+	defer src.Close()
+	preview.Encoding = src.encoding
+	preview.Delimiter = string(src.delim)
+
+	sample := req.SampleRows
+	if sample == 0 {
+		sample = defaultCSVSampleRows
+	}
+	previewRows := req.PreviewRows
+	if previewRows <= 0 {
+		previewRows = defaultCSVPreviewRows
+	}
+	var guesses []columnTypeGuess
+	for sample < 0 || preview.SampledRows < int64(sample) {
+		record, err := src.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return preview, err
+		}
+		for len(guesses) < len(record) {
+			guesses = append(guesses, columnTypeGuess{})
+		}
+		for i, value := range record {
+			guesses[i].add(value)
+		}
+		if len(preview.Rows) < previewRows {
+			preview.Rows = append(preview.Rows, slices.Clone(record))
+		}
+		preview.SampledRows++
+	}
+	preview.Columns = csvColumns(src.header, len(guesses))
+	for i := range preview.Columns {
+		preview.Columns[i].Type = CSV_TYPE_TEXT
+		if i < len(guesses) {
+			preview.Columns[i].Type = guesses[i].Type()
+		}
+	}
+	if len(preview.Columns) == 0 {
+		return preview, errors.New("csv file has no columns")
+	}
+	return preview, nil
+}
+
+func csvImportValue(value string, colType string) any {
+	if value == "" {
+		return nil
+	}
+	if colType == CSV_TYPE_BOOLEAN {
+		switch strings.ToLower(value) {
+		case "true":
+			return 1
+		case "false":
+			return 0
+		}
+	}
+	// the column affinity converts numeric text on insert
+	return value
+}
+
+// streams the rows of the file at req.Path into a new table of db using
+// multi-row INSERTs inside one transaction, reporting progress per batch
+func importCSV(db *sqlx.DB, req CSVImportRequest, cols []CSVImportColumn, progress func(rows, bytesRead, totalBytes int64)) (int64, error) {
+	var used []CSVImportColumn
+	for _, col := range cols {
+		if col.Skip {
+			continue
+		}
+		if col.Source < 0 || col.Name == "" {
+			return 0, fmt.Errorf("invalid column %+v", col)
+		}
+		col.Type = strings.ToUpper(col.Type)
+		if !slices.Contains([]string{CSV_TYPE_INTEGER, CSV_TYPE_REAL, CSV_TYPE_TEXT, CSV_TYPE_BOOLEAN, CSV_TYPE_DATE, CSV_TYPE_DATETIME}, col.Type) {
+			return 0, fmt.Errorf("column %s: unknown type %q", col.Name, col.Type)
+		}
+		used = append(used, col)
+	}
+	if len(used) == 0 {
+		return 0, errors.New("no columns selected for import")
+	}
+	src, err := openCSVSource(req.Path, req.Encoding, req.Delimiter, req.NoHeader)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	defs := make([]string, len(used))
+	names := make([]string, len(used))
+	for i, col := range used {
+		names[i] = quoteIdent(col.Name)
+		defs[i] = names[i] + " " + col.Type
+	}
+	batchSize := req.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCSVBatchSize
+	}
+	batchSize = min(batchSize, maxSQLVariables/len(used))
+	rowPlaceholder := "(" + generateINSERTPlaceholders(len(used)) + ")"
+	insertPrefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quoteIdent(req.Table), strings.Join(names, ", "))
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s);", quoteIdent(req.Table), strings.Join(defs, ", "))); err != nil {
+		return 0, err
+	}
+	fullBatch, err := tx.Preparex(insertPrefix + strings.TrimSuffix(strings.Repeat(rowPlaceholder+", ", batchSize), ", ") + ";")
+	if err != nil {
+		return 0, err
+	}
+	defer fullBatch.Close()
+
+	args := make([]any, 0, batchSize*len(used))
+	inBatch := 0
+	var count int64
+	flush := func() error {
+		if inBatch == 0 {
+			return nil
+		}
+		var err error
+		if inBatch == batchSize {
+			_, err = fullBatch.Exec(args...)
+		} else {
+			_, err = tx.Exec(insertPrefix+strings.TrimSuffix(strings.Repeat(rowPlaceholder+", ", inBatch), ", ")+";", args...)
+		}
+		if err != nil {
+			return fmt.Errorf("inserting rows %d to %d: %w", count-int64(inBatch)+1, count, err)
+		}
+		args = args[:0]
+		inBatch = 0
+		if progress != nil {
+			progress(count, src.counter.n, src.size)
+		}
+		return nil
+	}
+	for {
+		record, err := src.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		for _, col := range used {
+			var value string
+			if col.Source < len(record) {
+				value = record[col.Source]
+			}
+			args = append(args, csvImportValue(value, col.Type))
+		}
+		inBatch++
+		count++
+		if inBatch == batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	return count, tx.Commit()
+}
+
+// creates the database an import without a target writes into and stores
+// it for the current root
+func (a *App) newImportDB(path string) (*sqlx.DB, string, string, error) {
+	if err := a.checkWritable(""); err != nil {
+		return nil, "", "", err
+	}
+	name, _ := parseFile(path)
+	alias, err := a.freeDBAlias(name)
+	if err != nil {
+		return nil, "", "", err
+	}
+	dbPath, err := a.getUniqueDBPath(alias)
+	if err != nil {
+		return nil, "", "", err
+	}
+	db, err := sqlx.Open(SQLITE_DRIVER, dbPath)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return db, alias, dbPath, nil
+}
+
+// streams a CSV file into table of a new database at dbPath and stores it
+// under dbName, inferring the column types from every row
+func (a *App) uploadCSV(path string, dbName string, dbPath string) error {
+	req := CSVImportRequest{Path: path, Table: dbName, SampleRows: -1}
+	preview, err := previewCSV(req)
+	if err != nil {
+		return err
+	}
+	db, err := sqlx.Open(SQLITE_DRIVER, dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := importCSV(db, req, preview.Columns, a.importProgress(req.Table)); err != nil {
+		return err
+	}
+	return a.storeDB(dbName, dbPath, true)
+}
+
+func (a *App) importProgress(table string) func(rows, bytesRead, totalBytes int64) {
+	return func(rows, bytesRead, totalBytes int64) {
+		a.emitData(IMPORT_PROGRESS, ImportProgress{Table: table, Rows: rows, Bytes: bytesRead, TotalBytes: totalBytes})
+	}
+}
+
+// returns the inferred schema and first rows of a CSV file without writing
+// anything
+func (a *App) PreviewCSVImport(req CSVImportRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	preview, err := previewCSV(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, preview, nil)
+}
+
+// imports a CSV file into a new table. req.Columns, usually an adjusted
+// preview, decides the names and types; without it the inferred schema is
+// used. Without req.DB a new database named after the file is created.
+func (a *App) ImportCSV(req CSVImportRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	if req.Table == "" {
+		req.Table, _ = parseFile(req.Path)
+	}
+	cols := req.Columns
+	if len(cols) == 0 {
+		preview, err := previewCSV(req)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		cols = preview.Columns
+	}
+
+	var db *sqlx.DB
+	var alias, dbPath string
+	var err error
+	if req.DB == "" {
+		db, alias, dbPath, err = a.newImportDB(req.Path)
+	} else {
+		db, err = a.openWritableDB(req.DB)
+	}
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	n, err := importCSV(db, req, cols, a.importProgress(req.Table))
+	db.Close()
+	if err == nil && req.DB == "" {
+		err = a.storeDB(alias, dbPath, true)
+	}
+	if err != nil {
+		if req.DB == "" {
+			os.Remove(dbPath)
+		}
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	dbName := req.DB
+	if dbName == "" {
+		dbName = alias
+	}
+	return a.newResult(nil, map[string]any{"db": dbName, "table": req.Table, "rows": n}, nil)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func writeTestCSV(t *testing.T, name string, body []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestDetectCSVDialect(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      []byte
+		delimiter string
+		encoding  string
+		header    []string
+	}{
+		{"Comma", []byte("a,b\n1,2\n"), ",", ENCODING_UTF8, []string{"a", "b"}},
+		{"Semicolon with quoted commas", []byte("a;b\n\"1,5\";2\n\"3,5\";4\n"), ";", ENCODING_UTF8, []string{"a", "b"}},
+		{"Tab", []byte("a\tb\tc\n1\t2\t3\n"), "\t", ENCODING_UTF8, []string{"a", "b", "c"}},
+		{"UTF-8 BOM", []byte("\xEF\xBB\xBFname|n\nx|1\n"), "|", ENCODING_UTF8, []string{"name", "n"}},
+		{"UTF-16LE", []byte("\xFF\xFEa\x00,\x00b\x00\n\x001\x00,\x002\x00\n\x00"), ",", ENCODING_UTF16LE, []string{"a", "b"}},
+		{"Windows-1252", []byte("caf\xE9,n\nx,1\n"), ",", ENCODING_CP1252, []string{"café", "n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, err := previewCSV(CSVImportRequest{Path: writeTestCSV(t, "data.csv", tt.body)})
+			if err != nil {
+				t.Fatalf("previewCSV() error = %v", err)
+			}
+			if preview.Delimiter != tt.delimiter || preview.Encoding != tt.encoding {
+				t.Errorf("previewCSV() detected %q/%s, want %q/%s", preview.Delimiter, preview.Encoding, tt.delimiter, tt.encoding)
+			}
+			var header []string
+			for _, col := range preview.Columns {
+				header = append(header, col.Name)
+			}
+			if !slices.Equal(header, tt.header) {
+				t.Errorf("previewCSV() columns = %q, want %q", header, tt.header)
+			}
+		})
+	}
+}
+
+func TestDetectEncodingCutRune(t *testing.T) {
+	body := bytes.Repeat([]byte("x,東京\n"), csvSniffSize/9+1)
+	// every cut of the nine byte line, three of them inside each rune
+	for n := csvSniffSize - 8; n <= csvSniffSize; n++ {
+		if got := detectEncoding(body[:n]); got != ENCODING_UTF8 {
+			t.Errorf("detectEncoding() of %d bytes = %s, want %s", n, got, ENCODING_UTF8)
+		}
+	}
+	if got := detectEncoding(append([]byte("caf\xE9,"), body[:csvSniffSize-6]...)); got != ENCODING_CP1252 {
+		t.Errorf("detectEncoding() with a stray byte = %s, want %s", got, ENCODING_CP1252)
+	}
+
+	// the sniffed sample of a larger file ends inside a rune
+	path := writeTestCSV(t, "cut.csv", append([]byte("a,b\n"), body...))
+	preview, err := previewCSV(CSVImportRequest{Path: path})
+	if err != nil || preview.Encoding != ENCODING_UTF8 {
+		t.Errorf("previewCSV() = %+v, %v, want %s", preview, err, ENCODING_UTF8)
+	}
+}
+
+func TestPreviewCSVTypes(t *testing.T) {
+	path := writeTestCSV(t, "types.csv", []byte(
+		"id,zip,price,flag,day,at,code,blank,id\n"+
+			"1,02134,1.5,true,2024-01-02,2024-01-02 10:00:00,1,,x\n"+
+			"2,10001,2,FALSE,2024-02-03,2024-02-03T11:30:00,abc,,y\n"+
+			"3,,-3e2,,,,,,z\n",
+	))
+	tests := []struct {
+		name   string
+		sample int
+		want   []string
+	}{
+		{"All rows", -1, []string{CSV_TYPE_INTEGER, CSV_TYPE_TEXT, CSV_TYPE_REAL, CSV_TYPE_BOOLEAN, CSV_TYPE_DATE, CSV_TYPE_DATETIME, CSV_TYPE_TEXT, CSV_TYPE_TEXT, CSV_TYPE_TEXT}},
+		{"First row only", 1, []string{CSV_TYPE_INTEGER, CSV_TYPE_TEXT, CSV_TYPE_REAL, CSV_TYPE_BOOLEAN, CSV_TYPE_DATE, CSV_TYPE_DATETIME, CSV_TYPE_INTEGER, CSV_TYPE_TEXT, CSV_TYPE_TEXT}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, err := previewCSV(CSVImportRequest{Path: path, SampleRows: tt.sample, PreviewRows: 2})
+			if err != nil {
+				t.Fatalf("previewCSV() error = %v", err)
+			}
+			var types []string
+			for _, col := range preview.Columns {
+				types = append(types, col.Type)
+			}
+			if !slices.Equal(types, tt.want) {
+				t.Errorf("previewCSV() types = %q, want %q", types, tt.want)
+			}
+			if len(preview.Rows) != min(2, int(preview.SampledRows)) || preview.Rows[0][0] != "1" {
+				t.Errorf("previewCSV() rows = %q", preview.Rows)
+			}
+			if preview.Columns[8].Name != "id_2" {
+				t.Errorf("previewCSV() duplicate header named %q, want id_2", preview.Columns[8].Name)
+			}
+		})
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	path := writeTestCSV(t, "people.csv", []byte("name;age;active;notes\nann;31;true;x\nbob;;false;y\n\"c;d\";40;TRUE\n"))
+	preview, err := previewCSV(CSVImportRequest{Path: path})
+	if err != nil {
+		t.Fatalf("previewCSV() error = %v", err)
+	}
+	cols := preview.Columns
+	cols[0].Name = "full name"
+	cols[1].Type = CSV_TYPE_TEXT
+	cols[3].Skip = true
+
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	var progress []int64
+	n, err := importCSV(db, CSVImportRequest{Path: path, Table: "people", BatchSize: 2}, cols, func(rows, _, _ int64) {
+		progress = append(progress, rows)
+	})
+	if err != nil {
+		t.Fatalf("importCSV() error = %v", err)
+	}
+	if n != 3 || !slices.Equal(progress, []int64{2, 3}) {
+		t.Errorf("importCSV() rows = %d, progress = %v", n, progress)
+	}
+	var rows []struct {
+		Name   string  `db:"full name"`
+		Age    *string `db:"age"`
+		Active bool    `db:"active"`
+	}
+	if err := db.Select(&rows, `SELECT * FROM people;`); err != nil {
+		t.Fatalf("select error = %v", err)
+	}
+	if len(rows) != 3 || rows[1].Age != nil || *rows[0].Age != "31" || rows[2].Name != "c;d" || !rows[0].Active || rows[1].Active {
+		t.Errorf("imported rows = %+v", rows)
+	}
+	if _, err := importCSV(db, CSVImportRequest{Path: path, Table: "people"}, cols, nil); err == nil {
+		t.Error("importCSV() overwrote an existing table")
+	}
+	cols[1].Type = "MONEY"
+	if _, err := importCSV(db, CSVImportRequest{Path: path, Table: "other"}, cols, nil); err == nil {
+		t.Error("importCSV() accepted an unknown type")
+	}
+}
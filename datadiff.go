@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ROW_INSERTED = "insert"
+	ROW_DELETED  = "delete"
+	ROW_CHANGED  = "update"
+
+	defaultDiffLimit = 500
+)
+
+type tableRef struct {
+	DB    string
+	Table string
+}
+
+func (t tableRef) String() string {
+	return quoteIdent(t.DB) + "." + quoteIdent(t.Table)
+}
+
+// parses "db.table"; a bare table name refers to main
+func parseTableRef(ref string) (tableRef, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return tableRef{}, errors.New("table reference cannot be empty")
+	}
+	dbName, tblName, found := strings.Cut(ref, ".")
+	if !found {
+		return tableRef{DB: "main", Table: ref}, nil
+	}
+	if dbName == "" || tblName == "" {
+		return tableRef{}, fmt.Errorf("invalid table reference %q", ref)
+	}
+	return tableRef{DB: dbName, Table: tblName}, nil
+}
+
+type ValueChange struct {
+	Column string `json:"column"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// RowDiff is one row that differs between two tables. Row holds the full row
+// to insert or delete, Changes the columns to update.
+type RowDiff struct {
+	Kind    string         `json:"kind"`
+	Key     map[string]any `json:"key"`
+	Row     map[string]any `json:"row,omitempty"`
+	Changes []ValueChange  `json:"changes,omitempty"`
+}
+
+type TableDataDiff struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Keys      []string  `json:"keys"`
+	Columns   []string  `json:"columns"`
+	Inserted  int       `json:"inserted"`
+	Deleted   int       `json:"deleted"`
+	Changed   int       `json:"changed"`
+	Rows      []RowDiff `json:"rows"`
+	Truncated bool      `json:"truncated"`
+}
+
+func tableColumns(q sqlx.Queryer, t tableRef) ([]string, error) {
+	var cols []string
+	query := fmt.Sprintf("SELECT name FROM %s ORDER BY cid;", schemaPragma(t.DB, "pragma_table_info"))
+	if err := sqlx.Select(q, &cols, query, t.Table); err != nil {
+		return cols, err
+	}
+	if len(cols) == 0 {
+		return cols, fmt.Errorf("no such table: %s.%s", t.DB, t.Table)
+	}
+	return cols, nil
+}
+
+func primaryKeyColumns(q sqlx.Queryer, t tableRef) ([]string, error) {
+	var pks []string
+	query := fmt.Sprintf("SELECT name FROM %s WHERE pk > 0 ORDER BY pk;", schemaPragma(t.DB, "pragma_table_info"))
+	err := sqlx.Select(q, &pks, query, t.Table)
+	return pks, err
+}
+
+// resolves the columns both tables share and the key columns used to match rows
+func diffColumnsAndKeys(q sqlx.Queryer, from tableRef, to tableRef, keys []string) ([]string, []string, error) {
+	fromCols, err := tableColumns(q, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	toCols, err := tableColumns(q, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	var common []string
+	for _, col := range fromCols {
+		if slices.ContainsFunc(toCols, func(c string) bool { return strings.EqualFold(c, col) }) {
+			common = append(common, col)
+		}
+	}
+	if len(keys) == 0 {
+		if keys, err = primaryKeyColumns(q, from); err != nil {
+			return nil, nil, err
+		}
+		if len(keys) == 0 {
+			return nil, nil, fmt.Errorf("%s.%s has no primary key; choose key columns", from.DB, from.Table)
+		}
+	}
+	resolved := make([]string, len(keys))
+	for i, key := range keys {
+		j := slices.IndexFunc(common, func(c string) bool { return strings.EqualFold(c, key) })
+		if j < 0 {
+			return nil, nil, fmt.Errorf("key column %s must exist in both tables", key)
+		}
+		resolved[i] = common[j]
+	}
+	return common, resolved, nil
+}
+
+// streams every row that differs between from and to, matched on keys, to fn.
+// The comparison runs as a single FULL OUTER JOIN so only differing rows are
+// read; rows with a NULL key cannot be matched and are skipped.
+func streamTableDiff(q sqlx.Queryer, from tableRef, to tableRef, cols []string, keys []string, fn func(RowDiff) error) error {
+	var selectCols, joinOn, same []string
+	for _, side := range []string{"f", "t"} {
+		for _, col := range cols {
+			selectCols = append(selectCols, side+"."+quoteIdent(col))
+		}
+	}
+	for _, key := range keys {
+		joinOn = append(joinOn, fmt.Sprintf("f.%s = t.%s", quoteIdent(key), quoteIdent(key)))
+	}
+	for _, col := range cols {
+		same = append(same, fmt.Sprintf("f.%s IS t.%s", quoteIdent(col), quoteIdent(col)))
+	}
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s AS f FULL OUTER JOIN %s AS t ON %s WHERE f.%s IS NULL OR t.%s IS NULL OR NOT (%s);",
+		strings.Join(selectCols, ", "),
+		from, to,
+		strings.Join(joinOn, " AND "),
+		quoteIdent(keys[0]), quoteIdent(keys[0]),
+		strings.Join(same, " AND "),
+	)
+	rows, err := q.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keyIdx := make([]int, len(keys))
+	for i, key := range keys {
+		keyIdx[i] = slices.Index(cols, key)
+	}
+	hasKey := func(values []any) bool {
+		for _, i := range keyIdx {
+			if values[i] == nil {
+				return false
+			}
+		}
+		return true
+	}
+	toMap := func(values []any, only []int) map[string]any {
+		m := make(map[string]any)
+		if only == nil {
+			for i, col := range cols {
+				m[col] = values[i]
+			}
+			return m
+		}
+		for _, i := range only {
+			m[cols[i]] = values[i]
+		}
+		return m
+	}
+
+	n := len(cols)
+	for rows.Next() {
+		values := make([]any, 2*n)
+		ptrs := make([]any, 2*n)
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		before, after := values[:n], values[n:]
+		var diff RowDiff
+		switch inFrom, inTo := hasKey(before), hasKey(after); {
+		case inFrom && inTo:
+			diff = RowDiff{Kind: ROW_CHANGED, Key: toMap(before, keyIdx)}
+			for i, col := range cols {
+				if !sqlValuesEqual(before[i], after[i]) {
+					diff.Changes = append(diff.Changes, ValueChange{Column: col, Before: before[i], After: after[i]})
+				}
+			}
+		case inTo:
+			diff = RowDiff{Kind: ROW_INSERTED, Key: toMap(after, keyIdx), Row: toMap(after, nil)}
+		case inFrom:
+			diff = RowDiff{Kind: ROW_DELETED, Key: toMap(before, keyIdx), Row: toMap(before, nil)}
+		default:
+			continue
+		}
+		if err := fn(diff); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func sqlValuesEqual(a any, b any) bool {
+	return sqlLiteral(a) == sqlLiteral(b)
+}
+
+// writes the statement that applies diff to tblName
+func writeRowPatch(w io.Writer, tblName string, cols []string, keys []string, diff RowDiff) error {
+	where := make([]string, len(keys))
+	for i, key := range keys {
+		where[i] = fmt.Sprintf("%s = %s", quoteIdent(key), sqlLiteral(diff.Key[key]))
+	}
+	var stmt string
+	switch diff.Kind {
+	case ROW_INSERTED:
+		quoted := make([]string, len(cols))
+		values := make([]string, len(cols))
+		for i, col := range cols {
+			quoted[i] = quoteIdent(col)
+			values[i] = sqlLiteral(diff.Row[col])
+		}
+		stmt = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);\n", quoteIdent(tblName), strings.Join(quoted, ", "), strings.Join(values, ", "))
+	case ROW_DELETED:
+		stmt = fmt.Sprintf("DELETE FROM %s WHERE %s;\n", quoteIdent(tblName), strings.Join(where, " AND "))
+	case ROW_CHANGED:
+		set := make([]string, len(diff.Changes))
+		for i, change := range diff.Changes {
+			set[i] = fmt.Sprintf("%s = %s", quoteIdent(change.Column), sqlLiteral(change.After))
+		}
+		stmt = fmt.Sprintf("UPDATE %s SET %s WHERE %s;\n", quoteIdent(tblName), strings.Join(set, ", "), strings.Join(where, " AND "))
+	}
+	_, err := io.WriteString(w, stmt)
+	return err
+}
+
+// brings from in line with to using set-based statements in one transaction
+func applyTableSync(db txBeginner, from tableRef, to tableRef, cols []string, keys []string) (map[string]int64, error) {
+	var match, differs, quoted, toCols, setCols, setValues []string
+	for _, key := range keys {
+		match = append(match, fmt.Sprintf("%s.%s = %s.%s", to, quoteIdent(key), from, quoteIdent(key)))
+	}
+	for _, col := range cols {
+		quoted = append(quoted, quoteIdent(col))
+		toCols = append(toCols, fmt.Sprintf("%s.%s", to, quoteIdent(col)))
+		if !slices.Contains(keys, col) {
+			differs = append(differs, fmt.Sprintf("%s.%s IS %s.%s", from, quoteIdent(col), to, quoteIdent(col)))
+			setCols = append(setCols, quoteIdent(col))
+			setValues = append(setValues, fmt.Sprintf("%s.%s", to, quoteIdent(col)))
+		}
+	}
+	matchSQL := strings.Join(match, " AND ")
+	stmts := []struct {
+		kind  string
+		query string
+	}{
+		{ROW_DELETED, fmt.Sprintf("DELETE FROM %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s);", from, to, matchSQL)},
+	}
+	if len(setCols) > 0 {
+		stmts = append(stmts, struct {
+			kind  string
+			query string
+		}{ROW_CHANGED, fmt.Sprintf(
+			"UPDATE %s SET (%s) = (SELECT %s FROM %s WHERE %s) WHERE EXISTS (SELECT 1 FROM %s WHERE %s AND NOT (%s));",
+			from, strings.Join(setCols, ", "), strings.Join(setValues, ", "), to, matchSQL,
+			to, matchSQL, strings.Join(differs, " AND "),
+		)})
+	}
+	stmts = append(stmts, struct {
+		kind  string
+		query string
+	}{ROW_INSERTED, fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT %s FROM %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s);",
+		from, strings.Join(quoted, ", "), strings.Join(toCols, ", "), to, from, matchSQL,
+	)})
+
+	counts := make(map[string]int64)
+	tx, err := db.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return counts, err
+	}
+	for _, stmt := range stmts {
+		res, err := tx.Exec(stmt.query)
+		if err != nil {
+			tx.Rollback()
+			return counts, fmt.Errorf("sync failed: %w", err)
+		}
+		if counts[stmt.kind], err = res.RowsAffected(); err != nil {
+			tx.Rollback()
+			return counts, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return counts, err
+	}
+	return counts, nil
+}
+
+func (a *App) resolveTableDiff(req TableDiffRequest) (tableRef, tableRef, []string, []string, error) {
+	from, err := parseTableRef(req.From)
+	if err != nil {
+		return from, tableRef{}, nil, nil, err
+	}
+	to, err := parseTableRef(req.To)
+	if err != nil {
+		return from, to, nil, nil, err
+	}
+	cols, keys, err := diffColumnsAndKeys(a.db, from, to, req.Keys)
+	return from, to, cols, keys, err
+}
+
+// compares the rows of two tables and returns counts plus the first
+// req.Limit differing rows
+func (a *App) DiffTableData(req TableDiffRequest) AppResult {
+	from, to, cols, keys, err := a.resolveTableDiff(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, map[string]any{"error": BadRequestError}, nil)
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultDiffLimit
+	}
+	result := TableDataDiff{From: req.From, To: req.To, Keys: keys, Columns: cols, Rows: []RowDiff{}}
+	err = streamTableDiff(a.db, from, to, cols, keys, func(diff RowDiff) error {
+		switch diff.Kind {
+		case ROW_INSERTED:
+			result.Inserted++
+		case ROW_DELETED:
+			result.Deleted++
+		case ROW_CHANGED:
+			result.Changed++
+		}
+		if len(result.Rows) < req.Limit {
+			result.Rows = append(result.Rows, diff)
+		} else {
+			result.Truncated = true
+		}
+		return nil
+	})
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, result, nil)
+}
+
+// writes an INSERT/UPDATE/DELETE patch script to req.ScriptPath and, when
+// req.Apply is set, syncs req.From to req.To inside a transaction
+func (a *App) SyncTableData(req TableDiffRequest) AppResult {
+	from, to, cols, keys, err := a.resolveTableDiff(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, map[string]any{"error": BadRequestError}, nil)
+	}
+	if req.ScriptPath == "" && !req.Apply {
+		err := errors.New("a script path or apply is required")
+		return a.newResult(err, map[string]any{"error": BadRequestError}, nil)
+	}
+	results := make(map[string]any)
+	if req.ScriptPath != "" {
+		file, err := os.Create(req.ScriptPath)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		defer file.Close()
+		w := bufio.NewWriter(file)
+		statements := 0
+		fmt.Fprintln(w, "BEGIN TRANSACTION;")
+		err = streamTableDiff(a.db, from, to, cols, keys, func(diff RowDiff) error {
+			statements++
+			return writeRowPatch(w, from.Table, cols, keys, diff)
+		})
+		if err == nil {
+			fmt.Fprintln(w, "COMMIT;")
+			err = w.Flush()
+		}
+		if err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		results["scriptPath"] = req.ScriptPath
+		results["statements"] = statements
+	}
+	if req.Apply {
+		conn, err := a.connWith(context.Background(), from.DB, to.DB)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		defer conn.Close()
+		counts, err := applyTableSync(conn, from, to, cols, keys)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		for kind, count := range counts {
+			results[kind] = count
+		}
+	}
+	return a.newResult(nil, results, nil)
+}
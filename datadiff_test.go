@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newDiffTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	db.MustExec("ATTACH ':memory:' AS master;")
+	db.MustExec(`
+	CREATE TABLE main.readings (id INTEGER PRIMARY KEY, device TEXT, value REAL, raw BLOB);
+	CREATE TABLE master.readings (id INTEGER PRIMARY KEY, device TEXT, value REAL, raw BLOB);
+	INSERT INTO main.readings VALUES (1, 'a', 1.5, NULL), (2, 'b', 2.5, X'00'), (3, 'it''s', 3.5, NULL);
+	INSERT INTO master.readings VALUES (1, 'a', 1.5, NULL), (2, 'b', 9.0, X'01'), (4, 'd', NULL, NULL);
+	`)
+	return db
+}
+
+func collectTableDiff(t *testing.T, db *sqlx.DB, keys []string) (map[string]int, []RowDiff, []string, []string) {
+	from, _ := parseTableRef("main.readings")
+	to, _ := parseTableRef("master.readings")
+	cols, keys, err := diffColumnsAndKeys(db, from, to, keys)
+	if err != nil {
+		t.Fatalf("diffColumnsAndKeys() error = %v", err)
+	}
+	counts := make(map[string]int)
+	var diffs []RowDiff
+	if err := streamTableDiff(db, from, to, cols, keys, func(d RowDiff) error {
+		counts[d.Kind]++
+		diffs = append(diffs, d)
+		return nil
+	}); err != nil {
+		t.Fatalf("streamTableDiff() error = %v", err)
+	}
+	return counts, diffs, cols, keys
+}
+
+func TestStreamTableDiff(t *testing.T) {
+	db := newDiffTestDB(t)
+	defer db.Close()
+
+	counts, diffs, _, _ := collectTableDiff(t, db, nil)
+	if counts[ROW_INSERTED] != 1 || counts[ROW_DELETED] != 1 || counts[ROW_CHANGED] != 1 {
+		t.Fatalf("got counts %v, want one insert, delete and update", counts)
+	}
+	for _, d := range diffs {
+		if d.Kind == ROW_CHANGED && len(d.Changes) != 2 {
+			t.Errorf("expected value and raw to change, got %+v", d.Changes)
+		}
+	}
+
+	if _, _, err := diffColumnsAndKeys(db, tableRef{"main", "readings"}, tableRef{"master", "readings"}, []string{"missing"}); err == nil {
+		t.Errorf("expected an error for an unknown key column")
+	}
+}
+
+func TestTableSyncScript(t *testing.T) {
+	db := newDiffTestDB(t)
+	defer db.Close()
+
+	_, diffs, cols, keys := collectTableDiff(t, db, []string{"ID"})
+	var script bytes.Buffer
+	for _, d := range diffs {
+		if err := writeRowPatch(&script, "readings", cols, keys, d); err != nil {
+			t.Fatalf("writeRowPatch() error = %v", err)
+		}
+	}
+	if _, err := db.Exec(script.String()); err != nil {
+		t.Fatalf("patch script failed: %v\n%s", err, script.String())
+	}
+	if counts, _, _, _ := collectTableDiff(t, db, nil); len(counts) != 0 {
+		t.Errorf("tables still differ after patch: %v\n%s", counts, script.String())
+	}
+}
+
+func TestApplyTableSync(t *testing.T) {
+	db := newDiffTestDB(t)
+	defer db.Close()
+
+	counts, err := applyTableSync(db, tableRef{"main", "readings"}, tableRef{"master", "readings"}, []string{"id", "device", "value", "raw"}, []string{"id"})
+	if err != nil {
+		t.Fatalf("applyTableSync() error = %v", err)
+	}
+	if counts[ROW_INSERTED] != 1 || counts[ROW_DELETED] != 1 || counts[ROW_CHANGED] != 1 {
+		t.Errorf("got counts %v, want one insert, delete and update", counts)
+	}
+	if remaining, _, _, _ := collectTableDiff(t, db, nil); len(remaining) != 0 {
+		t.Errorf("tables still differ after sync: %v", remaining)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
@@ -36,29 +37,62 @@ func castToSQLiteType(t reflect.Type) string {
 type Series = map[string]any
 type Dataframe = []Series
 
-func convertToSQLite(d *Dataframe, db *sqlx.DB, tableName string) error {
+// returns every key used by the rows of df: columns first, in the order
+// given, then keys only found in the rows in order of first appearance, the
+// new keys of each row sorted
+func dataframeColumns(df Dataframe, columns []string) []string {
+	seen := make(map[string]bool, len(columns))
+	var ordered []string
+	for _, col := range columns {
+		if !seen[col] {
+			seen[col] = true
+			ordered = append(ordered, col)
+		}
+	}
+	for _, row := range df {
+		var extra []string
+		for col := range row {
+			if !seen[col] {
+				extra = append(extra, col)
+			}
+		}
+		slices.Sort(extra)
+		for _, col := range extra {
+			seen[col] = true
+			ordered = append(ordered, col)
+		}
+	}
+	return ordered
+}
+
+// replaces tableName with the rows of d. The table has a column for every key
+// of any row, ordered by columns (the source order, e.g. a CSV header) and
+// then as dataframeColumns finds them; rows missing a key store NULL. Each
+// column's type comes from its first non-nil value.
+func convertToSQLite(d *Dataframe, db *sqlx.DB, tableName string, columns ...string) error {
 	if len(*d) == 0 {
 		return fmt.Errorf("cannot convert empty Dataframe to database")
 	}
 	df := *d
 
-	firstRow := df[0]
-	columnDefinitions := ""
-	columnNames := make(map[string]string)
-
-	for colName, value := range firstRow {
-		valueType := reflect.TypeOf(value)
-		sqliteType := castToSQLiteType(valueType)
-		columnDefinitions += fmt.Sprintf("%s %s, ", colName, sqliteType)
-		columnNames[colName] = sqlSanitize(colName)
-	}
-
-	if len(columnNames) == 0 {
+	dfColNames := dataframeColumns(df, columns)
+	if len(dfColNames) == 0 {
 		return fmt.Errorf("Dataframe has no valid columns to infer schema")
 	}
-
-	columnDefinitions = strings.TrimSuffix(columnDefinitions, ", ")
-	createTableSQL := fmt.Sprintf("CREATE TABLE %s (%s)", tableName, columnDefinitions)
+	tblColNames := make([]string, len(dfColNames))
+	columnDefinitions := make([]string, len(dfColNames))
+	for i, colName := range dfColNames {
+		var valueType reflect.Type
+		for _, row := range df {
+			if value := row[colName]; value != nil {
+				valueType = reflect.TypeOf(value)
+				break
+			}
+		}
+		tblColNames[i] = sqlSanitize(colName)
+		columnDefinitions[i] = fmt.Sprintf("%s %s", tblColNames[i], castToSQLiteType(valueType))
+	}
+	createTableSQL := fmt.Sprintf("CREATE TABLE %s (%s)", tableName, strings.Join(columnDefinitions, ", "))
 
 	if _, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s;", tableName)); err != nil {
 		return fmt.Errorf("error dropping existing table %s: %w", tableName, err)
@@ -67,18 +101,10 @@ func convertToSQLite(d *Dataframe, db *sqlx.DB, tableName string) error {
 	if _, err := db.Exec(createTableSQL); err != nil {
 		return fmt.Errorf("error creating table %s: %w | %s", tableName, err, createTableSQL)
 	}
-	// log.Println(createTableSQL)
-	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columnNames)), ", ")
-	dfColNames := make([]string, 0, len(columnNames))
-	tblColNames := make([]string, 0, len(columnNames))
-	for k, v := range columnNames {
-		dfColNames = append(dfColNames, k)
-		tblColNames = append(tblColNames, v)
-	}
 	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
 		tableName,
 		strings.Join(tblColNames, ", "),
-		placeholders,
+		generateINSERTPlaceholders(len(tblColNames)),
 	)
 
 	tx, err := db.Begin()
@@ -97,11 +123,7 @@ func convertToSQLite(d *Dataframe, db *sqlx.DB, tableName string) error {
 		values := make([]any, len(dfColNames))
 		// log.Println(row)
 		for i, colName := range dfColNames {
-			value, ok := row[colName]
-			if !ok {
-				return fmt.Errorf("invalid column name %s", colName)
-			}
-
+			value := row[colName]
 			v := reflect.ValueOf(value)
 
 			if v.IsValid() && (v.Kind() == reflect.Slice || v.Kind() == reflect.Map || v.Kind() == reflect.Struct || v.Kind() == reflect.Array) {
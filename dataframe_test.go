@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/jmoiron/sqlx"
@@ -105,3 +106,46 @@ func TestConvertToSQLite(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertToSQLiteColumns(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	df := &Dataframe{
+		{"zeta": int64(1), "alpha": nil, "Mid": "x"},
+		{"alpha": 2.5, "extra": true},
+		{"beta": "b", "another": int64(1)},
+	}
+	tests := []struct {
+		name    string
+		columns []string
+		want    string
+	}{
+		{"Source order", []string{"zeta", "alpha", "Mid", "extra", "beta", "another"}, "zeta:INTEGER,alpha:REAL,mid:TEXT,extra:INTEGER,beta:TEXT,another:INTEGER"},
+		{"Without an order", nil, "Mid:TEXT,alpha:REAL,zeta:INTEGER,extra:INTEGER,another:INTEGER,beta:TEXT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 3 {
+				if err := convertToSQLite(df, db, "t", tt.columns...); err != nil {
+					t.Fatalf("convertToSQLite() error = %v", err)
+				}
+				var got string
+				if err := db.Get(&got, "SELECT group_concat(name || ':' || type, ',') FROM pragma_table_info('t');"); err != nil {
+					t.Fatalf("failed to read columns: %v", err)
+				}
+				if !strings.EqualFold(got, tt.want) {
+					t.Fatalf("columns = %q, want %q", got, tt.want)
+				}
+			}
+			var nulls int
+			if err := db.Get(&nulls, "SELECT COUNT(*) FROM t WHERE zeta IS NULL AND beta IS NULL;"); err != nil || nulls != 1 {
+				t.Errorf("rows missing keys = %d, %v, want NULLs in 1 row", nulls, err)
+			}
+		})
+	}
+}
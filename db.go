@@ -23,7 +23,7 @@ func (a *App) getCurrentDB() (string, error) {
 }
 
 func (a *App) SetCurrentDB(name string) AppResult {
-	if _, err := a.db.Exec(
+	if _, err := a.execTrusted(
 		`INSERT INTO main.current_db (id, current_db)
 		VALUES (1, ?)
 		ON CONFLICT (id) DO UPDATE SET current_db = excluded.current_db;`,
@@ -59,6 +59,9 @@ func (a *App) CreateDB(dbForm CreateDBRequest) AppResult {
 		a.logger.Error(err.Error())
 		return a.newResult(err, map[string]any{"error": BadRequestError}, nil)
 	}
+	if err := a.checkWritable(""); err != nil {
+		return a.newResult(err, nil, nil)
+	}
 	dbForm.Name = cleanDBName(dbForm.Name)
 	// 1. MANDATORY: Check for DB Name Uniqueness in Application Metadata
 	// The name used in the app/ATTACH command must be unique for the user.
@@ -75,33 +78,10 @@ func (a *App) CreateDB(dbForm CreateDBRequest) AppResult {
 		return a.newResult(err, nil, nil)
 	}
 
-	// --- MODIFICATION START: Ensure unique PHYSICAL file path ---
-	basePath := a.getNewDBPath(dbForm.Name) // e.g., /path/to/my_db.db
-	dbPath := basePath
-	suffix := 0
-	const maxAttempts = 100 // Safety break
-
-	// Split the name from the extension for easy suffixing (requires "path/filepath")
-	ext := filepath.Ext(basePath)
-	nameWithoutExt := basePath[:len(basePath)-len(ext)]
-
-	for i := range maxAttempts {
-		// Check if the file at the current path exists on disk
-		if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
-			// File does not exist, path is unique. Break and proceed.
-			break
-		}
-
-		// File exists, so generate the next suffixed path (e.g., /path/to/my_db_1.db)
-		suffix++
-		dbPath = fmt.Sprintf("%s_%d%s", nameWithoutExt, suffix, ext)
-		a.logger.Debug(fmt.Sprintf("File collision detected. Trying new path: %s", dbPath))
-
-		if i == maxAttempts-1 {
-			err := fmt.Errorf("failed to find a unique file path for database: %s after 100 attempts", dbForm.Name)
-			a.logger.Error(err.Error())
-			return a.newResult(err, nil, nil)
-		}
+	dbPath, err := a.getUniqueDBPath(dbForm.Name)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
 	}
 
 	// Create the file using the unique path (dbPath)
@@ -141,18 +121,38 @@ func (a *App) CreateDB(dbForm CreateDBRequest) AppResult {
 	)
 }
 
+// returns an app data path for dbName that does not exist on disk yet,
+// suffixing the name (my_db_1.db, my_db_2.db, ...) on collisions
+func (a *App) getUniqueDBPath(dbName string) (string, error) {
+	basePath := a.getNewDBPath(dbName) // e.g., /path/to/my_db.db
+	dbPath := basePath
+	suffix := 0
+	const maxAttempts = 100 // Safety break
+
+	// Split the name from the extension for easy suffixing (requires "path/filepath")
+	ext := filepath.Ext(basePath)
+	nameWithoutExt := basePath[:len(basePath)-len(ext)]
+
+	for range maxAttempts {
+		// Check if the file at the current path exists on disk
+		if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+			// File does not exist, path is unique.
+			return dbPath, nil
+		}
+
+		// File exists, so generate the next suffixed path (e.g., /path/to/my_db_1.db)
+		suffix++
+		dbPath = fmt.Sprintf("%s_%d%s", nameWithoutExt, suffix, ext)
+		a.logger.Debug(fmt.Sprintf("File collision detected. Trying new path: %s", dbPath))
+	}
+	return "", fmt.Errorf("failed to find a unique file path for database: %s after %d attempts", dbName, maxAttempts)
+}
+
 func (a *App) UpdateDB(req UpdateRequest) AppResult {
 	var pks []string
 	a.logger.Debug(fmt.Sprint(req))
-	var tableName string
-	if req.DB == "" {
-		tableName = req.Table
-	} else {
-		tableName = fmt.Sprintf("%s.%s", req.DB, req.Table)
-	}
-
-	pkQuery := fmt.Sprintf("SELECT name FROM pragma_table_info('%s') WHERE pk <> 0;", tableName)
-	if err := a.db.Select(&pks, pkQuery); err != nil {
+	pkQuery := fmt.Sprintf("SELECT name FROM %s WHERE pk <> 0;", schemaPragma(req.DB, "pragma_table_info"))
+	if err := a.db.Select(&pks, pkQuery, req.Table); err != nil {
 		a.logger.Error(err.Error())
 		return a.newResult(err, nil, nil)
 	}
@@ -182,17 +182,15 @@ func (a *App) UpdateDB(req UpdateRequest) AppResult {
 		}
 	}
 	query := fmt.Sprintf(
-		"UPDATE %s SET %s='%v' WHERE %s='%v';",
-		tableName,
-		req.Column,
-		req.Value,
-		pk,
-		pkVal,
+		"UPDATE %s SET %s = ? WHERE %s = ?;",
+		quoteQualified(req.DB, req.Table),
+		quoteIdent(req.Column),
+		quoteIdent(pk),
 	)
 	a.logger.Debug(query)
-	if _, err := a.db.Exec(query); err != nil {
+	if _, err := a.db.Exec(query, req.Value, pkVal); err != nil {
 		a.logger.Error(err.Error())
-		return a.newResult(err, nil, nil)
+		return a.newResult(a.policy.explain(err), nil, nil)
 	}
 	return a.newResult(nil, nil, nil)
 }
@@ -201,27 +199,26 @@ func (a *App) RemoveDB(dbName string) AppResult {
 	if dbName == "" {
 		return a.newResult(errors.New("invalid db name"), map[string]any{"error": "invalid db name"}, nil)
 	}
-	type DBInfo struct {
-		Name string `db:"name"`
-		File string `db:"file"`
-	}
-	var targetDB DBInfo
-	if err := a.db.Get(&targetDB, "SELECT dbs.name, dbs.file FROM pragma_database_list dbs JOIN main.dbs mdbs ON dbs.file = mdbs.path WHERE mdbs.name = ? LIMIT 1;", dbName); err != nil {
+	targetDB, ok := a.registry.lookup(dbName)
+	if !ok {
+		err := fmt.Errorf("database %s is not stored", dbName)
 		a.logger.Error(err.Error())
 		return a.newResult(err, nil, nil)
 	}
-
-	query := fmt.Sprintf("DETACH DATABASE \"%s\";", targetDB.Name)
-	if _, err := a.db.Exec(query); err != nil {
+	// removing deletes the file, which read-only databases must survive
+	if err := a.checkWritable(targetDB.Name); err != nil {
 		a.logger.Error(err.Error())
-		return a.newResult(err, map[string]any{"error": err.Error()}, nil)
+		return a.newResult(err, nil, nil)
 	}
 
-	if _, err := a.db.Exec("DELETE FROM main.dbs where name = ? ;", dbName); err != nil {
+	a.registry.unregister(targetDB.Name)
+	a.releaseDetached()
+	a.policy.set(dbName, false)
+	if _, err := a.execTrusted("DELETE FROM main.dbs where name = ? ;", dbName); err != nil {
 		a.logger.Error(err.Error())
 		return a.newResult(err, map[string]any{"error": err.Error()}, nil)
 	}
-	if err := os.Remove(targetDB.File); err != nil {
+	if err := os.Remove(targetDB.Path); err != nil {
 		a.logger.Error(err.Error())
 		return a.newResult(err, nil, nil)
 	}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const defaultDumpBatchSize = 100
+
+type DumpOptions struct {
+	SchemaOnly bool
+	DataOnly   bool
+	// rows per INSERT statement
+	BatchSize int
+}
+
+// selects a column so it scans either as a float64 or as a ready-made SQL
+// literal; quote() keeps TEXT verbatim where the driver would otherwise turn
+// DATETIME columns into time.Time
+func dumpValueExpr(col string) string {
+	return fmt.Sprintf("CASE WHEN typeof(%s) = 'real' THEN %s ELSE quote(%s) END", quoteIdent(col), quoteIdent(col), quoteIdent(col))
+}
+
+func dumpLiteral(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return sqlLiteral(v)
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return sqlLiteral(value)
+}
+
+// streams the rows of one table as batched INSERT statements
+func dumpTableData(w io.Writer, q sqlx.Queryer, dbName string, table SchemaObject, batchSize int) error {
+	var cols, exprs []string
+	generated := false
+	for _, col := range table.Columns {
+		if col.Hidden != 0 {
+			generated = true
+			continue
+		}
+		cols = append(cols, quoteIdent(col.Name))
+		exprs = append(exprs, dumpValueExpr(col.Name))
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	insertPrefix := fmt.Sprintf("INSERT INTO %s VALUES", quoteIdent(table.Name))
+	if generated || strings.HasPrefix(strings.ToUpper(table.SQL), "CREATE VIRTUAL") {
+		insertPrefix = fmt.Sprintf("INSERT INTO %s (%s) VALUES", quoteIdent(table.Name), strings.Join(cols, ", "))
+	}
+	rows, err := q.Query(fmt.Sprintf("SELECT %s FROM %s;", strings.Join(exprs, ", "), quoteQualified(dbName, table.Name)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	literals := make([]string, len(cols))
+	inBatch := 0
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			literals[i] = dumpLiteral(v)
+		}
+		sep := ","
+		if inBatch == 0 {
+			sep = insertPrefix
+		}
+		if _, err := fmt.Fprintf(w, "%s\n(%s)", sep, strings.Join(literals, ",")); err != nil {
+			return err
+		}
+		inBatch++
+		if inBatch == batchSize {
+			if _, err := io.WriteString(w, ";\n"); err != nil {
+				return err
+			}
+			inBatch = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if inBatch > 0 {
+		if _, err := io.WriteString(w, ";\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpSequences(w io.Writer, q sqlx.Queryer, dbName string) error {
+	var exists int
+	if err := sqlx.Get(q, &exists, fmt.Sprintf("SELECT COUNT(*) FROM %s.sqlite_master WHERE name = 'sqlite_sequence';", quoteIdent(dbName))); err != nil || exists == 0 {
+		return err
+	}
+	type sequence struct {
+		Name string `db:"name"`
+		Seq  int64  `db:"seq"`
+	}
+	var seqs []sequence
+	if err := sqlx.Select(q, &seqs, fmt.Sprintf("SELECT name, seq FROM %s.sqlite_sequence;", quoteIdent(dbName))); err != nil {
+		return err
+	}
+	if len(seqs) == 0 {
+		return nil
+	}
+	if _, err := io.WriteString(w, "DELETE FROM sqlite_sequence;\n"); err != nil {
+		return err
+	}
+	for _, s := range seqs {
+		if _, err := fmt.Fprintf(w, "INSERT INTO sqlite_sequence (name, seq) VALUES (%s, %d);\n", sqlLiteral(s.Name), s.Seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes dbName as an SQL script in the style of the sqlite3 .dump command:
+// tables with their rows first, then indexes, views and triggers in creation
+// order. Rows are streamed from the table cursor.
+func writeDump(w io.Writer, q sqlx.Queryer, dbName string, opts DumpOptions) error {
+	if opts.SchemaOnly && opts.DataOnly {
+		return errors.New("schema only and data only cannot both be set")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultDumpBatchSize
+	}
+	schema, err := loadSchema(q, dbName)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"); err != nil {
+		return err
+	}
+	// shadow tables are recreated by their virtual table's CREATE statement
+	var shadowTables []string
+	query := fmt.Sprintf("SELECT name FROM %s WHERE schema = ? AND type = 'shadow';", quoteQualified(dbName, "pragma_table_list"))
+	if err := sqlx.Select(q, &shadowTables, query, dbName); err != nil {
+		return err
+	}
+	for _, obj := range schema.Objects {
+		if obj.Type != "table" || slices.Contains(shadowTables, obj.Name) {
+			continue
+		}
+		if !opts.DataOnly {
+			if _, err := fmt.Fprintf(bw, "%s;\n", strings.TrimSuffix(obj.SQL, ";")); err != nil {
+				return err
+			}
+		}
+		if !opts.SchemaOnly {
+			if err := dumpTableData(bw, q, dbName, obj, opts.BatchSize); err != nil {
+				return fmt.Errorf("dumping %s: %w", obj.Name, err)
+			}
+		}
+	}
+	if !opts.SchemaOnly {
+		if err := dumpSequences(bw, q, dbName); err != nil {
+			return err
+		}
+	}
+	if !opts.DataOnly {
+		for _, objType := range []string{"index", "view", "trigger"} {
+			for _, obj := range schema.Objects {
+				if obj.Type == objType {
+					if _, err := fmt.Fprintf(bw, "%s;\n", strings.TrimSuffix(obj.SQL, ";")); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if _, err := io.WriteString(bw, "COMMIT;\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// dumps dbName inside one read transaction on one connection, like the
+// sqlite3 .dump command, so the script matches a single state of the
+// database while others write to it
+func (a *App) dumpDB(w io.Writer, dbName string, opts DumpOptions) error {
+	ctx := context.Background()
+	conn, err := a.connWith(ctx, dbName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return writeDump(w, tx, dbName, opts)
+}
+
+// writes one <db>.sql dump per stored database into a zip at path
+func (a *App) exportDumpZip(path string, opts DumpOptions) (err error) {
+	dbs, err := a.getSQLiteDBNames()
+	if err != nil {
+		return err
+	}
+	zipFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := zipFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// a partial dump is worse than none
+			os.Remove(path)
+		}
+	}()
+
+	zipWriter := zip.NewWriter(zipFile)
+	for _, dbName := range dbs {
+		file, err := zipWriter.Create(dbName + ".sql")
+		if err != nil {
+			return err
+		}
+		if err := a.dumpDB(file, dbName, opts); err != nil {
+			return fmt.Errorf("dumping %s: %w", dbName, err)
+		}
+	}
+	return zipWriter.Close()
+}
+
+// dumps req.DB to req.Path as a .sql script, or every database of the
+// workspace into a zip when req.DB is empty
+func (a *App) ExportSQLDump(req DumpRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	opts := DumpOptions{SchemaOnly: req.SchemaOnly, DataOnly: req.DataOnly, BatchSize: req.BatchSize}
+	if req.DB == "" {
+		if err := a.exportDumpZip(req.Path, opts); err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+		return a.newResult(nil, map[string]any{"path": req.Path}, nil)
+	}
+	file, err := os.Create(req.Path)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	err = a.dumpDB(file, req.DB, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(req.Path)
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": req.Path}, nil)
+}
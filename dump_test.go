@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestWriteDump(t *testing.T) {
+	src, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+	src.MustExec(`
+	CREATE TABLE events (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL, at DATETIME, score REAL, payload BLOB, total INTEGER GENERATED ALWAYS AS (id * 2) VIRTUAL);
+	CREATE TABLE "odd ""name""" (a, b);
+	CREATE INDEX events_at ON events(at);
+	CREATE VIEW recent AS SELECT * FROM events WHERE at > '2024-01-01';
+	CREATE TRIGGER events_touch AFTER UPDATE ON events BEGIN UPDATE events SET at = '2024-06-01' WHERE id = new.id; END;
+	INSERT INTO events (title, at, score, payload) VALUES
+		('it''s', '2024-03-01 10:00:00', 0.1, X'00FF'),
+		('line
+break', NULL, 1e300, NULL),
+		('whole', '2023-12-31', 3.0, X'');
+	INSERT INTO "odd ""name""" VALUES (1, NULL);
+	DELETE FROM events WHERE title = 'whole';
+	`)
+
+	tests := []struct {
+		name       string
+		opts       DumpOptions
+		wantCreate bool
+		wantInsert bool
+	}{
+		{"Full dump", DumpOptions{BatchSize: 1}, true, true},
+		{"Schema only", DumpOptions{SchemaOnly: true}, true, false},
+		{"Data only", DumpOptions{DataOnly: true}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeDump(&buf, src, "main", tt.opts); err != nil {
+				t.Fatalf("writeDump() error = %v", err)
+			}
+			script := buf.String()
+			if strings.Contains(script, "CREATE TABLE") != tt.wantCreate {
+				t.Errorf("CREATE TABLE present = %v, want %v\n%s", !tt.wantCreate, tt.wantCreate, script)
+			}
+			if strings.Contains(script, "INSERT INTO") != tt.wantInsert {
+				t.Errorf("INSERT present = %v, want %v\n%s", !tt.wantInsert, tt.wantInsert, script)
+			}
+			if !tt.wantCreate || !tt.wantInsert {
+				return
+			}
+
+			dst, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer dst.Close()
+			dst.SetMaxOpenConns(1)
+			if _, err := dst.Exec(script); err != nil {
+				t.Fatalf("restoring dump failed: %v\n%s", err, script)
+			}
+			before, _ := loadSchema(src, "main")
+			after, _ := loadSchema(dst, "main")
+			if diff := diffSchemas(before, after); !diff.Empty() {
+				t.Errorf("restored schema differs: %+v", diff)
+			}
+			query := "SELECT group_concat(id || quote(title) || quote(at) || score || quote(payload) || total, '|') FROM events;"
+			var want, got string
+			src.Get(&want, query)
+			dst.Get(&got, query)
+			if want != got {
+				t.Errorf("restored rows = %q, want %q", got, want)
+			}
+			var seq int
+			dst.Get(&seq, "SELECT seq FROM sqlite_sequence WHERE name = 'events';")
+			if seq != 3 {
+				t.Errorf("restored sqlite_sequence = %d, want 3", seq)
+			}
+		})
+	}
+}
+
+func TestDumpDB(t *testing.T) {
+	root := t.TempDir()
+	newWatchTestDB(t, filepath.Join(root, "dumped.db"))
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+	test_app.db.MustExec("INSERT INTO dumped.rows (id) VALUES (1), (2);")
+
+	var buf bytes.Buffer
+	if err := test_app.dumpDB(&buf, "dumped", DumpOptions{}); err != nil {
+		t.Fatalf("dumpDB() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "INSERT INTO \"rows\"") {
+		t.Errorf("dumpDB() wrote no rows:\n%s", buf.String())
+	}
+	// the read transaction is over once the dump is
+	if _, err := test_app.db.Exec("INSERT INTO dumped.rows (id) VALUES (3);"); err != nil {
+		t.Errorf("writing after the dump failed: %v", err)
+	}
+
+	// failed dumps leave no file behind
+	out := t.TempDir()
+	bad := DumpOptions{SchemaOnly: true, DataOnly: true}
+	if err := test_app.exportDumpZip(filepath.Join(out, "dump.zip"), bad); err == nil {
+		t.Error("exportDumpZip() accepted conflicting options")
+	}
+	if res := test_app.ExportSQLDump(DumpRequest{DB: "dumped", Path: filepath.Join(out, "dumped.sql"), SchemaOnly: true, DataOnly: true}); res.Err == nil {
+		t.Error("ExportSQLDump() accepted conflicting options")
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 0 {
+		t.Errorf("failed dumps left %v behind", entries)
+	}
+}
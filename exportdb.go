@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	EXPORT_DB_MERGE    = "merge"
+	EXPORT_DB_SEPARATE = "separate"
+)
+
+// captures everything up to and including the object name of a CREATE
+// INDEX, VIEW or TRIGGER statement
+var createNameRegex = regexp.MustCompile("(?is)^\\s*CREATE\\s+(?:UNIQUE\\s+|TEMP\\s+|TEMPORARY\\s+)?(?:INDEX|VIEW|TRIGGER)\\s+(?:IF\\s+NOT\\s+EXISTS\\s+)?(\"(?:[^\"]|\"\")*\"|\\[[^\\]]*\\]|`[^`]*`|[^\\s(]+)")
+
+// replaces the object name in a CREATE INDEX, VIEW or TRIGGER statement
+func renameCreateStatement(createSQL string, newName string) (string, error) {
+	loc := createNameRegex.FindStringSubmatchIndex(createSQL)
+	if loc == nil {
+		return "", fmt.Errorf("cannot find object name in %q", createSQL)
+	}
+	return createSQL[:loc[2]] + quoteIdent(newName) + createSQL[loc[3]:], nil
+}
+
+// replaces the table or view a CREATE TRIGGER statement is on
+func retargetTrigger(createSQL string, table string) (string, error) {
+	loc := createNameRegex.FindStringIndex(createSQL)
+	if loc == nil {
+		return "", fmt.Errorf("cannot find trigger name in %q", createSQL)
+	}
+	for i := loc[1]; i < len(createSQL); {
+		switch c := createSQL[i]; {
+		case closingQuote(c) != 0:
+			i = skipQuoted(createSQL, i)
+		case isIdentByte(c):
+			start := i
+			for i < len(createSQL) && isIdentByte(createSQL[i]) {
+				i++
+			}
+			if !strings.EqualFold(createSQL[start:i], "ON") {
+				continue
+			}
+			// the name follows ON, qualified or not
+			for i < len(createSQL) && unicode.IsSpace(rune(createSQL[i])) {
+				i++
+			}
+			end := i
+			for end < len(createSQL) {
+				if q := closingQuote(createSQL[end]); q != 0 && q != '\'' {
+					end = skipQuoted(createSQL, end)
+				} else if isIdentByte(createSQL[end]) || createSQL[end] == '.' {
+					end++
+				} else {
+					break
+				}
+			}
+			return createSQL[:i] + quoteIdent(table) + createSQL[end:], nil
+		default:
+			i++
+		}
+	}
+	return "", fmt.Errorf("cannot find the table of %q", createSQL)
+}
+
+// renames the view name to prefix+name. SQLite only rewrites the views and
+// triggers that select from an object when a table is renamed, so an empty
+// table with the view's columns stands in for it while it is. The INSTEAD OF
+// triggers of the view move along with it.
+func renamePrefixedView(tx *sqlx.Tx, name string, prefix string) ([]string, error) {
+	newName := prefix + name
+	var viewSQL string
+	if err := tx.Get(&viewSQL, "SELECT sql FROM main.sqlite_master WHERE type = 'view' AND name = ?;", name); err != nil {
+		return nil, err
+	}
+	var triggers []struct {
+		Name string `db:"name"`
+		SQL  string `db:"sql"`
+	}
+	if err := tx.Select(&triggers, "SELECT name, sql FROM main.sqlite_master WHERE type = 'trigger' AND tbl_name = ?;", name); err != nil {
+		return nil, err
+	}
+	standIn := quoteIdent("sqlitegui_view_" + newName)
+	stmts := []string{
+		fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s LIMIT 0;", standIn, quoteIdent(name)),
+		fmt.Sprintf("DROP VIEW %s;", quoteIdent(name)),
+		// until the stand-in takes the view's name the views selecting from
+		// it do not resolve, which the current ALTER TABLE refuses
+		"PRAGMA legacy_alter_table = ON;",
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", standIn, quoteIdent(name)),
+		"PRAGMA legacy_alter_table = OFF;",
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", quoteIdent(name), quoteIdent(newName)),
+		fmt.Sprintf("DROP TABLE %s;", quoteIdent(newName)),
+	}
+	renamed, err := renameCreateStatement(viewSQL, newName)
+	if err != nil {
+		return nil, err
+	}
+	stmts = append(stmts, renamed)
+	var moved []string
+	for _, trigger := range triggers {
+		renamed, err := renameCreateStatement(trigger.SQL, prefix+trigger.Name)
+		if err == nil {
+			renamed, err = retargetTrigger(renamed, newName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, renamed)
+		moved = append(moved, trigger.Name)
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return moved, nil
+}
+
+// rebuilds every object of schema in dst from its original CREATE statement
+// and copies the rows of srcPath across. With a prefix, tables and then views
+// are renamed afterwards with ALTER TABLE so SQLite rewrites foreign keys,
+// views and triggers that reference them; indexes and triggers are recreated
+// under prefixed names.
+func copyDatabaseInto(dst *sqlx.DB, srcPath string, schema SchemaSnapshot, prefix string) error {
+	if _, err := dst.Exec(fmt.Sprintf("ATTACH %s AS src;", sqlLiteral(srcPath))); err != nil {
+		return err
+	}
+	attached := true
+	detach := func() error {
+		if !attached {
+			return nil
+		}
+		attached = false
+		_, err := dst.Exec("DETACH DATABASE src;")
+		return err
+	}
+	defer detach()
+
+	var shadowTables []string
+	if err := dst.Select(&shadowTables, "SELECT name FROM pragma_table_list WHERE schema = 'src' AND type = 'shadow';"); err != nil {
+		return err
+	}
+	tx, err := dst.Beginx()
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for _, obj := range schema.Objects {
+		if obj.Type != "table" || slices.Contains(shadowTables, obj.Name) {
+			continue
+		}
+		if _, err := tx.Exec(obj.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("creating %s: %w", obj.Name, err)
+		}
+		var cols []string
+		for _, col := range obj.Columns {
+			if col.Hidden == 0 {
+				cols = append(cols, quoteIdent(col.Name))
+			}
+		}
+		if len(cols) > 0 {
+			colList := strings.Join(cols, ", ")
+			if _, err := tx.Exec(fmt.Sprintf(
+				"INSERT INTO main.%s (%s) SELECT %s FROM src.%s;",
+				quoteIdent(obj.Name), colList, colList, quoteIdent(obj.Name),
+			)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("copying %s: %w", obj.Name, err)
+			}
+		}
+		tables = append(tables, obj.Name)
+	}
+	var hasSequence int
+	if err := tx.Get(&hasSequence, "SELECT COUNT(*) FROM src.sqlite_master WHERE name = 'sqlite_sequence';"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if hasSequence > 0 {
+		if _, err := tx.Exec("DELETE FROM main.sqlite_sequence WHERE name IN (SELECT name FROM src.sqlite_sequence);"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO main.sqlite_sequence (name, seq) SELECT name, seq FROM src.sqlite_sequence;"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, objType := range []string{"index", "view", "trigger"} {
+		for _, obj := range schema.Objects {
+			if obj.Type != objType {
+				continue
+			}
+			if _, err := tx.Exec(obj.SQL); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("creating %s %s: %w", objType, obj.Name, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := detach(); err != nil {
+		return err
+	}
+	if prefix == "" {
+		return nil
+	}
+
+	tx, err = dst.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, name := range tables {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", quoteIdent(name), quoteIdent(prefix+name))); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("renaming %s: %w", name, err)
+		}
+	}
+	var moved []string
+	for _, obj := range schema.Objects {
+		if obj.Type != "view" {
+			continue
+		}
+		triggers, err := renamePrefixedView(tx, obj.Name, prefix)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("renaming view %s: %w", obj.Name, err)
+		}
+		moved = append(moved, triggers...)
+	}
+	for _, objType := range []string{"trigger", "index"} {
+		for _, obj := range schema.Objects {
+			if obj.Type != objType || slices.Contains(moved, obj.Name) {
+				continue
+			}
+			// the statement has to be re-read since renaming tables rewrote it
+			var current string
+			if err := tx.Get(&current, "SELECT sql FROM main.sqlite_master WHERE type = ? AND name = ?;", objType, obj.Name); err != nil {
+				tx.Rollback()
+				return err
+			}
+			renamed, err := renameCreateStatement(current, prefix+obj.Name)
+			if err == nil {
+				_, err = tx.Exec(fmt.Sprintf("DROP %s %s;", strings.ToUpper(objType), quoteIdent(obj.Name)))
+			}
+			if err == nil {
+				_, err = tx.Exec(renamed)
+			}
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("renaming %s %s: %w", objType, obj.Name, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// exports every stored database to a new SQLite file. In merge mode all of
+// them go into path with <db>_ prefixed object names; in separate mode each
+// database is written to its own <path>_<db>.db file with its original names.
+// Returns the files written.
+func (a *App) exportNewDB(path string, mode string) ([]string, error) {
+	if mode == "" {
+		mode = EXPORT_DB_MERGE
+	}
+	if mode != EXPORT_DB_MERGE && mode != EXPORT_DB_SEPARATE {
+		return nil, fmt.Errorf("unknown export mode %q", mode)
+	}
+	dbs, err := a.getRegisteredDBs()
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if ext == "" {
+		ext = ".db"
+	}
+
+	var written []string
+	var dst *sqlx.DB
+	defer func() {
+		if dst != nil {
+			dst.Close()
+		}
+	}()
+	for _, db := range dbs {
+		schemaName, err := a.getSQLiteDBName(db.Path)
+		if err != nil {
+			return written, fmt.Errorf("%s is not stored: %w", db.Name, err)
+		}
+		schema, err := loadSchema(a.db, schemaName)
+		if err != nil {
+			return written, err
+		}
+		if db.Path == a.getNewDBPath(a.rootDBName) {
+			schema.Objects = slices.DeleteFunc(schema.Objects, func(obj SchemaObject) bool {
+				return slices.Contains(SYSTEM_TABLES[:], obj.Table)
+			})
+		}
+
+		target := path
+		prefix := db.Name + "_"
+		if mode == EXPORT_DB_SEPARATE {
+			target = fmt.Sprintf("%s_%s%s", base, db.Name, ext)
+			prefix = ""
+		}
+		if dst == nil || mode == EXPORT_DB_SEPARATE {
+			if dst != nil {
+				dst.Close()
+			}
+			if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return written, err
+			}
+			if dst, err = sqlx.Connect(SQLITE_DRIVER, target); err != nil {
+				return written, err
+			}
+			// ATTACH and the renames below must all happen on one connection
+			dst.SetMaxOpenConns(1)
+			written = append(written, target)
+		}
+		if err := copyDatabaseInto(dst, db.Path, schema, prefix); err != nil {
+			return written, fmt.Errorf("exporting %s: %w", db.Name, err)
+		}
+		a.logger.Info(fmt.Sprintf("Successfully exported database: %s", db.Name))
+	}
+	return written, nil
+}
+
+// exports the workspace to req.Path, see exportNewDB for the modes
+func (a *App) ExportNewDB(req ExportDBRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	files, err := a.exportNewDB(req.Path, req.Mode)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"files": files}, nil)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRenameCreateStatement(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE INDEX idx ON t(a)", `CREATE INDEX "p_idx" ON t(a)`},
+		{"CREATE UNIQUE INDEX IF NOT EXISTS \"my idx\" ON t(a)", `CREATE UNIQUE INDEX IF NOT EXISTS "p_idx" ON t(a)`},
+		{"CREATE VIEW v AS SELECT 1", `CREATE VIEW "p_idx" AS SELECT 1`},
+		{"create trigger [trg] after insert on t begin select 1; end", `create trigger "p_idx" after insert on t begin select 1; end`},
+	}
+	for _, tt := range tests {
+		got, err := renameCreateStatement(tt.sql, "p_idx")
+		if err != nil || got != tt.want {
+			t.Errorf("renameCreateStatement(%q) = %q, %v, want %q", tt.sql, got, err, tt.want)
+		}
+	}
+}
+
+func TestExportNewDB(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"shop", "warehouse"} {
+		db, err := sqlx.Open(SQLITE_DRIVER, filepath.Join(root, name+".db"))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		db.MustExec(`
+		CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, sku TEXT NOT NULL UNIQUE, qty INTEGER DEFAULT 0 CHECK (qty >= 0));
+		CREATE TABLE stock (item_id INTEGER REFERENCES items(id), bin TEXT, PRIMARY KEY (item_id, bin));
+		CREATE INDEX stock_bin ON stock(bin);
+		CREATE VIEW low AS SELECT * FROM items WHERE qty < 5;
+		CREATE TRIGGER items_gone AFTER DELETE ON items BEGIN DELETE FROM stock WHERE item_id = old.id; END;
+		INSERT INTO items (sku, qty) VALUES ('a', 1), ('b', 10);
+		INSERT INTO stock VALUES (1, 'x'), (2, 'y');
+		`)
+		db.Close()
+	}
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	t.Run("Merge", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.db")
+		files, err := test_app.exportNewDB(path, EXPORT_DB_MERGE)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("exportNewDB() = %v, %v", files, err)
+		}
+		db := sqlx.MustOpen(SQLITE_DRIVER, path)
+		defer db.Close()
+		var fk string
+		if err := db.Get(&fk, `SELECT sql FROM sqlite_master WHERE name = 'warehouse_stock';`); err != nil {
+			t.Fatalf("warehouse_stock missing: %v", err)
+		}
+		if !strings.Contains(fk, `"warehouse_items"`) || !strings.Contains(fk, "PRIMARY KEY (item_id, bin)") {
+			t.Errorf("foreign key or composite key not preserved: %s", fk)
+		}
+		var objects int
+		db.Get(&objects, "SELECT COUNT(*) FROM sqlite_master WHERE name IN ('shop_stock_bin', 'shop_low', 'shop_items_gone', 'warehouse_low');")
+		if objects != 4 {
+			t.Errorf("got %d prefixed indexes/views/triggers, want 4", objects)
+		}
+		var low int
+		if err := db.Get(&low, "SELECT COUNT(*) FROM shop_low;"); err != nil || low != 1 {
+			t.Errorf("shop_low = %d (%v), want 1", low, err)
+		}
+	})
+
+	t.Run("Separate", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "export.db")
+		files, err := test_app.exportNewDB(path, EXPORT_DB_SEPARATE)
+		if err != nil || len(files) != 2 {
+			t.Fatalf("exportNewDB() = %v, %v", files, err)
+		}
+		original, err := loadSchema(test_app.db, "shop")
+		if err != nil {
+			t.Fatalf("loadSchema() error = %v", err)
+		}
+		db := sqlx.MustOpen(SQLITE_DRIVER, strings.TrimSuffix(path, ".db")+"_shop.db")
+		defer db.Close()
+		exported, err := loadSchema(db, "main")
+		if err != nil {
+			t.Fatalf("loadSchema() error = %v", err)
+		}
+		if diff := diffSchemas(original, exported); !diff.Empty() {
+			t.Errorf("exported schema differs: %+v", diff)
+		}
+		var seq int
+		db.Get(&seq, "SELECT seq FROM sqlite_sequence WHERE name = 'items';")
+		if seq != 2 {
+			t.Errorf("sqlite_sequence = %d, want 2", seq)
+		}
+	})
+}
+
+func TestRetargetTrigger(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE TRIGGER trg INSTEAD OF INSERT ON v BEGIN SELECT 1; END", `CREATE TRIGGER trg INSTEAD OF INSERT ON "p_v" BEGIN SELECT 1; END`},
+		{`create trigger "on" instead of update of a on "my view" for each row begin select 1; end`, `create trigger "on" instead of update of a on "p_v" for each row begin select 1; end`},
+		{"CREATE TRIGGER [x] AFTER DELETE ON main.[t]\nBEGIN DELETE FROM u; END", "CREATE TRIGGER [x] AFTER DELETE ON \"p_v\"\nBEGIN DELETE FROM u; END"},
+	}
+	for _, tt := range tests {
+		got, err := retargetTrigger(tt.sql, "p_v")
+		if err != nil || got != tt.want {
+			t.Errorf("retargetTrigger(%q) = %q, %v, want %q", tt.sql, got, err, tt.want)
+		}
+	}
+}
+
+func TestExportNewDBNestedViews(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"east", "west"} {
+		db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, name+".db"))
+		db.MustExec(`
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, total INTEGER);
+		CREATE TABLE audit (n INTEGER);
+		CREATE VIEW v1 AS SELECT id, total FROM orders WHERE total > 0;
+		CREATE VIEW v2 AS SELECT COUNT(*) AS n FROM v1;
+		CREATE TRIGGER v1_insert INSTEAD OF INSERT ON v1 BEGIN INSERT INTO orders (total) VALUES (new.total); END;
+		CREATE TRIGGER orders_audit AFTER INSERT ON orders BEGIN INSERT INTO audit SELECT n FROM v2; END;
+		INSERT INTO orders (total) VALUES (5), (0), (7);
+		`)
+		db.Close()
+	}
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "export.db")
+	if files, err := test_app.exportNewDB(path, EXPORT_DB_MERGE); err != nil || len(files) != 1 {
+		t.Fatalf("exportNewDB() = %v, %v", files, err)
+	}
+	db := sqlx.MustOpen(SQLITE_DRIVER, path)
+	defer db.Close()
+	var names []string
+	db.Select(&names, "SELECT name FROM sqlite_master WHERE type IN ('view', 'trigger') ORDER BY name;")
+	want := []string{"east_orders_audit", "east_v1", "east_v1_insert", "east_v2", "west_orders_audit", "west_v1", "west_v1_insert", "west_v2"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("views and triggers %v, want %v", names, want)
+	}
+	for _, prefix := range []string{"east_", "west_"} {
+		var n int
+		if err := db.Get(&n, "SELECT n FROM "+prefix+"v2;"); err != nil || n != 2 {
+			t.Errorf("%sv2 = %d, %v, want 2", prefix, n, err)
+		}
+		// the INSTEAD OF trigger moved with its view and the audit trigger reads the renamed view
+		if _, err := db.Exec("INSERT INTO " + prefix + "v1 (total) VALUES (9);"); err != nil {
+			t.Errorf("insert into %sv1: %v", prefix, err)
+		}
+		if err := db.Get(&n, "SELECT MAX(n) FROM "+prefix+"audit;"); err != nil || n != 3 {
+			t.Errorf("%saudit = %d, %v, want 3", prefix, n, err)
+		}
+	}
+	if _, err := db.Exec("ALTER TABLE east_audit RENAME TO east_log;"); err != nil {
+		t.Errorf("the exported schema does not resolve: %v", err)
+	}
+}
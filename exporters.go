@@ -0,0 +1,507 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rowWriter streams a result set in some output format. Close finishes the
+// document; it does not close the underlying writer.
+type rowWriter interface {
+	WriteHeader(cols []string) error
+	WriteRow(values []any) error
+	Close() error
+}
+
+// implemented by writers that need the declared column types, which
+// streamRows passes before WriteHeader
+type columnTypeSetter interface {
+	SetColumnTypes(types []*sql.ColumnType)
+}
+
+// implemented by writers holding resources besides their output, which
+// streamRows releases when the rows fail before Close
+type rowDiscarder interface {
+	Discard()
+}
+
+type ExportProgress struct {
+	DB     string `json:"db"`
+	Table  string `json:"table"`
+	Rows   int64  `json:"rows"`
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+	Format string `json:"format"`
+}
+
+const (
+	FORMAT_CSV      = ".csv"
+	FORMAT_TSV      = ".tsv"
+	FORMAT_JSON     = ".json"
+	FORMAT_NDJSON   = ".ndjson"
+	FORMAT_SQL      = ".sql"
+	FORMAT_MARKDOWN = ".md"
+	FORMAT_HTML     = ".html"
+	FORMAT_XLSX     = ".xlsx"
+
+	defaultExportTable = "query_result"
+)
+
+var formatAliases = map[string]string{
+	".jsonl":    FORMAT_NDJSON,
+	".markdown": FORMAT_MARKDOWN,
+	".htm":      FORMAT_HTML,
+}
+
+// ExportOptions configures newRowWriter. CSV also sets the NULL, BLOB and date
+// formatting of the text based formats; Table is the target of SQL INSERTs.
+type ExportOptions struct {
+	CSV   CSVOptions
+	Table string
+	// parquet compression codec, snappy when empty
+	Compression string
+}
+
+// accepts a format with or without its leading dot, as well as common aliases
+func normalizeExportFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "" && !strings.HasPrefix(format, ".") {
+		format = "." + format
+	}
+	if alias, ok := formatAliases[format]; ok {
+		return alias
+	}
+	return format
+}
+
+func newRowWriter(w io.Writer, format string, opts ExportOptions) (rowWriter, error) {
+	switch format {
+	case FORMAT_CSV:
+		return newCSVRowWriter(w, opts.CSV)
+	case FORMAT_TSV:
+		opts.CSV.Delimiter = "\t"
+		return newCSVRowWriter(w, opts.CSV)
+	case FORMAT_JSON:
+		return &jsonRowWriter{w: bufio.NewWriter(w)}, nil
+	case FORMAT_NDJSON:
+		return &jsonRowWriter{w: bufio.NewWriter(w), lines: true}, nil
+	case FORMAT_SQL:
+		table := opts.Table
+		if table == "" {
+			table = defaultExportTable
+		}
+		return &sqlRowWriter{w: bufio.NewWriter(w), table: table}, nil
+	case FORMAT_PARQUET:
+		return newParquetRowWriter(w, opts.Compression)
+	case FORMAT_XLSX:
+		book := newXLSXWriter(w)
+		sheet, err := book.AddSheet(opts.Table)
+		if err != nil {
+			return nil, err
+		}
+		sheet.book = book
+		return sheet, nil
+	case FORMAT_MARKDOWN, FORMAT_HTML:
+		csvOpts, err := opts.CSV.normalize()
+		if err != nil {
+			return nil, err
+		}
+		if format == FORMAT_HTML {
+			return &htmlRowWriter{w: bufio.NewWriter(w), opts: csvOpts}, nil
+		}
+		return &markdownRowWriter{w: bufio.NewWriter(w), opts: csvOpts}, nil
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+}
+
+// writes a JSON array of objects whose keys keep the column order, or one
+// object per line when lines is set
+type jsonRowWriter struct {
+	w     *bufio.Writer
+	keys  [][]byte
+	rows  int64
+	lines bool
+	buf   bytes.Buffer
+	enc   *json.Encoder
+}
+
+// encodes v without escaping <, > and &, which only matters inside HTML
+func (j *jsonRowWriter) marshal(v any) ([]byte, error) {
+	if j.enc == nil {
+		j.enc = json.NewEncoder(&j.buf)
+		j.enc.SetEscapeHTML(false)
+	}
+	j.buf.Reset()
+	if err := j.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(j.buf.Bytes(), []byte("\n")), nil
+}
+
+func (j *jsonRowWriter) WriteHeader(cols []string) error {
+	j.keys = make([][]byte, len(cols))
+	for i, col := range cols {
+		key, err := j.marshal(col)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = append(slices.Clone(key), ':')
+	}
+	if j.lines {
+		return nil
+	}
+	return j.w.WriteByte('[')
+}
+
+func (j *jsonRowWriter) WriteRow(values []any) error {
+	if j.rows > 0 && !j.lines {
+		j.w.WriteByte(',')
+	}
+	j.rows++
+	j.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		j.w.Write(j.keys[i])
+		body, err := j.marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := j.w.Write(body); err != nil {
+			return err
+		}
+	}
+	if j.lines {
+		_, err := j.w.WriteString("}\n")
+		return err
+	}
+	return j.w.WriteByte('}')
+}
+
+func (j *jsonRowWriter) Close() error {
+	if j.lines {
+		return j.w.Flush()
+	}
+	if j.keys == nil {
+		j.w.WriteByte('[')
+	}
+	j.w.WriteByte(']')
+	return j.w.Flush()
+}
+
+// writes one INSERT statement per row
+type sqlRowWriter struct {
+	w      *bufio.Writer
+	table  string
+	prefix string
+}
+
+func (s *sqlRowWriter) WriteHeader(cols []string) error {
+	s.prefix = fmt.Sprintf("INSERT INTO %s (%s) VALUES (", quoteIdent(s.table), strings.Join(quoteIdents(cols), ", "))
+	return nil
+}
+
+func (s *sqlRowWriter) WriteRow(values []any) error {
+	s.w.WriteString(s.prefix)
+	for i, v := range values {
+		if i > 0 {
+			s.w.WriteString(", ")
+		}
+		s.w.WriteString(sqlLiteral(v))
+	}
+	_, err := s.w.WriteString(");\n")
+	return err
+}
+
+func (s *sqlRowWriter) Close() error {
+	return s.w.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+// writes a GitHub flavoured Markdown table
+type markdownRowWriter struct {
+	w    *bufio.Writer
+	opts CSVOptions
+}
+
+func (m *markdownRowWriter) writeCells(cells []string) error {
+	for _, cell := range cells {
+		m.w.WriteString("| ")
+		m.w.WriteString(markdownEscaper.Replace(cell))
+		m.w.WriteByte(' ')
+	}
+	_, err := m.w.WriteString("|\n")
+	return err
+}
+
+func (m *markdownRowWriter) WriteHeader(cols []string) error {
+	if err := m.writeCells(cols); err != nil {
+		return err
+	}
+	for range cols {
+		m.w.WriteString("| --- ")
+	}
+	_, err := m.w.WriteString("|\n")
+	return err
+}
+
+func (m *markdownRowWriter) WriteRow(values []any) error {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i], _ = m.opts.formatValue(v)
+	}
+	return m.writeCells(cells)
+}
+
+func (m *markdownRowWriter) Close() error {
+	return m.w.Flush()
+}
+
+// writes a standalone HTML <table>
+type htmlRowWriter struct {
+	w    *bufio.Writer
+	opts CSVOptions
+}
+
+func (h *htmlRowWriter) WriteHeader(cols []string) error {
+	h.w.WriteString("<table>\n<thead>\n<tr>")
+	for _, col := range cols {
+		fmt.Fprintf(h.w, "<th>%s</th>", html.EscapeString(col))
+	}
+	_, err := h.w.WriteString("</tr>\n</thead>\n<tbody>\n")
+	return err
+}
+
+func (h *htmlRowWriter) WriteRow(values []any) error {
+	h.w.WriteString("<tr>")
+	for _, v := range values {
+		text, _ := h.opts.formatValue(v)
+		fmt.Fprintf(h.w, "<td>%s</td>", html.EscapeString(text))
+	}
+	_, err := h.w.WriteString("</tr>\n")
+	return err
+}
+
+func (h *htmlRowWriter) Close() error {
+	h.w.WriteString("</tbody>\n</table>\n")
+	return h.w.Flush()
+}
+
+// runs query and writes every row to w straight from the cursor, so memory
+// use does not grow with the size of the result. Returns the row count.
+func streamRows(q sqlx.Queryer, query string, w rowWriter) (n int64, err error) {
+	defer func() {
+		if discarder, ok := w.(rowDiscarder); ok && err != nil {
+			discarder.Discard()
+		}
+	}()
+	rows, err := q.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if setter, ok := w.(columnTypeSetter); ok {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			return 0, err
+		}
+		setter.SetColumnTypes(types)
+	}
+	if err := w.WriteHeader(cols); err != nil {
+		return 0, err
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		if err := w.WriteRow(values); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// writes every table of every stored database into a zip at path, one
+// <db>/<table><ext> entry per table, emitting EXPORT_PROGRESS after each table
+func (a *App) exportToZip(path string, ext string, csvOpts CSVOptions) (err error) {
+	if ext != FORMAT_CSV && ext != FORMAT_JSON && ext != FORMAT_PARQUET {
+		return errors.New("invalid format")
+	}
+	if _, err := csvOpts.normalize(); err != nil {
+		return err
+	}
+	dbs, err := a.getSQLiteDBNames()
+	if err != nil {
+		return err
+	}
+	tables := make(map[string][]string)
+	total := 0
+	for _, dbName := range dbs {
+		if tables[dbName], err = a.getTableList(dbName); err != nil {
+			return err
+		}
+		total += len(tables[dbName])
+	}
+
+	zipFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := zipFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// a partial export is worse than none
+			os.Remove(path)
+		}
+	}()
+	zipWriter := zip.NewWriter(zipFile)
+
+	done := 0
+	for _, dbName := range dbs {
+		for _, tblName := range tables[dbName] {
+			file, err := zipWriter.Create(zipEntryName(dbName, tblName) + ext)
+			if err != nil {
+				return err
+			}
+			w, err := newRowWriter(file, ext, ExportOptions{CSV: csvOpts, Table: tblName})
+			if err != nil {
+				return err
+			}
+			n, err := streamRows(a.db, fmt.Sprintf("SELECT * FROM %s;", quoteQualified(dbName, tblName)), w)
+			if err != nil {
+				return fmt.Errorf("exporting %s.%s: %w", dbName, tblName, err)
+			}
+			done++
+			a.emitData(EXPORT_PROGRESS, ExportProgress{
+				DB: dbName, Table: tblName, Rows: n, Done: done, Total: total, Format: ext,
+			})
+		}
+	}
+	return zipWriter.Close()
+}
+
+// zip entries always use forward slashes, whatever the host OS
+func zipEntryName(parts ...string) string {
+	return path.Join(parts...)
+}
+
+// exports every table to a zip at req.Path. CSV files use req.CSV when given,
+// otherwise the named req.Preset.
+func (a *App) ExportTablesZip(req TablesExportRequest) AppResult {
+	if req.Path == "" || (req.Format != FORMAT_CSV && req.Format != FORMAT_JSON && req.Format != FORMAT_PARQUET) {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	var opts CSVOptions
+	if req.CSV != nil {
+		opts = *req.CSV
+	} else {
+		var err error
+		if opts, err = a.getCSVPreset(req.Preset); err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+	}
+	if err := a.exportToZip(req.Path, req.Format, opts); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": req.Path}, nil)
+}
+
+// streams the rows of query into a new file at path
+func (a *App) exportQuery(query string, format string, path string, opts ExportOptions) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	w, err := newRowWriter(file, format, opts)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return 0, err
+	}
+	n, err := streamRows(a.db, query, w)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// a partial export is worse than none
+		os.Remove(path)
+		return n, err
+	}
+	a.emitData(EXPORT_PROGRESS, ExportProgress{Table: opts.Table, Rows: n, Done: 1, Total: 1, Format: format})
+	return n, nil
+}
+
+func (a *App) exportQueryResult(query string, format string, path string, table string) AppResult {
+	format = normalizeExportFormat(format)
+	if query == "" || path == "" || format == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	n, err := a.exportQuery(query, format, path, ExportOptions{Table: table})
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": path, "rows": n}, nil)
+}
+
+// only single queries that read data can be exported, as a file holds the
+// rows of one result set
+func (a *App) isExportableQuery(query string) bool {
+	return a.isReadOnlyQuery(query)
+}
+
+// saves the result of a read-only editor query to path. format is one of csv,
+// tsv, json, ndjson, sql, md, html, xlsx or parquet.
+func (a *App) ExportQueryResult(query string, format string, path string) AppResult {
+	query = cleanQuery(query)
+	if !a.isExportableQuery(query) {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	table := defaultExportTable
+	if match := a.pkRegex.FindStringSubmatch(query); match != nil {
+		table = match[1]
+	}
+	return a.exportQueryResult(query, format, path, table)
+}
+
+// saves every row of db.table to path, see ExportQueryResult for the formats
+func (a *App) ExportTable(db string, table string, format string, path string) AppResult {
+	if db == "" || table == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	query := fmt.Sprintf("SELECT * FROM %s;", quoteQualified(db, table))
+	return a.exportQueryResult(query, format, path, table)
+}
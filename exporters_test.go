@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestStreamRows(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec(`
+	CREATE TABLE people (zeta TEXT, alpha INTEGER, "mid, col" REAL);
+	INSERT INTO people VALUES ('ann', 1, 1.5), ('bob "b"', 2, NULL);
+	CREATE TABLE empty (a, b);
+	`)
+
+	tests := []struct {
+		name   string
+		format string
+		table  string
+		want   string
+		rows   int64
+	}{
+		{"CSV keeps column order", ".csv", "people", "zeta,alpha,\"mid, col\"\nann,1,1.5\n\"bob \"\"b\"\"\",2,\n", 2},
+		{"JSON keeps key order", ".json", "people", `[{"zeta":"ann","alpha":1,"mid, col":1.5},{"zeta":"bob \"b\"","alpha":2,"mid, col":null}]`, 2},
+		{"CSV empty table", ".csv", "empty", "a,b\n", 0},
+		{"JSON empty table", ".json", "empty", "[]", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRowWriter(&buf, tt.format, ExportOptions{})
+			if err != nil {
+				t.Fatalf("newRowWriter() error = %v", err)
+			}
+			n, err := streamRows(db, "SELECT * FROM "+tt.table+";", w)
+			if err != nil {
+				t.Fatalf("streamRows() error = %v", err)
+			}
+			if n != tt.rows {
+				t.Errorf("streamRows() rows = %d, want %d", n, tt.rows)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("streamRows() wrote %q, want %q", buf.String(), tt.want)
+			}
+			if tt.format == ".json" && !json.Valid(buf.Bytes()) {
+				t.Errorf("streamRows() wrote invalid JSON: %s", buf.String())
+			}
+		})
+	}
+
+	if _, err := newRowWriter(&bytes.Buffer{}, ".xml", ExportOptions{}); err == nil {
+		t.Error("newRowWriter(.xml) expected an error")
+	}
+}
+
+func TestCSVDialect(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec(`
+	CREATE TABLE t (name TEXT, n INTEGER, at DATETIME, data BLOB);
+	INSERT INTO t VALUES ('a;b', 7, '2024-03-01 10:00:00', X'00FF'), (NULL, NULL, NULL, NULL);
+	`)
+
+	tests := []struct {
+		name    string
+		opts    CSVOptions
+		want    string
+		wantErr bool
+	}{
+		{"Defaults", CSVOptions{}, "name,n,at,data\na;b,7,2024-03-01 10:00:00+00:00,00ff\n,,,\n", false},
+		{"Excel semicolon", CSVOptions{Delimiter: ";", LineEnding: LINE_ENDING_CRLF, BOM: true, DateFormat: "02/01/2006"}, "\xEF\xBB\xBFname;n;at;data\r\n\"a;b\";7;01/03/2024;00ff\r\n;;;\r\n", false},
+		{"Quote all, base64, no header", CSVOptions{Quote: QUOTE_ALL, Blob: BLOB_BASE64, SkipHeader: true, Null: "NULL"}, "\"a;b\",\"7\",\"2024-03-01 10:00:00+00:00\",\"AP8=\"\n\"NULL\",\"NULL\",\"NULL\",\"NULL\"\n", false},
+		{"Quote non-numeric, skip blobs", CSVOptions{Quote: QUOTE_NONNUMERIC, Blob: BLOB_SKIP, Null: `\N`}, "\"name\",\"n\",\"at\",\"data\"\n\"a;b\",7,\"2024-03-01 10:00:00+00:00\",\"\"\n\\N,\\N,\\N,\\N\n", false},
+		{"Quote none", CSVOptions{Quote: QUOTE_NONE, Delimiter: ";"}, "", true},
+		{"Bad delimiter", CSVOptions{Delimiter: "::"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRowWriter(&buf, ".csv", ExportOptions{CSV: tt.opts})
+			if err == nil {
+				_, err = streamRows(db, "SELECT * FROM t;", w)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("export error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && buf.String() != tt.want {
+				t.Errorf("export wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVPresets(t *testing.T) {
+	defer test_app.db.Exec("DELETE FROM main.export_presets;")
+	opts := CSVOptions{Delimiter: "|", Null: "-"}
+	if err := test_app.saveCSVPreset("finance", opts); err != nil {
+		t.Fatalf("saveCSVPreset() error = %v", err)
+	}
+	if err := test_app.saveCSVPreset("excel", opts); err == nil {
+		t.Error("saveCSVPreset() overwrote a built-in preset")
+	}
+	if err := test_app.saveCSVPreset("broken", CSVOptions{Quote: "sometimes"}); err == nil {
+		t.Error("saveCSVPreset() accepted an invalid quoting style")
+	}
+	got, err := test_app.getCSVPreset("finance")
+	if err != nil || got != opts {
+		t.Errorf("getCSVPreset() = %+v, %v, want %+v", got, err, opts)
+	}
+	if res := test_app.DeleteCSVPreset("finance"); res.Err != nil {
+		t.Errorf("DeleteCSVPreset() error = %v", res.Err)
+	}
+	if _, err := test_app.getCSVPreset("finance"); err == nil {
+		t.Error("getCSVPreset() found a deleted preset")
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec(`
+	CREATE TABLE t (name TEXT, n INTEGER);
+	INSERT INTO t VALUES ('a|<b>', 1), ('it''s
+x', NULL);
+	`)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"tsv", "name\tn\na|<b>\t1\n\"it's\nx\"\t\n"},
+		{"jsonl", "{\"name\":\"a|<b>\",\"n\":1}\n{\"name\":\"it's\\nx\",\"n\":null}\n"},
+		{"SQL", "INSERT INTO \"t\" (\"name\", \"n\") VALUES ('a|<b>', 1);\nINSERT INTO \"t\" (\"name\", \"n\") VALUES ('it''s\nx', NULL);\n"},
+		{".md", "| name | n |\n| --- | --- |\n| a\\|<b> | 1 |\n| it's<br>x |  |\n"},
+		{"html", "<table>\n<thead>\n<tr><th>name</th><th>n</th></tr>\n</thead>\n<tbody>\n<tr><td>a|&lt;b&gt;</td><td>1</td></tr>\n<tr><td>it&#39;s\nx</td><td></td></tr>\n</tbody>\n</table>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRowWriter(&buf, normalizeExportFormat(tt.format), ExportOptions{Table: "t"})
+			if err != nil {
+				t.Fatalf("newRowWriter() error = %v", err)
+			}
+			if _, err := streamRows(db, "SELECT * FROM t;", w); err != nil {
+				t.Fatalf("streamRows() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("export wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestExportQueryResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.ndjson")
+	res := test_app.ExportQueryResult("SELECT 1 AS one, 'x' AS two;", "ndjson", path)
+	if res.Err != nil {
+		t.Fatalf("ExportQueryResult() error = %v", res.Err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil || string(body) != "{\"one\":1,\"two\":\"x\"}\n" {
+		t.Errorf("ExportQueryResult() wrote %q, %v", body, err)
+	}
+	if res := test_app.ExportQueryResult("DELETE FROM main.dbs;", "csv", path); res.Err == nil {
+		t.Error("ExportQueryResult() accepted a DELETE statement")
+	}
+	if res := test_app.ExportTable("main", "dbs", "xml", path); res.Err == nil {
+		t.Error("ExportTable() accepted an unknown format")
+	}
+	if res := test_app.ExportQueryResult("SELECT 1; SELECT 2;", "csv", path); res.Err == nil {
+		t.Error("ExportQueryResult() accepted several statements")
+	}
+
+	// the overflow fails the query after the first row is written
+	failed := filepath.Join(t.TempDir(), "failed.csv")
+	res = test_app.ExportQueryResult("SELECT 1 AS n UNION ALL SELECT abs(-9223372036854775807 - 1)", "csv", failed)
+	if res.Err == nil {
+		t.Fatal("ExportQueryResult() succeeded on a failing query")
+	}
+	if _, err := os.Stat(failed); !os.IsNotExist(err) {
+		t.Errorf("a failed export left %s behind", failed)
+	}
+}
+
+func TestExportToZipFailure(t *testing.T) {
+	root := t.TempDir()
+	db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, "infinite.db"))
+	// JSON has no infinity
+	db.MustExec("CREATE TABLE broken (x REAL); INSERT INTO broken (x) VALUES (1e999);")
+	db.Close()
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tables.zip")
+	if err := test_app.exportToZip(path, FORMAT_JSON, CSVOptions{}); err == nil {
+		t.Fatal("exportToZip() succeeded on a failing table")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("a failed export left %s behind", path)
+	}
+}
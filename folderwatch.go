@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// watches root and every folder below it, so database files dropped into
+// the folder are stored and those deleted or moved away are pruned. The nav
+// is told to refresh after each burst of changes.
+func (a *App) watchRoot(root string) error {
+	a.stopWatching()
+	w, err := newFolderWatcher()
+	if err != nil {
+		return err
+	}
+	a.watchFolders(w, root)
+	a.watcher = w
+	a.watchDone = make(chan struct{})
+	go a.runWatcher(w, root, a.watchDone)
+	return nil
+}
+
+// stops watching the root and waits for the events in flight to be handled
+func (a *App) stopWatching() {
+	if a.watcher == nil {
+		return
+	}
+	a.watcher.Close()
+	<-a.watchDone
+	a.watcher = nil
+	a.watchDone = nil
+}
+
+// adds dir and the folders below it to w
+func (a *App) watchFolders(w folderWatcher, dir string) {
+	err := a.scanFolder(dir, func(path string, isDir bool) {
+		if isDir {
+			if err := w.Add(path); err != nil {
+				a.logger.Error(err.Error())
+			}
+		}
+	})
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("Error accessing path %q: %v", dir, err))
+	}
+}
+
+func (a *App) runWatcher(w folderWatcher, root string, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case event, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			changed := a.handleFolderEvent(w, event)
+			// handle whatever else is queued before refreshing the nav once
+			for drained := false; !drained; {
+				select {
+				case event, ok := <-w.Events():
+					if !ok {
+						drained = true
+						break
+					}
+					changed = a.handleFolderEvent(w, event) || changed
+				default:
+					drained = true
+				}
+			}
+			if changed {
+				a.emit(NAV_REFRESH, root)
+			}
+		case err := <-w.Errors():
+			a.logger.Error(err.Error(), slog.String("root", root))
+			if errors.Is(err, errWatchOverflow) {
+				// events were lost, so look at the whole folder again
+				a.watchFolders(w, root)
+				if err := a.attachDBsFromFolder(root); err != nil {
+					a.logger.Error(err.Error())
+				}
+				a.emit(NAV_REFRESH, root)
+			}
+		}
+	}
+}
+
+// stores or prunes the databases event concerns; reports whether any were
+func (a *App) handleFolderEvent(w folderWatcher, event folderEvent) bool {
+	if event.Op == WATCH_REMOVE {
+		return a.pruneDBs(event.Path)
+	}
+	isDir, ok := a.scanEntry(a.scanRoot(event.Path), event.Path)
+	switch {
+	case !ok:
+		return false
+	case isDir:
+		// files may have landed in the folder before it was watched
+		a.watchFolders(w, event.Path)
+		before := len(a.registry.under(event.Path))
+		if err := a.attachDBsFromFolder(event.Path); err != nil {
+			a.logger.Error(err.Error())
+		}
+		return len(a.registry.under(event.Path)) > before
+	default:
+		added, err := a.attachDBFile(event.Path)
+		if err != nil {
+			a.logger.Error(fmt.Sprintf("Failed to attach and persist DB %s: %v", event.Path, err))
+		}
+		return added
+	}
+}
+
+// detaches every database whose file is path or lies inside it and removes
+// it from main.dbs, remembering which were read-only. Reports whether there
+// were any.
+func (a *App) pruneDBs(path string) bool {
+	dbs := a.registry.under(path)
+	for _, db := range dbs {
+		a.logger.Debug(fmt.Sprintf("Pruning DB: %s", db.Path))
+		a.registry.unregister(db.Name)
+		a.registry.prune(db.Path, db.ReadOnly)
+		a.policy.set(db.Name, false)
+		if _, err := a.execTrusted("DELETE FROM main.dbs WHERE name = ? AND root = ?;", db.Name, a.rootPath); err != nil {
+			a.logger.Error(err.Error())
+		}
+	}
+	if len(dbs) > 0 {
+		a.releaseDetached()
+	}
+	return len(dbs) > 0
+}
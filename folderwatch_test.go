@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newWatchTestDB(t *testing.T, path string) {
+	t.Helper()
+	db := sqlx.MustOpen(SQLITE_DRIVER, path)
+	db.MustExec("CREATE TABLE rows (id INTEGER PRIMARY KEY);")
+	db.Close()
+}
+
+// waits for the next event of w, failing the test after a few seconds
+func nextFolderEvent(t *testing.T, w folderWatcher) folderEvent {
+	t.Helper()
+	select {
+	case event := <-w.Events():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no folder event")
+	}
+	return folderEvent{}
+}
+
+func TestPollWatcher(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "old.db"), nil, SafePermissions)
+	w := newPollWatcher(10 * time.Millisecond)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	newWatchTestDB(t, filepath.Join(dir, "new.db"))
+	if event := nextFolderEvent(t, w); event != (folderEvent{Path: filepath.Join(dir, "new.db"), Op: WATCH_CREATE}) {
+		t.Errorf("got %+v, want new.db created", event)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), SafePermissions)
+	if event := nextFolderEvent(t, w); event != (folderEvent{Path: filepath.Join(dir, "sub"), Op: WATCH_CREATE, IsDir: true}) {
+		t.Errorf("got %+v, want sub created", event)
+	}
+	os.Remove(filepath.Join(dir, "old.db"))
+	if event := nextFolderEvent(t, w); event != (folderEvent{Path: filepath.Join(dir, "old.db"), Op: WATCH_REMOVE}) {
+		t.Errorf("got %+v, want old.db removed", event)
+	}
+	newWatchTestDB(t, filepath.Join(dir, "next.tmp"))
+	os.Rename(filepath.Join(dir, "next.tmp"), filepath.Join(dir, "new.db"))
+	if event := nextFolderEvent(t, w); event != (folderEvent{Path: filepath.Join(dir, "new.db"), Op: WATCH_CREATE}) {
+		t.Errorf("got %+v, want new.db replaced", event)
+	}
+	w.Close()
+	for range w.Events() {
+	}
+}
+
+func TestWatchRoot(t *testing.T) {
+	root := t.TempDir()
+	newWatchTestDB(t, filepath.Join(root, "first.db"))
+	refreshed := make(chan struct{}, 16)
+	prevRoot, prevOnEvent := test_app.rootPath, test_app.onEvent
+	test_app.rootPath = root
+	test_app.onEvent = func(emitType WailsEmitType, data any) {
+		if emitType == NAV_REFRESH {
+			refreshed <- struct{}{}
+		}
+	}
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath, test_app.onEvent = prevRoot, prevOnEvent
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_app.watchRoot(root); err != nil {
+		t.Fatalf("watchRoot() error = %v", err)
+	}
+	// waits for a nav refresh, then checks the stored databases
+	expect := func(what string, names ...string) {
+		t.Helper()
+		select {
+		case <-refreshed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no nav refresh", what)
+		}
+		var stored []string
+		test_app.db.Select(&stored, "SELECT name FROM main.dbs WHERE root = ? ORDER BY name;", root)
+		if !reflect.DeepEqual(stored, names) {
+			t.Errorf("%s: stored %v, want %v", what, stored, names)
+		}
+		for _, name := range names {
+			if _, ok := test_app.registry.lookup(name); !ok {
+				t.Errorf("%s: %s is not registered", what, name)
+			}
+		}
+	}
+
+	newWatchTestDB(t, filepath.Join(root, "second.db"))
+	expect("new file", "first", "second")
+
+	os.Mkdir(filepath.Join(root, "etl"), SafePermissions)
+	newWatchTestDB(t, filepath.Join(root, "etl", "third.sqlite"))
+	expect("file in a new folder", "first", "second", "third")
+
+	os.Rename(filepath.Join(root, "second.db"), filepath.Join(root, "renamed.db"))
+	expect("rename", "first", "renamed", "third")
+
+	os.Remove(filepath.Join(root, "first.db"))
+	expect("delete", "renamed", "third")
+	var count int
+	if err := test_app.db.Get(&count, "SELECT COUNT(*) FROM first.rows;"); err == nil {
+		t.Error("a deleted database can still be queried")
+	}
+}
+
+func TestMissingDB(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "gone.db")
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	test_app.execTrusted("INSERT INTO main.dbs (name, path, root) VALUES ('gone', ?, ?);", path, root)
+	if err := test_app.attachMainDBs(); err != nil {
+		t.Fatalf("attachMainDBs() error = %v", err)
+	}
+	nav := test_app.GetNavData()
+	var dbs map[string]struct {
+		Missing bool `json:"missing"`
+	}
+	data, _ := json.Marshal(nav.Results)
+	if err := json.Unmarshal(data, &dbs); err != nil || nav.Err != nil || !dbs["gone"].Missing {
+		t.Fatalf("GetNavData() = %+v, want gone marked missing", nav)
+	}
+	if _, err := test_app.db.Exec("SELECT * FROM gone.sqlite_master;"); err == nil {
+		t.Error("a missing database was attached")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("attaching a missing database created its file")
+	}
+
+	// workspace exports leave the missing database out
+	newWatchTestDB(t, filepath.Join(root, "present.db"))
+	if added, err := test_app.attachDBFile(filepath.Join(root, "present.db")); !added || err != nil {
+		t.Fatalf("attachDBFile() = %v, %v", added, err)
+	}
+	if names, err := test_app.getSQLiteDBNames(); err != nil || slices.Contains(names, "gone") || !slices.Contains(names, "present") {
+		t.Errorf("getSQLiteDBNames() = %v, %v", names, err)
+	}
+	out := t.TempDir()
+	if err := test_app.exportDumpZip(filepath.Join(out, "dump.zip"), DumpOptions{}); err != nil {
+		t.Errorf("exportDumpZip() error = %v", err)
+	}
+	if err := test_app.exportToZip(filepath.Join(out, "csv.zip"), FORMAT_CSV, CSVOptions{}); err != nil {
+		t.Errorf("exportToZip() error = %v", err)
+	}
+	if _, err := test_app.tableSheets(""); err != nil {
+		t.Errorf("tableSheets() error = %v", err)
+	}
+	if manifest, err := test_app.exportBundle(filepath.Join(out, "bundle.zip")); err != nil || slices.ContainsFunc(manifest.Databases, func(e BundleEntry) bool { return e.Alias == "gone" }) {
+		t.Errorf("exportBundle() = %+v, %v", manifest, err)
+	}
+	if _, err := test_app.exportNewDB(filepath.Join(out, "merged.db"), EXPORT_DB_MERGE); err != nil {
+		t.Errorf("exportNewDB() error = %v", err)
+	}
+
+	// the file coming back brings the database back
+	newWatchTestDB(t, path)
+	if added, err := test_app.attachDBFile(path); !added || err != nil {
+		t.Fatalf("attachDBFile() = %v, %v", added, err)
+	}
+	if _, ok := test_app.registry.lookup("gone"); !ok || test_app.registry.isMissing("gone") {
+		t.Error("gone is still missing")
+	}
+}
+
+func TestReplacedDB(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "snap.db")
+	newWatchTestDB(t, path)
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if added, err := test_app.attachDBFile(path); !added || err != nil {
+		t.Fatalf("attachDBFile() = %v, %v", added, err)
+	}
+	if res := test_app.SetDBReadOnly(ReadOnlyRequest{DB: "snap", ReadOnly: true}); res.Err != nil {
+		t.Fatalf("SetDBReadOnly() error = %v", res.Err)
+	}
+
+	// a snapshot refreshed by delete and copy stays read-only
+	os.Remove(path)
+	if !test_app.pruneDBs(path) {
+		t.Fatal("pruneDBs() pruned nothing")
+	}
+	newWatchTestDB(t, path)
+	if added, err := test_app.attachDBFile(path); !added || err != nil {
+		t.Fatalf("attachDBFile() = %v, %v", added, err)
+	}
+	var readOnly bool
+	test_app.db.Get(&readOnly, "SELECT read_only FROM main.dbs WHERE name = 'snap' AND root = ?;", root)
+	if db, _ := test_app.registry.lookup("snap"); !db.ReadOnly || !readOnly || !test_app.policy.flagged("snap") {
+		t.Errorf("snap came back as %+v, stored read_only %v", db, readOnly)
+	}
+	if _, err := test_app.db.Exec("DELETE FROM snap.rows;"); err == nil {
+		t.Error("wrote to a read-only snapshot after it was replaced")
+	}
+
+	// a file renamed over it replaces what pools and connections read
+	pool, err := test_app.registry.pool("snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	test_app.db.Get(&count, "SELECT COUNT(*) FROM snap.rows;")
+	next := filepath.Join(root, "next.tmp")
+	db := sqlx.MustOpen(SQLITE_DRIVER, next)
+	db.MustExec("CREATE TABLE fresh (id INTEGER PRIMARY KEY);")
+	db.Close()
+	os.Rename(next, path)
+	if added, err := test_app.attachDBFile(path); !added || err != nil {
+		t.Fatalf("attachDBFile() after a rename = %v, %v", added, err)
+	}
+	if p, _ := test_app.registry.pool("snap"); p == pool {
+		t.Error("the pool on the old file was kept")
+	}
+	if err := test_app.db.Get(&count, "SELECT COUNT(*) FROM snap.fresh;"); err != nil {
+		t.Errorf("querying the new file: %v", err)
+	}
+	if added, _ := test_app.attachDBFile(path); added {
+		t.Error("an unchanged file was reported again")
+	}
+}
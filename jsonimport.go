@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// nested objects and arrays are stored as JSON text
+	JSON_NESTED_TEXT = "text"
+	// nested objects become parent_child columns
+	JSON_NESTED_FLATTEN = "flatten"
+	// nested objects are flattened and arrays of objects become child tables
+	JSON_NESTED_TABLES = "tables"
+
+	jsonIDColumn = "_id"
+)
+
+type JSONImportOptions struct {
+	Nested string `json:"nested"`
+	// JSONPath of the records, e.g. $.data.items[*]
+	RecordPath string `json:"recordPath"`
+	// one JSON document per line
+	Lines bool `json:"lines"`
+}
+
+// a JSON object that keeps its keys in the order of the document, so the
+// columns of imported tables follow it
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, k := range o.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, key...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// decodes the next value of dec with objects as *jsonObject. dec should use
+// numbers.
+func decodeJSONValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSONToken(dec, tok)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func decodeJSONToken(dec *json.Decoder, tok json.Token) (any, error) {
+	switch tok {
+	case json.Delim('{'):
+		obj := &jsonObject{values: make(map[string]any)}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = value
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+type jsonPathStep struct {
+	key       string
+	index     int
+	isIndex   bool
+	wildcard  bool
+	recursive bool
+}
+
+// parses the subset of JSONPath used to select records: $, .key, ['key'],
+// [n], [*], .* and ..key
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", path)
+	}
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		var step jsonPathStep
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "" {
+				return nil, fmt.Errorf("json path %q has an empty key", path)
+			}
+			if name == "*" {
+				step.wildcard = true
+			} else {
+				step.key = name
+			}
+			steps = append(steps, step)
+			continue
+		}
+		if !strings.HasPrefix(rest, "[") {
+			return nil, fmt.Errorf("json path %q is invalid at %q", path, rest)
+		}
+		end := strings.Index(rest, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("json path %q has an unclosed [", path)
+		}
+		inner := strings.TrimSpace(rest[1:end])
+		rest = rest[end+1:]
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.key = inner[1 : len(inner)-1]
+		default:
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("json path %q has an invalid index %q", path, inner)
+			}
+			step.index, step.isIndex = i, true
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// returns node and everything nested in it, depth first
+func jsonDescendants(node any, out []any) []any {
+	out = append(out, node)
+	switch v := node.(type) {
+	case *jsonObject:
+		for _, k := range v.keys {
+			out = jsonDescendants(v.values[k], out)
+		}
+	case []any:
+		for _, el := range v {
+			out = jsonDescendants(el, out)
+		}
+	}
+	return out
+}
+
+func (s jsonPathStep) apply(node any, out []any) []any {
+	switch v := node.(type) {
+	case *jsonObject:
+		if s.wildcard {
+			for _, k := range v.keys {
+				out = append(out, v.values[k])
+			}
+		} else if child, ok := v.values[s.key]; ok && !s.isIndex {
+			out = append(out, child)
+		}
+	case []any:
+		if s.wildcard {
+			out = append(out, v...)
+		} else if s.isIndex {
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				out = append(out, v[i])
+			}
+		}
+	}
+	return out
+}
+
+// evaluates path against doc; a single array result stands for its elements
+func selectJSONPath(doc any, path string) ([]any, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, node := range nodes {
+			candidates := []any{node}
+			if step.recursive {
+				candidates = jsonDescendants(node, nil)
+			}
+			for _, c := range candidates {
+				next = step.apply(c, next)
+			}
+		}
+		nodes = next
+	}
+	if len(nodes) == 1 {
+		if arr, ok := nodes[0].([]any); ok {
+			return arr, nil
+		}
+	}
+	return nodes, nil
+}
+
+// the rows of one table built from JSON records, with columns in order of
+// first appearance
+type jsonTable struct {
+	name   string
+	parent string
+	// rows carry a generated id, and a link to the parent row when nested
+	linked  bool
+	columns []string
+	seen    map[string]bool
+	rows    []Series
+}
+
+func (t *jsonTable) addColumn(name string) {
+	if !t.seen[name] {
+		t.seen[name] = true
+		t.columns = append(t.columns, name)
+	}
+}
+
+type jsonTableBuilder struct {
+	nested string
+	tables map[string]*jsonTable
+	order  []*jsonTable
+}
+
+func newJSONTableBuilder(nested string) (*jsonTableBuilder, error) {
+	if nested == "" {
+		nested = JSON_NESTED_TEXT
+	}
+	if !slices.Contains([]string{JSON_NESTED_TEXT, JSON_NESTED_FLATTEN, JSON_NESTED_TABLES}, nested) {
+		return nil, fmt.Errorf("unknown nested json mode %q", nested)
+	}
+	return &jsonTableBuilder{nested: nested, tables: make(map[string]*jsonTable)}, nil
+}
+
+func (b *jsonTableBuilder) table(name string, parent string) *jsonTable {
+	if t, ok := b.tables[name]; ok {
+		return t
+	}
+	t := &jsonTable{name: name, parent: parent, linked: b.nested == JSON_NESTED_TABLES, seen: make(map[string]bool)}
+	if t.linked {
+		t.addColumn(jsonIDColumn)
+		if parent != "" {
+			t.addColumn(parent + jsonIDColumn)
+		}
+	}
+	b.tables[name] = t
+	b.order = append(b.order, t)
+	return t
+}
+
+// adds record as a row of the named table; parentID links child table rows
+// to the row they were nested in
+func (b *jsonTableBuilder) addRecord(name string, parent string, parentID int64, record any) error {
+	obj, ok := record.(*jsonObject)
+	if !ok {
+		return fmt.Errorf("malformed json structure: expected an object in %s, got %T", name, record)
+	}
+	t := b.table(name, parent)
+	row := make(Series)
+	id := int64(len(t.rows) + 1)
+	if t.linked {
+		row[jsonIDColumn] = id
+		if parent != "" {
+			row[parent+jsonIDColumn] = parentID
+		}
+	}
+	// children are added after the parent row so ids stay in record order
+	t.rows = append(t.rows, row)
+	return b.flatten(t, row, "", obj, id)
+}
+
+func isObjectArray(v []any) bool {
+	if len(v) == 0 {
+		return false
+	}
+	for _, el := range v {
+		if _, ok := el.(*jsonObject); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *jsonTableBuilder) flatten(t *jsonTable, row Series, prefix string, obj *jsonObject, id int64) error {
+	for _, k := range obj.keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "_" + k
+		}
+		switch v := obj.values[k].(type) {
+		case *jsonObject:
+			if b.nested != JSON_NESTED_TEXT {
+				if err := b.flatten(t, row, name, v, id); err != nil {
+					return err
+				}
+				continue
+			}
+		case []any:
+			if b.nested == JSON_NESTED_TABLES && isObjectArray(v) {
+				for _, el := range v {
+					if err := b.addRecord(t.name+"_"+name, t.name, id, el); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		t.addColumn(name)
+		row[name] = obj.values[k]
+	}
+	return nil
+}
+
+// decodes the JSON document (or lines) in r and splits the selected records
+// into tables. Without a record path a top-level object maps each key to a
+// table, as the plain JSON upload does.
+func readJSONTables(r io.Reader, rootName string, opts JSONImportOptions) ([]*jsonTable, error) {
+	b, err := newJSONTableBuilder(opts.Nested)
+	if err != nil {
+		return nil, err
+	}
+	addDocument := func(doc any) error {
+		if opts.RecordPath != "" {
+			records, err := selectJSONPath(doc, opts.RecordPath)
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				if err := b.addRecord(rootName, "", 0, record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		switch v := doc.(type) {
+		case []any:
+			for _, record := range v {
+				if err := b.addRecord(rootName, "", 0, record); err != nil {
+					return err
+				}
+			}
+		case *jsonObject:
+			if opts.Lines {
+				return b.addRecord(rootName, "", 0, v)
+			}
+			for _, name := range v.keys {
+				records, ok := v.values[name].([]any)
+				if !ok {
+					return errors.New("malformed json structure: expected array of objects")
+				}
+				for _, record := range records {
+					if err := b.addRecord(name, "", 0, record); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			return errors.New("malformed json")
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+	for line := 1; ; line++ {
+		doc, err := decodeJSONValue(dec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if opts.Lines {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			return nil, err
+		}
+		if err := addDocument(doc); err != nil {
+			if opts.Lines {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			return nil, err
+		}
+		if !opts.Lines {
+			break
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, errors.New("json contains no records")
+	}
+	return b.order, nil
+}
+
+// maps JSON field names to unique SQL column names, keeping the names in
+// reserved as they are
+func jsonColumnNames(columns []string, reserved map[string]string) map[string]string {
+	names := make(map[string]string, len(columns))
+	used := make(map[string]bool)
+	for col, name := range reserved {
+		names[col] = name
+		used[name] = true
+	}
+	for _, col := range columns {
+		if _, ok := reserved[col]; ok {
+			continue
+		}
+		name := strings.Trim(sqlSanitize(col), `"`)
+		if name == "" {
+			name = "column"
+		}
+		unique := name
+		for n := 2; used[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		used[unique] = true
+		names[col] = unique
+	}
+	return names
+}
+
+// creates the tables in db, child tables after their parents with a foreign
+// key on the generated id. Tables that exist already are refused rather than
+// replaced; ImportIntoTable appends to them.
+func writeJSONTables(db *sqlx.DB, tables []*jsonTable) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tableNames := make(map[string]string, len(tables))
+	for _, t := range tables {
+		tableNames[t.name] = strings.Trim(sqlSanitize(t.name), `"`)
+	}
+	for _, t := range tables {
+		reserved := make(map[string]string)
+		if t.linked {
+			reserved[jsonIDColumn] = jsonIDColumn
+			if t.parent != "" {
+				reserved[t.parent+jsonIDColumn] = tableNames[t.parent] + jsonIDColumn
+			}
+		}
+		names := jsonColumnNames(t.columns, reserved)
+		defs := make([]string, len(t.columns))
+		cols := make([]string, len(t.columns))
+		for i, col := range t.columns {
+			cols[i] = quoteIdent(names[col])
+			switch {
+			case t.linked && col == jsonIDColumn:
+				defs[i] = cols[i] + " INTEGER PRIMARY KEY"
+			case t.linked && t.parent != "" && col == t.parent+jsonIDColumn:
+				defs[i] = fmt.Sprintf("%s INTEGER REFERENCES %s(%s)", cols[i], quoteIdent(tableNames[t.parent]), quoteIdent(jsonIDColumn))
+			default:
+				var sample any
+				for _, row := range t.rows {
+					if sample = importValue(row[col]); sample != nil {
+						break
+					}
+				}
+				defs[i] = cols[i] + " " + castToSQLiteType(reflect.TypeOf(sample))
+			}
+		}
+		table := quoteIdent(tableNames[t.name])
+		var exists bool
+		if err := tx.Get(&exists, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type IN ('table', 'view') AND name = ? COLLATE NOCASE;", tableNames[t.name]); err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("table %s already exists, import into it with ImportIntoTable instead", table)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s);", table, strings.Join(defs, ", "))); err != nil {
+			return fmt.Errorf("error creating table %s: %w", table, err)
+		}
+		stmt, err := tx.Preparex(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table, strings.Join(cols, ", "), generateINSERTPlaceholders(len(cols))))
+		if err != nil {
+			return err
+		}
+		values := make([]any, len(t.columns))
+		for _, row := range t.rows {
+			for i, col := range t.columns {
+				values[i] = importValue(row[col])
+			}
+			if _, err := stmt.Exec(values...); err != nil {
+				stmt.Close()
+				return fmt.Errorf("error inserting row into %s: %w", table, err)
+			}
+		}
+		stmt.Close()
+	}
+	return tx.Commit()
+}
+
+// imports a JSON or NDJSON file into a new database, or into an attached one
+// when req.DB is set
+func (a *App) ImportJSON(req JSONImportRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	name, ext := parseFile(req.Path)
+	if req.Table == "" {
+		req.Table = name
+	}
+	if normalizeExportFormat(ext) == FORMAT_NDJSON {
+		req.Options.Lines = true
+	}
+	file, err := os.Open(req.Path)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	tables, err := readJSONTables(file, req.Table, req.Options)
+	file.Close()
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+
+	var db *sqlx.DB
+	var alias, dbPath string
+	if req.DB == "" {
+		db, alias, dbPath, err = a.newImportDB(req.Path)
+	} else {
+		alias = req.DB
+		db, err = a.openWritableDB(req.DB)
+	}
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	err = writeJSONTables(db, tables)
+	db.Close()
+	if err == nil && req.DB == "" {
+		err = a.storeDB(alias, dbPath, true)
+	}
+	if err != nil {
+		if req.DB == "" {
+			os.Remove(dbPath)
+		}
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = strings.Trim(sqlSanitize(t.name), `"`)
+	}
+	return a.newResult(nil, map[string]any{"db": alias, "tables": names}, nil)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSelectJSONPath(t *testing.T) {
+	doc, err := decodeJSONValue(json.NewDecoder(strings.NewReader(
+		`{"data": {"items": [{"id": 1}, {"id": 2}], "next": "x"}, "meta": {"id": 3}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		want    int
+		wantErr bool
+	}{
+		{"$.data.items", 2, false},
+		{"$.data.items[*]", 2, false},
+		{"$['data']['items'][-1]", 1, false},
+		{"$..id", 3, false},
+		{"$.missing", 0, false},
+		{"data.items", 0, true},
+		{"$.data[items", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := selectJSONPath(doc, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectJSONPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("selectJSONPath() = %v, want %d nodes", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONImport(t *testing.T) {
+	const payload = `{"response": {"orders": [
+		{"id": 7, "customer": {"name": "ann", "address": {"city": "Oslo"}}, "items": [{"sku": "a", "qty": 2}, {"sku": "b", "qty": 1}], "tags": ["x"]},
+		{"id": 8, "customer": {"name": "bob"}, "items": []}
+	]}}`
+
+	tests := []struct {
+		name    string
+		input   string
+		opts    JSONImportOptions
+		queries map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "Flatten nested objects",
+			input: payload,
+			opts:  JSONImportOptions{Nested: JSON_NESTED_FLATTEN, RecordPath: "$.response.orders"},
+			queries: map[string]string{
+				"SELECT group_concat(name, ',') FROM pragma_table_info('orders');":        "id,customer_name,customer_address_city,items,tags",
+				"SELECT customer_name || customer_address_city FROM orders WHERE id = 7;": "annOslo",
+				"SELECT customer_address_city IS NULL FROM orders WHERE id = 8;":          "1",
+			},
+		},
+		{
+			name:  "Child tables",
+			input: payload,
+			opts:  JSONImportOptions{Nested: JSON_NESTED_TABLES, RecordPath: "$.response.orders[*]"},
+			queries: map[string]string{
+				"SELECT group_concat(name, ',') FROM pragma_table_info('orders_items');":                            "_id,orders_id,sku,qty",
+				"SELECT group_concat(o.id || i.sku, ',') FROM orders o JOIN orders_items i ON i.orders_id = o._id;": "7a,7b",
+				"SELECT \"table\" FROM pragma_foreign_key_list('orders_items');":                                    "orders",
+				"SELECT items FROM orders WHERE id = 8;":                                                            "[]",
+			},
+		},
+		{
+			name:  "NDJSON",
+			input: "{\"a\": 1}\n\n{\"b\": {\"c\": true}}\n",
+			opts:  JSONImportOptions{Nested: JSON_NESTED_FLATTEN, Lines: true},
+			queries: map[string]string{
+				"SELECT group_concat(ifnull(a, '-') || ifnull(b_c, '-'), ',') FROM orders;": "1-,-1",
+			},
+		},
+		{name: "NDJSON with a bad line", input: "{\"a\": 1}\n{oops}\n", opts: JSONImportOptions{Lines: true}, wantErr: true},
+		{name: "Records are not objects", input: `{"r": [1, 2]}`, opts: JSONImportOptions{RecordPath: "$.r"}, wantErr: true},
+		{name: "Unknown mode", input: `[{}]`, opts: JSONImportOptions{Nested: "explode"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables, err := readJSONTables(strings.NewReader(tt.input), "orders", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readJSONTables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			if err := writeJSONTables(db, tables); err != nil {
+				t.Fatalf("writeJSONTables() error = %v", err)
+			}
+			for query, want := range tt.queries {
+				var got string
+				if err := db.Get(&got, query); err != nil || got != want {
+					t.Errorf("%s = %q, %v, want %q", query, got, err, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Existing table", func(t *testing.T) {
+		db := sqlx.MustOpen(SQLITE_DRIVER, ":memory:")
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+		db.MustExec("CREATE TABLE orders (note TEXT); INSERT INTO orders VALUES ('keep');")
+		tables, err := readJSONTables(strings.NewReader(`[{"a": 1}]`), "orders", JSONImportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeJSONTables(db, tables); err == nil || !strings.Contains(err.Error(), "ImportIntoTable") {
+			t.Errorf("writeJSONTables() over an existing table: %v", err)
+		}
+		var note string
+		if err := db.Get(&note, "SELECT note FROM orders;"); err != nil || note != "keep" {
+			t.Errorf("the existing table was changed: %q, %v", note, err)
+		}
+	})
+}
+
+func TestUploadJSON(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "upload_json.json")
+	payload := `{"people": [{"zeta": 1, "alpha": {"b": 2, "a": 1}, "Mid": "x"}, {"alpha": null, "extra": true}], "pets": [{"name": "rex"}]}`
+	os.WriteFile(jsonPath, []byte(payload), SafePermissions)
+	var failed []any
+	prevEvent := test_app.onEvent
+	test_app.onEvent = func(emitType WailsEmitType, data any) {
+		if emitType == DB_UPLOAD_FAIL {
+			failed = append(failed, data)
+		}
+	}
+	defer func() { test_app.onEvent = prevEvent }()
+
+	test_app.dialog.(*MockDialogService).QueueFileResult(jsonPath, nil)
+	test_app.uploadDB()
+	if len(failed) != 0 {
+		t.Fatalf("uploadDB() failures %v", failed)
+	}
+	defer test_app.RemoveDB("upload_json")
+
+	db, err := sqlx.Open(SQLITE_DRIVER, test_app.getNewDBPath("upload_json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	queries := map[string]string{
+		"SELECT group_concat(name, ',') FROM pragma_table_info('people');": "zeta,alpha,mid,extra",
+		"SELECT alpha FROM people WHERE zeta = 1;":                         `{"b":2,"a":1}`,
+		"SELECT name FROM pets;":                                           "rex",
+	}
+	for query, want := range queries {
+		var got string
+		if err := db.Get(&got, query); err != nil || got != want {
+			t.Errorf("%s = %q, %v, want %q", query, got, err, want)
+		}
+	}
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -42,34 +40,60 @@ func (a *App) OpenFolderOnStart() AppResult {
 		a.logger.Error(err.Error())
 		return a.newResult(err, nil, nil)
 	}
+	if err = a.watchRoot(selection); err != nil {
+		a.logger.Error(err.Error())
+	}
 	return a.newResult(nil, map[string]any{"root": selection}, nil)
 }
 func (a *App) attachDBsFromFolder(targetPath string) error {
-	err := filepath.WalkDir(targetPath, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			a.logger.Error(fmt.Sprintf("Error accessing path %q: %v", path, err))
-			return err // Stop the walk on critical error
+	err := a.scanFolder(targetPath, func(path string, isDir bool) {
+		if isDir {
+			return
 		}
-
-		if d.IsDir() {
-			return nil
+		if _, err := a.attachDBFile(path); err != nil {
+			a.logger.Error(fmt.Sprintf("Failed to attach and persist DB %s: %v", path, err))
 		}
+	})
+	if err != nil {
+		a.logger.Error(fmt.Sprintf("Error accessing path %q: %v", targetPath, err))
+	}
+	return err
+}
 
-		baseName, ext := parseFile(path)
-		if slices.Contains(dbFileTypes[:], ext) {
-			safeAlias := strings.ReplaceAll(baseName, string(filepath.Separator), "_")
-			safeAlias = strings.ReplaceAll(safeAlias, ".", "_")
+// stores the database file at path under an alias derived from its name.
+// Reports whether the file was new to the app; files of other types are
+// left alone.
+func (a *App) attachDBFile(path string) (bool, error) {
+	if !a.scan.current().isDBFile(path) {
+		return false, nil
+	}
+	baseName, _ := parseFile(path)
+	if db, ok := a.registry.byPath(path); ok {
+		if !db.replaced() {
+			return false, nil
+		}
+		// renamed over the registered file: connections still hold the old one
+		a.logger.Debug(fmt.Sprintf("Replaced DB: %s", path))
+		a.registry.register(db.Name, db.Path, db.ReadOnly)
+		a.releaseDetached()
+		return true, nil
+	}
+	if db, ok := a.registry.missingAt(path); ok {
+		a.logger.Debug(fmt.Sprintf("Missing DB is back: %s", path))
+		a.registry.register(db.Name, db.Path, db.ReadOnly)
+		return true, nil
+	}
+	safeAlias := strings.ReplaceAll(baseName, string(filepath.Separator), "_")
+	safeAlias = strings.ReplaceAll(safeAlias, ".", "_")
 
-			if safeAlias == "main" {
-				safeAlias = "main_db"
-			}
-			a.logger.Debug(fmt.Sprintf("Attaching DB: %s as Alias: %s", path, safeAlias))
+	if safeAlias == "main" {
+		safeAlias = "main_db"
+	}
+	a.logger.Debug(fmt.Sprintf("Attaching DB: %s as Alias: %s", path, safeAlias))
 
-			if err := a.storeDB(safeAlias, path, false); err != nil {
-				a.logger.Error(fmt.Sprintf("Failed to attach and persist DB %s: %v", path, err))
-			}
-		}
-		return nil
-	})
-	return err
+	if err := a.storeDB(safeAlias, path, false); err != nil {
+		return false, err
+	}
+	_, ok := a.registry.byPath(path)
+	return ok, nil
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"embed"
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -58,6 +60,9 @@ func (a *App) newAppMenu() *menu.Menu {
 	FileMenu.AddText("Import", keys.CmdOrCtrl("o"), func(_ *menu.CallbackData) {
 		a.importDB()
 	})
+	FileMenu.AddText("Import bundle", keys.Combo("o", keys.CmdOrCtrlKey, keys.ShiftKey), func(_ *menu.CallbackData) {
+		a.importBundleDB()
+	})
 	FileMenu.AddText("Open Folder...", keys.CmdOrCtrl("K"), func(_ *menu.CallbackData) {
 		a.openFolder()
 	})
@@ -79,6 +84,14 @@ func (a *App) newAppMenu() *menu.Menu {
 			a.exportDB(".json")
 		}),
 
+		menu.Text("Export to Parquet (ZIP)", keys.Combo("p", keys.CmdOrCtrlKey, keys.ShiftKey), func(_ *menu.CallbackData) {
+			a.exportDB(".parquet")
+		}),
+
+		menu.Text("Export to Excel (XLSX)", keys.Combo("x", keys.CmdOrCtrlKey, keys.ShiftKey), func(_ *menu.CallbackData) {
+			a.exportDB(".xlsx")
+		}),
+
 		menu.Text("Export to SQL (ZIP)", keys.Combo("s", keys.CmdOrCtrlKey, keys.ShiftKey), func(_ *menu.CallbackData) {
 			a.exportDB(".sql")
 		}),
@@ -100,12 +113,27 @@ func (a *App) newAppMenu() *menu.Menu {
 }
 
 func main() {
+	if len(os.Args) > 1 && isCLICommand(os.Args[1]) {
+		os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	flags := flag.NewFlagSet(APP_NAME, flag.ExitOnError)
+	readOnly := flags.Bool("read-only", false, "refuse writes to every database")
+	policyPath := flags.String("policy", "", "JSON file of the statement rules, as GetPolicy returns them")
+	flags.Parse(os.Args[1:])
+	policy, err := loadPolicyConfig(*policyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Create an instance of the app structure
 	logger := NewSLogger()
 	app := NewApp(&CustomAppConfig{
 		Logger:        logger,
 		DialogService: &WailsDialogService{},
+		ReadOnly:      *readOnly,
+		Policy:        policy,
 	})
 	AppMenu := app.newAppMenu()
 	appInstance := options.App{
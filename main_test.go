@@ -67,6 +67,11 @@ func (m *MockDialogService) OpenFile(ctx context.Context, opts runtime.OpenDialo
 	return result.Result, result.Err
 }
 
+// SaveFile shares the OpenFile queue.
+func (m *MockDialogService) SaveFile(ctx context.Context, opts runtime.SaveDialogOptions) (string, error) {
+	return m.OpenFile(ctx, runtime.OpenDialogOptions{Title: opts.Title})
+}
+
 func TestMain(m *testing.M) {
 	uniqueDBIdentifier := "test_app"
 	test_app = NewApp(&CustomAppConfig{
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	mcpServerName = "sqlitegui"
+	// newest protocol revision spoken, answered when the client asks for one
+	// we do not know
+	mcpProtocolVersion = "2025-06-18"
+
+	defaultMCPRowLimit = 100
+	maxMCPRowLimit     = 1000
+	// longest line read from the client
+	maxMCPMessage = 16 << 20
+
+	// JSON-RPC error codes
+	mcpParseError     = -32700
+	mcpInvalidRequest = -32600
+	mcpMethodNotFound = -32601
+	mcpInvalidParams  = -32602
+)
+
+var mcpProtocolVersions = []string{"2024-11-05", "2025-03-26", mcpProtocolVersion}
+
+// a JSON-RPC message from the client; notifications carry no ID
+type mcpRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type mcpResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+	call        func(args json.RawMessage) (any, error)
+	write       bool
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// rows of a query cut off at a limit
+type MCPQueryResult struct {
+	Columns   []string `json:"columns"`
+	Rows      [][]any  `json:"rows"`
+	Truncated bool     `json:"truncated"`
+}
+
+// speaks the Model Context Protocol over a line delimited stream, exposing
+// the workspace to assistants. Statements that write are only offered when
+// allowWrites is set.
+type mcpServer struct {
+	app         *App
+	allowWrites bool
+	tools       []mcpTool
+}
+
+func objectSchema(required []string, properties map[string]any) map[string]any {
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+func newMCPServer(app *App, allowWrites bool) *mcpServer {
+	m := &mcpServer{app: app, allowWrites: allowWrites}
+	dbProp := map[string]any{"type": "string", "description": "database name as listed by list_databases"}
+	limitProp := map[string]any{"type": "integer", "minimum": 1, "maximum": maxMCPRowLimit, "description": fmt.Sprintf("most rows returned, %d by default", defaultMCPRowLimit)}
+	m.tools = []mcpTool{
+		{
+			Name:        "list_databases",
+			Description: "Lists the attached databases and their tables.",
+			InputSchema: objectSchema([]string{}, map[string]any{}),
+			call:        m.listDatabases,
+		},
+		{
+			Name:        "describe_schema",
+			Description: "Returns the CREATE statements and columns of the tables, indexes, views and triggers of a database, or of one table.",
+			InputSchema: objectSchema([]string{"db"}, map[string]any{
+				"db":    dbProp,
+				"table": map[string]any{"type": "string", "description": "only describe this table and its indexes and triggers"},
+			}),
+			call: m.describeSchema,
+		},
+		{
+			Name:        "query",
+			Description: "Runs one read-only SQLite statement (SELECT, WITH, VALUES or PRAGMA). Tables are referenced as db.table.",
+			InputSchema: objectSchema([]string{"sql"}, map[string]any{
+				"sql":   map[string]any{"type": "string"},
+				"limit": limitProp,
+			}),
+			call: m.query,
+		},
+		{
+			Name:        "sample_table",
+			Description: "Returns randomly chosen rows of a table.",
+			InputSchema: objectSchema([]string{"db", "table"}, map[string]any{
+				"db":    dbProp,
+				"table": map[string]any{"type": "string"},
+				"limit": limitProp,
+			}),
+			call: m.sampleTable,
+		},
+		{
+			Name:        "execute",
+			Description: "Runs SQL statements that change a database and returns the rows affected.",
+			InputSchema: objectSchema([]string{"sql"}, map[string]any{
+				"sql": map[string]any{"type": "string"},
+			}),
+			call:  m.execute,
+			write: true,
+		},
+	}
+	return m
+}
+
+func (m *mcpServer) availableTools() []mcpTool {
+	return slices.DeleteFunc(slices.Clone(m.tools), func(tool mcpTool) bool {
+		return tool.write && !m.allowWrites
+	})
+}
+
+// answers requests read from r on w, one JSON message per line, until r ends
+func (m *mcpServer) serve(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxMCPMessage)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req mcpRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			if err := enc.Encode(mcpResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{mcpParseError, err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rpcErr := m.handle(req)
+		if len(req.ID) == 0 {
+			// notifications are not answered
+			continue
+		}
+		if err := enc.Encode(mcpResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (m *mcpServer) handle(req mcpRequest) (any, *mcpError) {
+	if req.JSONRPC != "2.0" {
+		return nil, &mcpError{mcpInvalidRequest, "jsonrpc must be 2.0"}
+	}
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := mcpProtocolVersion
+		if slices.Contains(mcpProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": mcpServerName, "version": "1.0.0"},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": m.availableTools()}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &mcpError{mcpInvalidParams, err.Error()}
+		}
+		i := slices.IndexFunc(m.availableTools(), func(tool mcpTool) bool { return tool.Name == params.Name })
+		if i < 0 {
+			return nil, &mcpError{mcpInvalidParams, fmt.Sprintf("unknown tool %q", params.Name)}
+		}
+		if len(params.Arguments) == 0 {
+			params.Arguments = json.RawMessage("{}")
+		}
+		return m.callTool(m.availableTools()[i], params.Arguments), nil
+	}
+	if strings.HasPrefix(req.Method, "notifications/") {
+		return nil, nil
+	}
+	return nil, &mcpError{mcpMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// runs a tool; its failures are results the assistant can read and correct
+func (m *mcpServer) callTool(tool mcpTool, args json.RawMessage) mcpToolResult {
+	result, err := tool.call(args)
+	if err != nil {
+		m.app.logger.Error(err.Error())
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+	}
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+	}
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: string(body)}}}
+}
+
+func (m *mcpServer) listDatabases(args json.RawMessage) (any, error) {
+	res := m.app.GetNavData()
+	return res.Results, res.Err
+}
+
+func (m *mcpServer) describeSchema(args json.RawMessage) (any, error) {
+	var req struct {
+		DB    string `json:"db"`
+		Table string `json:"table"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil || req.DB == "" {
+		return nil, errors.New("db is required")
+	}
+	schema, err := loadSchema(m.app.db, req.DB)
+	if err != nil {
+		return nil, err
+	}
+	if req.Table != "" {
+		schema.Objects = slices.DeleteFunc(schema.Objects, func(obj SchemaObject) bool {
+			return !strings.EqualFold(obj.Table, req.Table)
+		})
+		if len(schema.Objects) == 0 {
+			return nil, fmt.Errorf("no such table: %s.%s", req.DB, req.Table)
+		}
+	}
+	return schema, nil
+}
+
+func rowLimit(limit int) int {
+	if limit <= 0 {
+		return defaultMCPRowLimit
+	}
+	return min(limit, maxMCPRowLimit)
+}
+
+var errRowLimit = errors.New("row limit reached")
+
+// collects rows until limit, then stops streamRows so the rest of the result
+// is never read
+type limitRowWriter struct {
+	result MCPQueryResult
+	limit  int
+}
+
+func (l *limitRowWriter) WriteHeader(cols []string) error {
+	l.result.Columns = cols
+	l.result.Rows = [][]any{}
+	return nil
+}
+
+func (l *limitRowWriter) WriteRow(values []any) error {
+	if len(l.result.Rows) == l.limit {
+		l.result.Truncated = true
+		return errRowLimit
+	}
+	row := make([]any, len(values))
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			v = fmt.Sprintf("<blob %d bytes>", len(b))
+		}
+		row[i] = v
+	}
+	l.result.Rows = append(l.result.Rows, row)
+	return nil
+}
+
+func (l *limitRowWriter) Close() error {
+	return nil
+}
+
+func (m *mcpServer) limitedRows(query string, limit int) (MCPQueryResult, error) {
+	w := &limitRowWriter{limit: rowLimit(limit)}
+	if _, err := streamRows(m.app.db, query, w); err != nil && !errors.Is(err, errRowLimit) {
+		return w.result, err
+	}
+	return w.result, nil
+}
+
+func (m *mcpServer) query(args json.RawMessage) (any, error) {
+	var req struct {
+		SQL   string `json:"sql"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil || req.SQL == "" {
+		return nil, errors.New("sql is required")
+	}
+	query := cleanQuery(req.SQL)
+	if !m.app.isReadOnlyQuery(query) {
+		if m.allowWrites {
+			return nil, errors.New("only single read-only statements are allowed, use execute for changes")
+		}
+		return nil, errors.New("only single read-only statements are allowed")
+	}
+	return m.limitedRows(query, req.Limit)
+}
+
+func (m *mcpServer) sampleTable(args json.RawMessage) (any, error) {
+	var req struct {
+		DB    string `json:"db"`
+		Table string `json:"table"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil || req.DB == "" || req.Table == "" {
+		return nil, errors.New("db and table are required")
+	}
+	if _, err := tableColumns(m.app.db, tableRef{DB: req.DB, Table: req.Table}); err != nil {
+		return nil, err
+	}
+	limit := rowLimit(req.Limit)
+	query := fmt.Sprintf("SELECT * FROM %s ORDER BY random() LIMIT %d;", quoteQualified(req.DB, req.Table), limit)
+	return m.limitedRows(query, limit)
+}
+
+func (m *mcpServer) execute(args json.RawMessage) (any, error) {
+	var req struct {
+		SQL string `json:"sql"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil || req.SQL == "" {
+		return nil, errors.New("sql is required")
+	}
+	res := m.app.Query(QueryRequest{Query: req.SQL})
+	return res.Results, res.Err
+}
+
+func cliMCP(s *cliSession, args []string) error {
+	fs := s.flagSet("mcp")
+	allowWrites := fs.Bool("allow-writes", false, "offer the execute tool, which runs statements that change databases")
+	if err := s.parse(fs, args, 0); err != nil {
+		return err
+	}
+	return newMCPServer(s.app, *allowWrites).serve(s.in, s.out)
+}
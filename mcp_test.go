@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// sends each request line to a fresh server and returns the decoded replies
+func mcpExchange(t *testing.T, allowWrites bool, lines ...string) []mcpResponse {
+	t.Helper()
+	var out bytes.Buffer
+	if err := newMCPServer(test_app, allowWrites).serve(strings.NewReader(strings.Join(lines, "\n")), &out); err != nil {
+		t.Fatalf("serve() error = %v", err)
+	}
+	var replies []mcpResponse
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var res struct {
+			mcpResponse
+			Result json.RawMessage `json:"result"`
+		}
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("bad reply: %v", err)
+		}
+		res.mcpResponse.Result = res.Result
+		replies = append(replies, res.mcpResponse)
+	}
+	return replies
+}
+
+func toolResult(t *testing.T, res mcpResponse) (string, bool) {
+	t.Helper()
+	if res.Error != nil {
+		t.Fatalf("rpc error %+v", res.Error)
+	}
+	var result mcpToolResult
+	if err := json.Unmarshal(res.Result.(json.RawMessage), &result); err != nil || len(result.Content) != 1 {
+		t.Fatalf("bad tool result %s: %v", res.Result, err)
+	}
+	return result.Content[0].Text, result.IsError
+}
+
+func TestMCPServer(t *testing.T) {
+	root := t.TempDir()
+	db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, "mcp.db"))
+	db.MustExec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT);
+		CREATE INDEX notes_body ON notes(body);
+		INSERT INTO notes (body) VALUES ('a'), ('b'), ('c'), ('d');`)
+	db.Close()
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	t.Run("Handshake", func(t *testing.T) {
+		replies := mcpExchange(t, false,
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+			`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+			`{"jsonrpc":"2.0","id":3,"method":"nope"}`,
+		)
+		if len(replies) != 3 {
+			t.Fatalf("got %d replies, want 3 (notifications are not answered)", len(replies))
+		}
+		if !strings.Contains(string(replies[0].Result.(json.RawMessage)), `"protocolVersion":"2025-03-26"`) {
+			t.Errorf("initialize result %s", replies[0].Result)
+		}
+		if list := string(replies[1].Result.(json.RawMessage)); !strings.Contains(list, `"sample_table"`) || strings.Contains(list, `"execute"`) {
+			t.Errorf("tools/list without writes = %s", list)
+		}
+		if replies[2].Error == nil || replies[2].Error.Code != mcpMethodNotFound {
+			t.Errorf("unknown method reply %+v", replies[2])
+		}
+	})
+
+	t.Run("Tools", func(t *testing.T) {
+		replies := mcpExchange(t, false,
+			`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"list_databases"}}`,
+			`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"describe_schema","arguments":{"db":"mcp","table":"notes"}}}`,
+			`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"query","arguments":{"sql":"SELECT body FROM mcp.notes ORDER BY id","limit":2}}}`,
+			`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"sample_table","arguments":{"db":"mcp","table":"notes","limit":3}}}`,
+		)
+		if text, isErr := toolResult(t, replies[0]); isErr || !strings.Contains(text, `"notes"`) {
+			t.Errorf("list_databases = %s", text)
+		}
+		if text, isErr := toolResult(t, replies[1]); isErr || !strings.Contains(text, "notes_body") {
+			t.Errorf("describe_schema = %s", text)
+		}
+		text, isErr := toolResult(t, replies[2])
+		var rows MCPQueryResult
+		if err := json.Unmarshal([]byte(text), &rows); isErr || err != nil || len(rows.Rows) != 2 || !rows.Truncated || rows.Rows[1][0] != "b" {
+			t.Errorf("query = %s", text)
+		}
+		text, _ = toolResult(t, replies[3])
+		if err := json.Unmarshal([]byte(text), &rows); err != nil || len(rows.Rows) != 3 || rows.Truncated {
+			t.Errorf("sample_table = %s", text)
+		}
+	})
+
+	t.Run("WritesGated", func(t *testing.T) {
+		replies := mcpExchange(t, false,
+			`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"query","arguments":{"sql":"DELETE FROM mcp.notes"}}}`,
+			`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"execute","arguments":{"sql":"DELETE FROM mcp.notes"}}}`,
+		)
+		if _, isErr := toolResult(t, replies[0]); !isErr {
+			t.Error("query ran a DELETE")
+		}
+		if replies[1].Error == nil {
+			t.Error("execute was offered without -allow-writes")
+		}
+		// statements that change the shared connections are not reads either
+		for _, sql := range []string{"BEGIN", "SAVEPOINT s", "PRAGMA foreign_keys = OFF", "PRAGMA query_only = 1"} {
+			replies := mcpExchange(t, false, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"query","arguments":{"sql":"`+sql+`"}}}`)
+			if _, isErr := toolResult(t, replies[0]); !isErr {
+				t.Errorf("query ran %s", sql)
+			}
+		}
+		replies = mcpExchange(t, true,
+			`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"execute","arguments":{"sql":"DELETE FROM mcp.notes WHERE id = 1"}}}`,
+		)
+		if text, isErr := toolResult(t, replies[0]); isErr || !strings.Contains(text, `"rowsAffected": 1`) {
+			t.Errorf("execute = %s", text)
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	TRACK_MIGRATIONS_TABLE = "table"
+	TRACK_USER_VERSION     = "user_version"
+)
+
+// matches 0001_create_users.sql, 0001_create_users.up.sql and 0001_create_users.down.sql
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_?(.*?)(\.up|\.down)?\.sql$`)
+
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+	HasDown bool   `json:"hasDown"`
+	Applied bool   `json:"applied"`
+
+	// user_version to restore when this migration is rolled back
+	previous int64
+}
+
+// reads every numbered migration in dir, ordered by version
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFileRegex.FindStringSubmatch(strings.ToLower(entry.Name()))
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %w", entry.Name(), err)
+		}
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if match[3] == ".down" {
+			m.Down = string(body)
+			m.HasDown = true
+			continue
+		}
+		if m.Up != "" {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		m.Up = string(body)
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db sqlx.Execer) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	return err
+}
+
+// marks which migrations have been applied to db
+func markAppliedMigrations(db *sqlx.DB, migrations []Migration, tracking string) error {
+	switch tracking {
+	case TRACK_USER_VERSION:
+		var userVersion int64
+		if err := db.Get(&userVersion, "PRAGMA user_version;"); err != nil {
+			return err
+		}
+		for i := range migrations {
+			migrations[i].Applied = migrations[i].Version <= userVersion
+		}
+	case TRACK_MIGRATIONS_TABLE:
+		var tracked int
+		if err := db.Get(&tracked, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations';"); err != nil {
+			return err
+		}
+		var applied []int64
+		if tracked > 0 {
+			if err := db.Select(&applied, "SELECT version FROM schema_migrations;"); err != nil {
+				return err
+			}
+		}
+		for i := range migrations {
+			migrations[i].Applied = slices.Contains(applied, migrations[i].Version)
+		}
+	default:
+		return fmt.Errorf("unknown migration tracking %q", tracking)
+	}
+	return nil
+}
+
+func recordMigration(tx *sqlx.Tx, m Migration, tracking string, up bool) error {
+	var err error
+	switch {
+	case tracking == TRACK_USER_VERSION && up:
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", m.Version))
+	case tracking == TRACK_USER_VERSION:
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", m.previous))
+	case up:
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?);", m.Version, m.Name)
+	default:
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?;", m.Version)
+	}
+	return err
+}
+
+// runs the given migrations in one transaction. With dryRun the transaction is
+// rolled back after every script succeeded, so the caller sees whether the
+// migrations would apply without changing the database.
+func runMigrations(db *sqlx.DB, migrations []Migration, tracking string, up bool, dryRun bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if tracking == TRACK_MIGRATIONS_TABLE {
+		if err := ensureMigrationsTable(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, m := range migrations {
+		script := m.Up
+		if !up {
+			script = m.Down
+		}
+		if _, err := tx.Exec(script); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+		if err := recordMigration(tx, m, tracking, up); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if dryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+func pendingMigrations(migrations []Migration) []Migration {
+	var pending []Migration
+	for _, m := range migrations {
+		if !m.Applied {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// returns the last steps applied migrations, newest first
+func rollbackCandidates(migrations []Migration, steps int) ([]Migration, error) {
+	var applied []Migration
+	for i := len(migrations) - 1; i >= 0 && len(applied) < steps; i-- {
+		if !migrations[i].Applied {
+			continue
+		}
+		if !migrations[i].HasDown {
+			return nil, fmt.Errorf("migration %d_%s has no down script", migrations[i].Version, migrations[i].Name)
+		}
+		m := migrations[i]
+		for j := i - 1; j >= 0; j-- {
+			if migrations[j].Applied {
+				m.previous = migrations[j].Version
+				break
+			}
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// opens its own connection to the file behind a registered database so
+// migration scripts run unqualified against it, under the app's policy
+func (a *App) openAttachedDB(dbName string) (*sqlx.DB, error) {
+	db, ok := a.registry.lookup(dbName)
+	if !ok {
+		return nil, fmt.Errorf("database %s is not stored", dbName)
+	}
+	uri := fileURI(db.Path, "")
+	if a.policy.flagged(dbName) {
+		uri = readOnlyURI(db.Path)
+	}
+	return a.openPolicedDB(uri, db.Name), nil
+}
+
+func (a *App) prepareMigrations(req MigrationRequest) (*sqlx.DB, []Migration, error) {
+	if req.DB == "" || req.Dir == "" {
+		return nil, nil, errors.New(BadRequestError)
+	}
+	migrations, err := loadMigrations(req.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := a.openAttachedDB(req.DB)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := markAppliedMigrations(db, migrations, req.tracking()); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, migrations, nil
+}
+
+// lists every migration in req.Dir and whether it has been applied to req.DB
+func (a *App) GetMigrationStatus(req MigrationRequest) AppResult {
+	db, migrations, err := a.prepareMigrations(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	return a.newResult(nil, map[string]any{
+		"migrations": migrations,
+		"pending":    pendingMigrations(migrations),
+	}, nil)
+}
+
+// applies every pending migration in a single transaction
+func (a *App) ApplyMigrations(req MigrationRequest) AppResult {
+	if err := a.checkWritable(req.DB); err != nil {
+		return a.newResult(err, nil, nil)
+	}
+	db, migrations, err := a.prepareMigrations(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	pending := pendingMigrations(migrations)
+	if err := runMigrations(db, pending, req.tracking(), true, req.DryRun); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, map[string]any{"pending": pending}, nil)
+	}
+	results := map[string]any{
+		"applied": pending,
+		"dryRun":  req.DryRun,
+	}
+	if req.DryRun {
+		preview := make([]map[string]any, len(pending))
+		for i, m := range pending {
+			preview[i] = map[string]any{"version": m.Version, "name": m.Name, "sql": m.Up}
+		}
+		results["preview"] = preview
+	}
+	return a.newResult(nil, results, nil)
+}
+
+// runs the down scripts of the last req.Steps applied migrations
+func (a *App) RollbackMigrations(req MigrationRequest) AppResult {
+	if err := a.checkWritable(req.DB); err != nil {
+		return a.newResult(err, nil, nil)
+	}
+	db, migrations, err := a.prepareMigrations(req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	if req.Steps <= 0 {
+		req.Steps = 1
+	}
+	targets, err := rollbackCandidates(migrations, req.Steps)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	if err := runMigrations(db, targets, req.tracking(), false, req.DryRun); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{
+		"rolledBack": targets,
+		"dryRun":     req.DryRun,
+	}, nil)
+}
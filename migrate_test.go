@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func writeMigrationFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), SafePermissions); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    int
+		wantErr bool
+	}{
+		{"Up and down pairs", map[string]string{
+			"0002_add_email.up.sql":   "ALTER TABLE users ADD COLUMN email TEXT;",
+			"0001_init.sql":           "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+			"0002_add_email.down.sql": "ALTER TABLE users DROP COLUMN email;",
+			"README.md":               "ignored",
+		}, 2, false},
+		{"Down without up", map[string]string{"0001_init.down.sql": "DROP TABLE users;"}, 0, true},
+		{"Duplicate version", map[string]string{"1_a.sql": "SELECT 1;", "0001_b.sql": "SELECT 1;"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(writeMigrationFiles(t, tt.files))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(migrations) != tt.want {
+				t.Fatalf("got %d migrations, want %d", len(migrations), tt.want)
+			}
+			for i := 1; i < len(migrations); i++ {
+				if migrations[i-1].Version >= migrations[i].Version {
+					t.Errorf("migrations out of order: %v", migrations)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMigrations(t *testing.T) {
+	dir := writeMigrationFiles(t, map[string]string{
+		"0001_init.up.sql":        "CREATE TABLE users (id INTEGER PRIMARY KEY);",
+		"0001_init.down.sql":      "DROP TABLE users;",
+		"0002_add_email.up.sql":   "ALTER TABLE users ADD COLUMN email TEXT;",
+		"0002_add_email.down.sql": "ALTER TABLE users DROP COLUMN email;",
+	})
+	for _, tracking := range []string{TRACK_MIGRATIONS_TABLE, TRACK_USER_VERSION} {
+		t.Run(tracking, func(t *testing.T) {
+			db, err := sqlx.Open(SQLITE_DRIVER, filepath.Join(t.TempDir(), "target.db"))
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer db.Close()
+
+			status := func() []Migration {
+				migrations, err := loadMigrations(dir)
+				if err != nil {
+					t.Fatalf("loadMigrations() error = %v", err)
+				}
+				if err := markAppliedMigrations(db, migrations, tracking); err != nil {
+					t.Fatalf("markAppliedMigrations() error = %v", err)
+				}
+				return migrations
+			}
+
+			if err := runMigrations(db, pendingMigrations(status()), tracking, true, true); err != nil {
+				t.Fatalf("dry run error = %v", err)
+			}
+			if pending := pendingMigrations(status()); len(pending) != 2 {
+				t.Fatalf("dry run changed the database, %d pending", len(pending))
+			}
+
+			if err := runMigrations(db, pendingMigrations(status()), tracking, true, false); err != nil {
+				t.Fatalf("runMigrations() error = %v", err)
+			}
+			if pending := pendingMigrations(status()); len(pending) != 0 {
+				t.Fatalf("got %d pending after apply, want 0", len(pending))
+			}
+
+			targets, err := rollbackCandidates(status(), 1)
+			if err != nil {
+				t.Fatalf("rollbackCandidates() error = %v", err)
+			}
+			if err := runMigrations(db, targets, tracking, false, false); err != nil {
+				t.Fatalf("rollback error = %v", err)
+			}
+			pending := pendingMigrations(status())
+			if len(pending) != 1 || pending[0].Version != 2 {
+				t.Fatalf("got pending %v after rollback, want version 2", pending)
+			}
+			var cols int
+			if err := db.Get(&cols, "SELECT COUNT(*) FROM pragma_table_info('users');"); err != nil || cols != 1 {
+				t.Errorf("expected email column to be dropped, got %d columns (%v)", cols, err)
+			}
+		})
+	}
+}
+
+func TestOpenAttachedDB(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "odd?name#1.db")
+	newWatchTestDB(t, fileURI(path, ""))
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE path = ?;", path)
+	}()
+	if added, err := test_app.attachDBFile(path); !added || err != nil {
+		t.Fatalf("attachDBFile() = %v, %v", added, err)
+	}
+	stored, _ := test_app.registry.byPath(path)
+
+	db, err := test_app.openAttachedDB(stored.Name)
+	if err != nil {
+		t.Fatalf("openAttachedDB() error = %v", err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM rows;"); err != nil {
+		t.Fatalf("querying the file by its path: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY);"); err != nil {
+		t.Fatalf("migration statement error = %v", err)
+	}
+	var policyErr *PolicyError
+	if _, err := db.Exec("ATTACH ':memory:' AS extra;"); !errors.As(err, &policyErr) || policyErr.Rule != RULE_ATTACH {
+		t.Errorf("ATTACH in a migration: %v, want the attach rule", err)
+	}
+
+	test_app.policy.set(stored.Name, true)
+	defer test_app.policy.set(stored.Name, false)
+	if _, err := db.Exec("DROP TABLE users;"); !errors.As(err, &policyErr) || policyErr.Rule != RULE_READ_ONLY || policyErr.DB != stored.Name {
+		t.Errorf("a migration on a read-only database: %v, want it refused", err)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("got %d files, want only the database itself", len(entries))
+	}
+}
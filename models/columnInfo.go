@@ -1,10 +1,11 @@
 package models
 
 type ColumnInfo struct {
-	Name       string  `db:"name"`
-	Type       string  `db:"type"`
-	NotNull    bool    `db:"notnull"`
-	PK         int     `db:"pk"`
-	CID        string  `db:"cid"`
-	DFLT_value *string `db:"dflt_value"`
+	Name       string  `db:"name" json:"name"`
+	Type       string  `db:"type" json:"type"`
+	NotNull    bool    `db:"notnull" json:"notNull"`
+	PK         int     `db:"pk" json:"pk"`
+	CID        string  `db:"cid" json:"cid"`
+	DFLT_value *string `db:"dflt_value" json:"default"`
+	Hidden     int     `db:"hidden" json:"hidden,omitempty"`
 }
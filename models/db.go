@@ -6,5 +6,6 @@ type DB struct {
 	Path        string `db:"path"`
 	Root        string `db:"root"`
 	App_Created bool   `db:"app_created"`
+	Read_Only   bool   `db:"read_only"`
 	Created_At  string `db:"created_at"`
 }
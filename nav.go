@@ -9,6 +9,9 @@ func (a *App) GetNavData() AppResult {
 	type DBResult struct {
 		Tables     []string `json:"tables"`
 		AppCreated bool     `json:"appCreated"`
+		ReadOnly   bool     `json:"readOnly"`
+		// the file is gone; the database is kept until it comes back
+		Missing bool `json:"missing"`
 	}
 	var mainTables []string
 
@@ -24,24 +27,23 @@ func (a *App) GetNavData() AppResult {
 
 		return a.newResult(err, nil, nil)
 	}
-	data["main"] = DBResult{mainTables, false}
+	data["main"] = DBResult{mainTables, false, false, false}
 	otherDBS, err := a.getStoredDBs()
 	if err != nil {
 		a.logger.Error(fmt.Sprintf("Failed to fetch tables: %s", err.Error()))
 		return a.newResult(err, nil, nil)
 	}
 	for _, db := range otherDBS {
-		dbName, err := a.getSQLiteDBName(db.Path)
-		if err != nil {
-			a.logger.Error(err.Error())
-			return a.newResult(err, nil, nil)
+		if a.registry.isMissing(db.Name) {
+			data[db.Name] = DBResult{[]string{}, db.App_Created, db.Read_Only, true}
+			continue
 		}
-		tables, err := a.getTableList(dbName)
+		tables, err := a.getTableList(db.Name)
 		if err != nil {
 			a.logger.Error(fmt.Sprintf("Failed to fetch tables: %s", err.Error()))
 			return a.newResult(err, nil, nil)
 		}
-		data[db.Name] = DBResult{tables, db.App_Created}
+		data[db.Name] = DBResult{tables, db.App_Created, db.Read_Only || a.policy.isReadOnly(db.Name), false}
 	}
 	return a.newResult(
 		nil,
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/encoding"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+const (
+	FORMAT_PARQUET = ".parquet"
+
+	parquetReadBatch = 1024
+	// rows buffered to guess the type of columns without a declared type
+	parquetSampleRows = 1000
+	// Julian day of 1970-01-01, used by legacy INT96 timestamps
+	julianUnixEpoch = 2440588
+)
+
+var parquetCodecs = map[string]compress.Codec{
+	"":             &parquet.Snappy,
+	"snappy":       &parquet.Snappy,
+	"gzip":         &parquet.Gzip,
+	"zstd":         &parquet.Zstd,
+	"lz4":          &parquet.Lz4Raw,
+	"brotli":       &parquet.Brotli,
+	"none":         &parquet.Uncompressed,
+	"uncompressed": &parquet.Uncompressed,
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	codec, ok := parquetCodecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown parquet compression %q", name)
+	}
+	return codec, nil
+}
+
+// a leaf column of a parquet file as it is imported into SQLite
+type parquetColumn struct {
+	name     string
+	leaf     parquet.LeafColumn
+	affinity string
+}
+
+// maps the physical and logical type of a leaf to a SQLite column affinity
+func parquetAffinity(leaf parquet.LeafColumn) string {
+	if leaf.MaxRepetitionLevel > 0 {
+		return "TEXT"
+	}
+	t := leaf.Node.Type()
+	if lt := t.LogicalType(); lt != nil {
+		switch {
+		case lt.Decimal != nil:
+			return "REAL"
+		case lt.Integer != nil:
+			return "INTEGER"
+		case lt.UTF8 != nil, lt.Enum != nil, lt.Json != nil, lt.UUID != nil,
+			lt.Date != nil, lt.Time != nil, lt.Timestamp != nil:
+			return "TEXT"
+		}
+	}
+	switch t.Kind() {
+	case parquet.Boolean, parquet.Int32, parquet.Int64:
+		return "INTEGER"
+	case parquet.Float, parquet.Double:
+		return "REAL"
+	case parquet.Int96:
+		return "TEXT"
+	}
+	if ct := t.ConvertedType(); ct != nil && *ct == deprecated.UTF8 {
+		return "TEXT"
+	}
+	return "BLOB"
+}
+
+func timeUnitDuration(unit format.TimeUnit) time.Duration {
+	switch {
+	case unit.Millis != nil:
+		return time.Millisecond
+	case unit.Nanos != nil:
+		return time.Nanosecond
+	}
+	return time.Microsecond
+}
+
+// decodes a big-endian two's complement decimal
+func decimalFromBytes(b []byte, scale int32) float64 {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(n), new(big.Float).SetFloat64(math.Pow10(int(scale)))).Float64()
+	return f
+}
+
+// converts a parquet value to what gets stored in SQLite
+func parquetValue(v parquet.Value, t parquet.Type) any {
+	if v.IsNull() {
+		return nil
+	}
+	if lt := t.LogicalType(); lt != nil {
+		switch {
+		case lt.Decimal != nil:
+			switch t.Kind() {
+			case parquet.Int32, parquet.Int64:
+				return float64(v.Int64()) / math.Pow10(int(lt.Decimal.Scale))
+			default:
+				return decimalFromBytes(v.ByteArray(), lt.Decimal.Scale)
+			}
+		case lt.Date != nil:
+			return time.Unix(int64(v.Int32())*86400, 0).UTC().Format(time.DateOnly)
+		case lt.Timestamp != nil:
+			d := timeUnitDuration(lt.Timestamp.Unit)
+			ts := time.Unix(0, 0).Add(time.Duration(v.Int64()) * d).UTC()
+			return ts.Format("2006-01-02 15:04:05.999999999")
+		case lt.Time != nil:
+			d := timeUnitDuration(lt.Time.Unit)
+			raw := v.Int64()
+			if t.Kind() == parquet.Int32 {
+				raw = int64(v.Int32())
+			}
+			return time.Time{}.Add(time.Duration(raw) * d).Format("15:04:05.999999999")
+		case lt.UUID != nil:
+			b := v.ByteArray()
+			if len(b) == 16 {
+				h := hex.EncodeToString(b)
+				return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+			}
+		case lt.Integer != nil && !lt.Integer.IsSigned && lt.Integer.BitWidth == 64:
+			if u := v.Uint64(); u > math.MaxInt64 {
+				return float64(u)
+			}
+		case lt.UTF8 != nil, lt.Enum != nil, lt.Json != nil:
+			return string(v.ByteArray())
+		}
+	}
+	switch t.Kind() {
+	case parquet.Boolean:
+		return v.Boolean()
+	case parquet.Int32:
+		if lt := t.LogicalType(); lt != nil && lt.Integer != nil && !lt.Integer.IsSigned {
+			return int64(v.Uint32())
+		}
+		return int64(v.Int32())
+	case parquet.Int64:
+		return v.Int64()
+	case parquet.Int96:
+		i := v.Int96()
+		nanos := int64(uint64(i[1])<<32 | uint64(i[0]))
+		days := int64(i[2]) - julianUnixEpoch
+		return time.Unix(days*86400, nanos).UTC().Format("2006-01-02 15:04:05.999999999")
+	case parquet.Float:
+		return float64(v.Float())
+	case parquet.Double:
+		return v.Double()
+	}
+	if ct := t.ConvertedType(); ct != nil && *ct == deprecated.UTF8 {
+		return string(v.ByteArray())
+	}
+	return bytes.Clone(v.ByteArray())
+}
+
+// loads every row of a parquet file into table, creating it from the parquet
+// schema when it does not exist yet. Nested columns are flattened into
+// parent_child names and repeated values are stored as a JSON array.
+func importParquet(db *sqlx.DB, r io.ReaderAt, size int64, table string) (int64, error) {
+	file, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return 0, fmt.Errorf("not a parquet file: %w", err)
+	}
+	schema := file.Schema()
+	var cols []parquetColumn
+	for _, path := range schema.Columns() {
+		leaf, ok := schema.Lookup(path...)
+		if !ok {
+			return 0, fmt.Errorf("parquet column %s not found", strings.Join(path, "."))
+		}
+		var parts []string
+		for _, p := range path {
+			// list and map wrappers add no meaning to the column name
+			if p != "list" && p != "element" && p != "key_value" {
+				parts = append(parts, p)
+			}
+		}
+		cols = append(cols, parquetColumn{name: strings.Join(parts, "_"), leaf: leaf, affinity: parquetAffinity(leaf)})
+	}
+	if len(cols) == 0 {
+		return 0, errors.New("parquet file has no columns")
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var exists int
+	if err := tx.Get(&exists, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;", table); err != nil {
+		return 0, err
+	}
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = quoteIdent(col.name)
+	}
+	if exists == 0 {
+		defs := make([]string, len(cols))
+		for i, col := range cols {
+			defs[i] = names[i] + " " + col.affinity
+		}
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s);", quoteIdent(table), strings.Join(defs, ", "))); err != nil {
+			return 0, err
+		}
+	}
+	stmt, err := tx.Preparex(fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s);",
+		quoteIdent(table), strings.Join(names, ", "), generateINSERTPlaceholders(len(cols)),
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	buf := make([]parquet.Row, parquetReadBatch)
+	values := make([]any, len(cols))
+	repeated := make([][]any, len(cols))
+	var count int64
+	for {
+		n, readErr := reader.ReadRows(buf)
+		for _, row := range buf[:n] {
+			for i := range values {
+				values[i] = nil
+				repeated[i] = repeated[i][:0]
+			}
+			for _, v := range row {
+				i := v.Column()
+				col := cols[i]
+				if col.leaf.MaxRepetitionLevel == 0 {
+					values[i] = parquetValue(v, col.leaf.Node.Type())
+				} else if v.DefinitionLevel() == col.leaf.MaxDefinitionLevel {
+					repeated[i] = append(repeated[i], parquetValue(v, col.leaf.Node.Type()))
+				}
+			}
+			for i, col := range cols {
+				if col.leaf.MaxRepetitionLevel > 0 && len(repeated[i]) > 0 {
+					body, err := json.Marshal(repeated[i])
+					if err != nil {
+						return count, err
+					}
+					values[i] = string(body)
+				}
+			}
+			if _, err := stmt.Exec(values...); err != nil {
+				return count, fmt.Errorf("row %d: %w", count+1, err)
+			}
+			count++
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return count, readErr
+		}
+	}
+	return count, tx.Commit()
+}
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquet.Group orders its fields by name; exports keep the column order of
+// the result set instead
+type parquetGroup []parquet.Field
+
+func (g parquetGroup) ID() int                     { return 0 }
+func (g parquetGroup) String() string              { return g.group().String() }
+func (g parquetGroup) Type() parquet.Type          { return parquet.Group{}.Type() }
+func (g parquetGroup) Optional() bool              { return false }
+func (g parquetGroup) Repeated() bool              { return false }
+func (g parquetGroup) Required() bool              { return true }
+func (g parquetGroup) Leaf() bool                  { return false }
+func (g parquetGroup) Fields() []parquet.Field     { return g }
+func (g parquetGroup) Encoding() encoding.Encoding { return nil }
+func (g parquetGroup) Compression() compress.Codec { return nil }
+func (g parquetGroup) GoType() reflect.Type        { return g.group().GoType() }
+
+func (g parquetGroup) group() parquet.Group {
+	group := make(parquet.Group, len(g))
+	for _, f := range g {
+		group[f.Name()] = f
+	}
+	return group
+}
+
+const (
+	parquetInt64     = "int64"
+	parquetDouble    = "double"
+	parquetBoolean   = "boolean"
+	parquetString    = "string"
+	parquetBytes     = "bytes"
+	parquetTimestamp = "timestamp"
+)
+
+// picks a parquet type from a declared column type, following SQLite's
+// affinity rules; an empty result means the type has to be sampled
+func parquetKindOfDeclType(declType string) string {
+	declType = strings.ToUpper(declType)
+	switch {
+	case declType == "":
+		return ""
+	case strings.Contains(declType, "BOOL"):
+		return parquetBoolean
+	case strings.Contains(declType, "INT"):
+		return parquetInt64
+	case strings.Contains(declType, "CHAR"), strings.Contains(declType, "CLOB"), strings.Contains(declType, "TEXT"):
+		return parquetString
+	case strings.Contains(declType, "BLOB"):
+		return parquetBytes
+	case strings.Contains(declType, "DATE"), strings.Contains(declType, "TIME"):
+		return parquetTimestamp
+	}
+	return parquetDouble
+}
+
+// converts a scanned SQLite value to the Go value stored in a column of kind
+func parquetConvert(kind string, value any, opts CSVOptions) (any, bool) {
+	switch kind {
+	case parquetInt64:
+		switch v := value.(type) {
+		case int64:
+			return v, true
+		case float64:
+			return int64(v), v == math.Trunc(v) && math.Abs(v) < 1<<63
+		case bool:
+			if v {
+				return int64(1), true
+			}
+			return int64(0), true
+		}
+	case parquetDouble:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		}
+	case parquetBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case int64:
+			return v != 0, v == 0 || v == 1
+		}
+	case parquetTimestamp:
+		if v, ok := value.(time.Time); ok {
+			return v.UnixMicro(), true
+		}
+	case parquetBytes:
+		switch v := value.(type) {
+		case []byte:
+			return v, true
+		case string:
+			return []byte(v), true
+		}
+	case parquetString:
+		if v, ok := value.([]byte); ok && utf8.Valid(v) {
+			return string(v), true
+		}
+		text, _ := opts.formatValue(value)
+		return text, true
+	}
+	return nil, false
+}
+
+// guesses a column kind from sampled values
+func inferParquetKind(values []any) string {
+	kind := ""
+	for _, v := range values {
+		var k string
+		switch v.(type) {
+		case nil:
+			continue
+		case int64:
+			k = parquetInt64
+		case float64:
+			k = parquetDouble
+		case bool:
+			k = parquetBoolean
+		case time.Time:
+			k = parquetTimestamp
+		case []byte:
+			k = parquetBytes
+		default:
+			return parquetString
+		}
+		switch {
+		case kind == "" || kind == k:
+			kind = k
+		case (kind == parquetInt64 && k == parquetDouble) || (kind == parquetDouble && k == parquetInt64):
+			kind = parquetDouble
+		default:
+			return parquetString
+		}
+	}
+	if kind == "" {
+		return parquetString
+	}
+	return kind
+}
+
+func parquetNode(kind string) parquet.Node {
+	switch kind {
+	case parquetInt64:
+		return parquet.Int(64)
+	case parquetDouble:
+		return parquet.Leaf(parquet.DoubleType)
+	case parquetBoolean:
+		return parquet.Leaf(parquet.BooleanType)
+	case parquetBytes:
+		return parquet.Leaf(parquet.ByteArrayType)
+	case parquetTimestamp:
+		return parquet.Timestamp(parquet.Microsecond)
+	}
+	return parquet.String()
+}
+
+// rowWriter that writes a parquet file. The schema is fixed from the declared
+// column types, with the first parquetSampleRows rows buffered to type the
+// columns that have none (expressions) or whose values do not match it.
+// Later rows are spooled to a temporary file and the parquet file is written
+// on Close, so a column can still widen to string when a later value does
+// not fit its kind.
+type parquetRowWriter struct {
+	out       io.Writer
+	codec     compress.Codec
+	opts      CSVOptions
+	cols      []string
+	declTypes []string
+	kinds     []string
+	pending   [][]any
+	spool     *os.File
+	spooled   *bufio.Writer
+	enc       *gob.Encoder
+	writer    *parquet.Writer
+	batch     []parquet.Row
+}
+
+func newParquetRowWriter(w io.Writer, compression string) (*parquetRowWriter, error) {
+	codec, err := parquetCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	// the spool holds scanned values, of which only times need registering
+	gob.Register(time.Time{})
+	opts, _ := CSVOptions{}.normalize()
+	return &parquetRowWriter{out: w, codec: codec, opts: opts}, nil
+}
+
+func (p *parquetRowWriter) SetColumnTypes(types []*sql.ColumnType) {
+	p.declTypes = make([]string, len(types))
+	for i, t := range types {
+		p.declTypes[i] = t.DatabaseTypeName()
+	}
+}
+
+func (p *parquetRowWriter) WriteHeader(cols []string) error {
+	p.cols = cols
+	return nil
+}
+
+func (p *parquetRowWriter) WriteRow(values []any) error {
+	if p.kinds != nil {
+		return p.spoolRow(values)
+	}
+	p.pending = append(p.pending, slices.Clone(values))
+	if len(p.pending) < parquetSampleRows {
+		return nil
+	}
+	return p.start()
+}
+
+// types the columns from the buffered rows and moves them to the spool
+func (p *parquetRowWriter) start() error {
+	p.kinds = make([]string, len(p.cols))
+	for i := range p.cols {
+		sample := make([]any, len(p.pending))
+		for j, row := range p.pending {
+			sample[j] = row[i]
+		}
+		kind := ""
+		if i < len(p.declTypes) {
+			kind = parquetKindOfDeclType(p.declTypes[i])
+		}
+		for _, v := range sample {
+			if _, ok := parquetConvert(kind, v, p.opts); v != nil && !ok {
+				kind = ""
+				break
+			}
+		}
+		if kind == "" {
+			kind = inferParquetKind(sample)
+		}
+		p.kinds[i] = kind
+	}
+	spool, err := os.CreateTemp("", "sqlitegui-parquet")
+	if err != nil {
+		return err
+	}
+	p.spool = spool
+	p.spooled = bufio.NewWriter(spool)
+	p.enc = gob.NewEncoder(p.spooled)
+	for _, row := range p.pending {
+		if err := p.spoolRow(row); err != nil {
+			return err
+		}
+	}
+	p.pending = nil
+	return nil
+}
+
+func (p *parquetRowWriter) spoolRow(values []any) error {
+	for i, v := range values {
+		if _, ok := parquetConvert(p.kinds[i], v, p.opts); v != nil && !ok {
+			// strings take the text form of every value
+			p.kinds[i] = parquetString
+		}
+	}
+	return p.enc.Encode(values)
+}
+
+func (p *parquetRowWriter) write(values []any) error {
+	row := make(parquet.Row, len(values))
+	for i, v := range values {
+		if v == nil {
+			row[i] = parquet.NullValue().Level(0, 0, i)
+			continue
+		}
+		converted, ok := parquetConvert(p.kinds[i], v, p.opts)
+		if !ok {
+			return fmt.Errorf("column %s: cannot store %T in a %s column", p.cols[i], v, p.kinds[i])
+		}
+		row[i] = parquet.ValueOf(converted).Level(0, 1, i)
+	}
+	p.batch = append(p.batch, row)
+	if len(p.batch) >= parquetReadBatch {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *parquetRowWriter) flush() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+	_, err := p.writer.WriteRows(p.batch)
+	p.batch = p.batch[:0]
+	return err
+}
+
+// writes the spooled rows as a parquet file with the final column kinds
+func (p *parquetRowWriter) Close() error {
+	defer p.Discard()
+	if p.kinds == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	if err := p.spooled.Flush(); err != nil {
+		return err
+	}
+	if _, err := p.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fields := make(parquetGroup, len(p.cols))
+	seen := make(map[string]bool)
+	for i, col := range p.cols {
+		name := col
+		for n := 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", col, n)
+		}
+		seen[name] = true
+		fields[i] = parquetField{Node: parquet.Optional(parquetNode(p.kinds[i])), name: name}
+	}
+	p.writer = parquet.NewWriter(p.out, parquet.NewSchema("sqlitegui", fields), parquet.Compression(p.codec))
+	dec := gob.NewDecoder(bufio.NewReader(p.spool))
+	for {
+		var values []any
+		if err := dec.Decode(&values); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := p.write(values); err != nil {
+			return err
+		}
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.writer.Close()
+}
+
+// removes the spool of an export that failed before Close
+func (p *parquetRowWriter) Discard() {
+	if p.spool != nil {
+		p.spool.Close()
+		os.Remove(p.spool.Name())
+		p.spool = nil
+	}
+}
+
+// loads a parquet file into req.Table of the attached database req.DB
+func (a *App) ImportParquet(req ParquetImportRequest) AppResult {
+	if req.Path == "" || req.DB == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	if req.Table == "" {
+		req.Table, _ = parseFile(req.Path)
+	}
+	file, err := os.Open(req.Path)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	db, err := a.openWritableDB(req.DB)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	n, err := importParquet(db, file, info.Size(), req.Table)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"table": req.Table, "rows": n}, nil)
+}
+
+func (a *App) ExportParquet(req ParquetExportRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	query := cleanQuery(req.Query)
+	table := req.Table
+	switch {
+	case query != "":
+		if !a.isExportableQuery(query) {
+			return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+		}
+	case req.DB != "" && req.Table != "":
+		query = fmt.Sprintf("SELECT * FROM %s;", quoteQualified(req.DB, req.Table))
+	default:
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	n, err := a.exportQuery(query, FORMAT_PARQUET, req.Path, ExportOptions{Table: table, Compression: req.Compression})
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": req.Path, "rows": n}, nil)
+}
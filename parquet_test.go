@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	src, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+	src.MustExec(`
+	CREATE TABLE events (zid INTEGER, title TEXT, score REAL, at DATETIME, payload BLOB, active BOOLEAN);
+	INSERT INTO events VALUES
+		(1, 'first', 0.5, '2024-03-01 10:00:00', X'00FF', 1),
+		(2, NULL, NULL, NULL, NULL, 0),
+		(3, 'third', 7, '2024-03-03 12:30:00', X'', NULL);
+	`)
+
+	for _, compression := range []string{"", "zstd", "gzip", "none"} {
+		t.Run("compression "+compression, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRowWriter(&buf, FORMAT_PARQUET, ExportOptions{Compression: compression})
+			if err != nil {
+				t.Fatalf("newRowWriter() error = %v", err)
+			}
+			if _, err := streamRows(src, "SELECT *, zid * 1.5 AS half FROM events ORDER BY zid;", w); err != nil {
+				t.Fatalf("streamRows() error = %v", err)
+			}
+
+			file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("OpenFile() error = %v", err)
+			}
+			var names []string
+			for _, f := range file.Schema().Fields() {
+				names = append(names, f.Name())
+			}
+			wantNames := []string{"zid", "title", "score", "at", "payload", "active", "half"}
+			if len(names) != len(wantNames) {
+				t.Fatalf("parquet columns = %v, want %v", names, wantNames)
+			}
+			for i := range names {
+				if names[i] != wantNames[i] {
+					t.Fatalf("parquet columns = %v, want %v", names, wantNames)
+				}
+			}
+
+			dst, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer dst.Close()
+			dst.SetMaxOpenConns(1)
+			n, err := importParquet(dst, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "events")
+			if err != nil || n != 3 {
+				t.Fatalf("importParquet() = %d, %v, want 3 rows", n, err)
+			}
+			var types string
+			dst.Get(&types, "SELECT group_concat(type, ',') FROM pragma_table_info('events');")
+			if types != "INTEGER,TEXT,REAL,TEXT,BLOB,INTEGER,REAL" {
+				t.Errorf("imported column types = %s", types)
+			}
+			query := "SELECT group_concat(quote(zid) || quote(title) || quote(score) || quote(substr(at, 1, 19)) || quote(payload) || quote(active) || quote(half), '|') FROM events;"
+			var want, got string
+			src.Get(&want, "SELECT group_concat(quote(zid) || quote(title) || quote(score) || quote(at) || quote(payload) || quote(active) || quote(zid * 1.5), '|') FROM events;")
+			dst.Get(&got, query)
+			if got != want {
+				t.Errorf("imported rows = %s, want %s", got, want)
+			}
+
+			// a second import appends to the existing table
+			if n, err := importParquet(dst, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "events"); err != nil || n != 3 {
+				t.Errorf("appending importParquet() = %d, %v", n, err)
+			}
+		})
+	}
+
+	if _, err := newRowWriter(&bytes.Buffer{}, FORMAT_PARQUET, ExportOptions{Compression: "rar"}); err == nil {
+		t.Error("newRowWriter() accepted an unknown compression")
+	}
+}
+
+func TestParquetWidening(t *testing.T) {
+	spoolDir := t.TempDir()
+	t.Setenv("TMPDIR", spoolDir)
+	src, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer src.Close()
+	src.SetMaxOpenConns(1)
+	// the text comes after the sampled rows typed the column as integers
+	src.MustExec(`
+	CREATE TABLE counts (n INTEGER);
+	WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < 1200)
+	INSERT INTO counts SELECT i FROM seq;
+	INSERT INTO counts VALUES ('n/a');
+	`)
+
+	var buf bytes.Buffer
+	w, err := newRowWriter(&buf, FORMAT_PARQUET, ExportOptions{})
+	if err != nil {
+		t.Fatalf("newRowWriter() error = %v", err)
+	}
+	if _, err := streamRows(src, "SELECT n FROM counts ORDER BY rowid;", w); err != nil {
+		t.Fatalf("streamRows() error = %v", err)
+	}
+	dst, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer dst.Close()
+	dst.SetMaxOpenConns(1)
+	if n, err := importParquet(dst, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "counts"); err != nil || n != 1201 {
+		t.Fatalf("importParquet() = %d, %v, want 1201 rows", n, err)
+	}
+	var got string
+	dst.Get(&got, "SELECT type || ':' || (SELECT group_concat(n, ',') FROM (SELECT n FROM counts WHERE rowid IN (1, 1201))) FROM pragma_table_info('counts');")
+	if got != "TEXT:1,n/a" {
+		t.Errorf("widened column = %q, want TEXT:1,n/a", got)
+	}
+	if entries, _ := os.ReadDir(spoolDir); len(entries) != 0 {
+		t.Errorf("the export left %v behind", entries)
+	}
+}
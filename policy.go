@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+type PolicyRule string
+
+const (
+	RULE_READ_ONLY PolicyRule = "readOnly"
+	RULE_ATTACH    PolicyRule = "attach"
+	RULE_PRAGMA    PolicyRule = "pragma"
+	RULE_FUNCTION  PolicyRule = "function"
+	RULE_APP_TABLE PolicyRule = "appTable"
+)
+
+// PolicyError reports the rule a statement broke
+type PolicyError struct {
+	Rule PolicyRule `json:"rule"`
+	// the database the statement would change; empty for a read-only
+	// violation when the whole app is read-only
+	DB string `json:"db,omitempty"`
+	// the pragma, function or attached file concerned
+	Target string `json:"target,omitempty"`
+}
+
+func (e *PolicyError) Error() string {
+	switch e.Rule {
+	case RULE_READ_ONLY:
+		if e.DB == "" {
+			return "the app is in read-only mode"
+		}
+		return fmt.Sprintf("database %s is read-only", e.DB)
+	case RULE_ATTACH:
+		return "ATTACH and DETACH are disabled"
+	case RULE_PRAGMA:
+		return fmt.Sprintf("PRAGMA %s cannot be changed", e.Target)
+	case RULE_FUNCTION:
+		return fmt.Sprintf("function %s is not allowed", e.Target)
+	case RULE_APP_TABLE:
+		return fmt.Sprintf("table %s belongs to the app and cannot be changed", e.Target)
+	}
+	return fmt.Sprintf("statement breaks the %s rule", e.Rule)
+}
+
+// PolicyConfig is the rule set applied to every statement run on the app's
+// connection. Databases flagged read-only in main.dbs come on top of it.
+type PolicyConfig struct {
+	// refuse writes to every database except the app's own tables
+	ReadOnly bool `json:"readOnly"`
+	// let statements ATTACH and DETACH databases
+	AllowAttach bool `json:"allowAttach"`
+	// pragmas that may be read but not set
+	DeniedPragmas []string `json:"deniedPragmas"`
+	// SQL functions that may not be called
+	DeniedFunctions []string `json:"deniedFunctions"`
+}
+
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		DeniedPragmas:   []string{"writable_schema", "schema_version", "trusted_schema", "temp_store_directory", "data_store_directory"},
+		DeniedFunctions: []string{"load_extension"},
+	}
+}
+
+// reads a PolicyConfig from the JSON file at path; fields the file leaves
+// out keep their defaults. An empty path returns nil.
+func loadPolicyConfig(path string) (*PolicyConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := DefaultPolicyConfig()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("policy file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// actions that change a database, passed to the authorizer
+var writeActions = []int{
+	sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE,
+	sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TRIGGER,
+	sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_CREATE_VTABLE,
+	sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TABLE, sqlite3.SQLITE_DROP_TRIGGER,
+	sqlite3.SQLITE_DROP_VIEW, sqlite3.SQLITE_DROP_VTABLE,
+	sqlite3.SQLITE_ALTER_TABLE, sqlite3.SQLITE_REINDEX, sqlite3.SQLITE_ANALYZE,
+}
+
+// pragmas that change the database when given a value
+var writePragmas = []string{
+	"application_id", "auto_vacuum", "incremental_vacuum", "journal_mode",
+	"page_size", "schema_version", "user_version", "wal_checkpoint",
+}
+
+// pragmas whose argument names what to read rather than a new value
+var argumentPragmas = []string{
+	"foreign_key_check", "foreign_key_list", "index_info", "index_list",
+	"index_xinfo", "integrity_check", "quick_check", "table_info",
+	"table_list", "table_xinfo",
+}
+
+// reports whether the action changes the state of the connection rather
+// than a database: transactions, savepoints, attached databases and pragma
+// settings. SQLite counts such statements as read-only, yet one left behind
+// on a pooled connection affects every statement that runs on it later.
+func changesConnection(action int, arg1 string, arg2 string) bool {
+	switch action {
+	case sqlite3.SQLITE_TRANSACTION, sqlite3.SQLITE_SAVEPOINT, sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		return true
+	case sqlite3.SQLITE_PRAGMA:
+		return arg2 != "" && !containsFold(argumentPragmas, arg1)
+	}
+	return false
+}
+
+// the rules consulted by the authorizer of every connection
+type statementPolicy struct {
+	mu     sync.RWMutex
+	config PolicyConfig
+	// databases flagged read-only
+	dbs map[string]bool
+}
+
+func (p *statementPolicy) configure(config PolicyConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+}
+
+func (p *statementPolicy) currentConfig() PolicyConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config
+}
+
+func (p *statementPolicy) setReadOnly(readOnly bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config.ReadOnly = readOnly
+}
+
+// flags dbName read-only, or writable again
+func (p *statementPolicy) set(dbName string, readOnly bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dbs == nil {
+		p.dbs = map[string]bool{}
+	}
+	if readOnly {
+		p.dbs[strings.ToLower(dbName)] = true
+	} else {
+		delete(p.dbs, strings.ToLower(dbName))
+	}
+}
+
+func (p *statementPolicy) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dbs = nil
+}
+
+// reports whether dbName itself is flagged read-only
+func (p *statementPolicy) flagged(dbName string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.dbs[strings.ToLower(dbName)]
+}
+
+func (p *statementPolicy) isReadOnly(dbName string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config.ReadOnly || p.dbs[strings.ToLower(dbName)]
+}
+
+func containsFold(list []string, name string) bool {
+	return slices.ContainsFunc(list, func(item string) bool { return strings.EqualFold(item, name) })
+}
+
+// returns the table of main an action changes, if any
+func changedMainTable(action int, arg1 string, arg2 string, dbName string) string {
+	switch action {
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE,
+		sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_DROP_TABLE,
+		sqlite3.SQLITE_CREATE_VTABLE, sqlite3.SQLITE_DROP_VTABLE:
+		if dbName == "main" {
+			return arg1
+		}
+	case sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_DROP_INDEX,
+		sqlite3.SQLITE_CREATE_TRIGGER, sqlite3.SQLITE_DROP_TRIGGER:
+		if dbName == "main" {
+			return arg2
+		}
+	case sqlite3.SQLITE_ALTER_TABLE:
+		if arg1 == "main" {
+			return arg2
+		}
+	case sqlite3.SQLITE_CREATE_TEMP_TRIGGER:
+		// a temp trigger may watch a table of main
+		return arg2
+	}
+	return ""
+}
+
+// returns the rule the action breaks, if any. trusted is set while the
+// connection runs one of the app's own statements.
+func (p *statementPolicy) check(action int, arg1 string, arg2 string, dbName string, trusted bool) *PolicyError {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	// the app's tables are changed by the app alone
+	if table := changedMainTable(action, arg1, arg2, dbName); !trusted && containsFold(SYSTEM_TABLES[:], table) {
+		return &PolicyError{Rule: RULE_APP_TABLE, DB: "main", Target: table}
+	}
+	switch action {
+	case sqlite3.SQLITE_ATTACH, sqlite3.SQLITE_DETACH:
+		if !p.config.AllowAttach && !trusted {
+			return &PolicyError{Rule: RULE_ATTACH, Target: arg1}
+		}
+		return nil
+	case sqlite3.SQLITE_FUNCTION:
+		if containsFold(p.config.DeniedFunctions, arg2) {
+			return &PolicyError{Rule: RULE_FUNCTION, Target: arg2}
+		}
+		return nil
+	case sqlite3.SQLITE_PRAGMA:
+		if arg2 == "" {
+			return nil
+		}
+		if containsFold(p.config.DeniedPragmas, arg1) {
+			return &PolicyError{Rule: RULE_PRAGMA, DB: dbName, Target: arg1}
+		}
+		if !containsFold(writePragmas, arg1) {
+			return nil
+		}
+		if dbName == "" {
+			// an unqualified pragma applies to main
+			dbName = "main"
+		}
+	case sqlite3.SQLITE_ALTER_TABLE:
+		// the database comes first for ALTER TABLE
+		dbName = arg1
+	default:
+		if !slices.Contains(writeActions, action) {
+			return nil
+		}
+	}
+	if dbName == "" || dbName == "temp" {
+		return nil
+	}
+	if p.dbs[strings.ToLower(dbName)] {
+		return &PolicyError{Rule: RULE_READ_ONLY, DB: dbName}
+	}
+	if p.config.ReadOnly {
+		// main holds the app's own tables and settings
+		appTable := action == sqlite3.SQLITE_PRAGMA || slices.Contains(SYSTEM_TABLES[:], arg1) || arg1 == "sqlite_master"
+		if dbName != "main" || !appTable {
+			return &PolicyError{Rule: RULE_READ_ONLY}
+		}
+	}
+	return nil
+}
+
+// the authorizer callback of a connection of the app. It runs on the
+// goroutine using the connection, so the violation is the connection's own.
+func (c *managedConn) authorize(action int, arg1 string, arg2 string, dbName string) int {
+	if c.schema != "" {
+		// main is a registered database here, not the app's own
+		if dbName == "main" || (dbName == "" && action == sqlite3.SQLITE_PRAGMA) {
+			dbName = c.schema
+		}
+		if action == sqlite3.SQLITE_ALTER_TABLE && arg1 == "main" {
+			arg1 = c.schema
+		}
+	}
+	violation := c.app.policy.check(action, arg1, arg2, dbName, c.trusted)
+	if violation != nil {
+		c.violation = violation
+		return sqlite3.SQLITE_DENY
+	}
+	if !c.trusted && changesConnection(action, arg1, arg2) {
+		c.changedConn = true
+		if c.classifying {
+			// some pragmas take effect as they compile
+			return sqlite3.SQLITE_DENY
+		}
+	}
+	return sqlite3.SQLITE_OK
+}
+
+// turns the authorizer's refusal of the statement the connection just
+// compiled into the PolicyError it recorded
+func (c *managedConn) explain(err error) error {
+	violation := c.violation
+	c.violation = nil
+	var sqliteErr sqlite3.Error
+	if err == nil || violation == nil || !errors.As(err, &sqliteErr) {
+		return err
+	}
+	// denied functions fail with a generic error
+	if sqliteErr.Code == sqlite3.ErrAuth || strings.HasPrefix(sqliteErr.Error(), "not authorized") {
+		return violation
+	}
+	return err
+}
+
+// turns SQLite's refusals into a PolicyError; the authorizer's come as one
+// already
+func (p *statementPolicy) explain(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrReadonly {
+		return &PolicyError{Rule: RULE_READ_ONLY}
+	}
+	return err
+}
+
+// opens SQLite connections that run every statement past the app's policy
+// and attach registered databases on demand
+type authorizedConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+	app    *App
+	// the registered database dsn opens as main, empty for the app's own
+	schema string
+}
+
+func (c *authorizedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	managed := &managedConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), app: c.app, schema: c.schema}
+	managed.RegisterAuthorizer(managed.authorize)
+	return managed, nil
+}
+
+func (c *authorizedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (a *App) openAuthorizedDB(dsn string) *sqlx.DB {
+	return a.openPolicedDB(dsn, "")
+}
+
+// opens dsn like openAuthorizedDB, with the rules of the registered database
+// schema applying to main
+func (a *App) openPolicedDB(dsn string, schema string) *sqlx.DB {
+	connector := &authorizedConnector{dsn: dsn, driver: &sqlite3.SQLiteDriver{}, app: a, schema: schema}
+	return sqlx.NewDb(sql.OpenDB(connector), SQLITE_DRIVER)
+}
+
+// runs one of the app's own statements: the ATTACH, DETACH and VACUUM INTO
+// the attach rule does not cover, and the writes to the app's tables
+func (a *App) execTrusted(query string, args ...any) (sql.Result, error) {
+	ctx := context.Background()
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setTrusted := func(trusted bool) error {
+		return withManagedConn(conn, func(c *managedConn) error {
+			c.trusted = trusted
+			return nil
+		})
+	}
+	if err := setTrusted(true); err != nil {
+		return nil, err
+	}
+	defer setTrusted(false)
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// one statement of a query as SQLite compiled it
+type StatementInfo struct {
+	SQL  string `json:"sql"`
+	Line int    `json:"line"`
+	// the statement changes neither a database nor the connection
+	Readonly bool `json:"readonly"`
+}
+
+// compiles every statement of query without running it. Statements that
+// break the policy return its PolicyError; those that would change the
+// connection are not compiled and are reported as writing. A statement that only compiles
+// once earlier ones have run, such as an INSERT into a table created before
+// it, ends the list and is reported as writing.
+func (a *App) classifyQuery(query string) ([]StatementInfo, error) {
+	conn, err := a.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var stmts []StatementInfo
+	err = conn.Raw(func(driverConn any) error {
+		sqliteConn, ok := driverConn.(*managedConn)
+		if !ok {
+			return fmt.Errorf("unexpected connection type %T", driverConn)
+		}
+		sqliteConn.classifying = true
+		defer func() { sqliteConn.classifying = false }()
+		scanner := newSQLScanner(strings.NewReader(query))
+		for {
+			next, err := scanner.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			info := StatementInfo{SQL: next.Text, Line: next.Line}
+			sqliteConn.changedConn = false
+			stmt, err := sqliteConn.Prepare(next.Text)
+			if err != nil && sqliteConn.changedConn {
+				// refused so compiling it leaves the connection as it was
+				stmts = append(stmts, info)
+				continue
+			}
+			if err != nil {
+				var policyErr *PolicyError
+				if explained := a.policy.explain(err); errors.As(explained, &policyErr) || len(stmts) == 0 {
+					return explained
+				}
+				stmts = append(stmts, info)
+				return nil
+			}
+			info.Readonly = stmt.(*sqlite3.SQLiteStmt).Readonly() && !sqliteConn.changedConn
+			stmt.Close()
+			stmts = append(stmts, info)
+		}
+	})
+	return stmts, err
+}
+
+// reports whether every statement of query only reads
+func readonlyStatements(stmts []StatementInfo) bool {
+	return len(stmts) > 0 && !slices.ContainsFunc(stmts, func(stmt StatementInfo) bool { return !stmt.Readonly })
+}
+
+// reports whether query is a single statement that only reads
+func (a *App) isReadOnlyQuery(query string) bool {
+	stmts, err := a.classifyQuery(query)
+	return err == nil && len(stmts) == 1 && stmts[0].Readonly
+}
+
+// returns a URI filename for the database at path, so characters such as ?
+// and # stay part of the name. query holds the URI parameters, if any.
+func fileURI(path string, query string) string {
+	p := filepath.ToSlash(path)
+	if !strings.HasPrefix(p, "/") {
+		// Windows drive paths
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p, RawQuery: query}).String()
+}
+
+// returns a URI filename that opens the database at path read-only
+func readOnlyURI(path string) string {
+	return fileURI(path, "mode=ro")
+}
+
+// returns the statement attaching the database file at path as name
+func attachStatement(path string, name string, readOnly bool) string {
+	if readOnly {
+		path = readOnlyURI(path)
+	}
+	return fmt.Sprintf("ATTACH %s AS %s;", sqlLiteral(path), quoteIdent(name))
+}
+
+// returns a read-only PolicyError when dbName, or a new database when dbName
+// is empty, must not be written
+func (a *App) checkWritable(dbName string) error {
+	if a.policy.flagged(dbName) {
+		return &PolicyError{Rule: RULE_READ_ONLY, DB: dbName}
+	}
+	if a.policy.isReadOnly(dbName) {
+		return &PolicyError{Rule: RULE_READ_ONLY}
+	}
+	return nil
+}
+
+// opens an attached database on its own connection for writing, refusing
+// read-only ones
+func (a *App) openWritableDB(dbName string) (*sqlx.DB, error) {
+	if err := a.checkWritable(dbName); err != nil {
+		return nil, err
+	}
+	return a.openAttachedDB(dbName)
+}
+
+// flags a stored database read-only, or writable again. Connections reattach
+// it so SQLite enforces the flag as well.
+func (a *App) SetDBReadOnly(req ReadOnlyRequest) AppResult {
+	if req.DB == "" || req.DB == "main" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	var path string
+	if err := a.db.Get(&path, "SELECT path FROM main.dbs WHERE name = ? AND root = ?;", req.DB, a.rootPath); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(fmt.Errorf("database %s is not stored: %w", req.DB, err), nil, nil)
+	}
+	a.registry.register(req.DB, path, req.ReadOnly)
+	a.policy.set(req.DB, req.ReadOnly)
+	if _, err := a.execTrusted("UPDATE main.dbs SET read_only = ? WHERE name = ? AND root = ?;", req.ReadOnly, req.DB, a.rootPath); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"db": req.DB, "readOnly": req.ReadOnly}, nil)
+}
+
+// returns the rules applied to every statement. They are set from
+// CustomAppConfig, the -read-only and -policy flags of the app or the CLI
+// only, so the frontend cannot loosen them. The per-database flags of
+// SetDBReadOnly are the user's own and not part of them.
+func (a *App) GetPolicy() AppResult {
+	return a.newResult(nil, a.policy.currentConfig(), nil)
+}
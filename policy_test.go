@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestPolicyReadOnly(t *testing.T) {
+	app := NewApp(&CustomAppConfig{Logger: NewSLogger()})
+	db := app.openAuthorizedDB(":memory:")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	app.db = db
+	db.MustExec(`CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT);`)
+	app.execTrusted(`CREATE TABLE dbs (name TEXT);`)
+	app.policy.set("main", true)
+
+	for _, stmt := range []string{
+		"INSERT INTO t (v) VALUES ('x')",
+		"UPDATE t SET v = 'y'",
+		"DELETE FROM t",
+		"CREATE TABLE u (id INTEGER)",
+		"DROP TABLE t",
+		"ALTER TABLE t ADD COLUMN w TEXT",
+		"CREATE INDEX t_v ON t(v)",
+		"PRAGMA user_version = 3",
+	} {
+		_, err := db.Exec(stmt)
+		var roErr *PolicyError
+		if !errors.As(app.policy.explain(err), &roErr) || roErr.DB != "main" {
+			t.Errorf("%s: got %v, want a read-only error for main", stmt, err)
+		}
+	}
+	for _, stmt := range []string{
+		"SELECT * FROM t",
+		"PRAGMA table_info('t')",
+		"PRAGMA user_version",
+		"CREATE TEMP TABLE scratch (id INTEGER)",
+		"INSERT INTO scratch VALUES (1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Errorf("%s: %v", stmt, err)
+		}
+	}
+
+	// the app's own tables stay writable to the app when everything is
+	// read-only, and to the app alone
+	app.policy.set("main", false)
+	app.policy.setReadOnly(true)
+	if _, err := app.execTrusted("INSERT INTO dbs VALUES ('a')"); err != nil {
+		t.Errorf("insert into an app table: %v", err)
+	}
+	var roErr *PolicyError
+	if _, err := db.Exec("INSERT INTO dbs VALUES ('b')"); !errors.As(err, &roErr) || roErr.Rule != RULE_APP_TABLE {
+		t.Errorf("user insert into an app table: %v", err)
+	}
+	_, err := db.Exec("INSERT INTO t (v) VALUES ('x')")
+	if !errors.As(app.policy.explain(err), &roErr) || roErr.DB != "" {
+		t.Errorf("global read-only insert: %v", err)
+	}
+}
+
+func TestReadOnlyURI(t *testing.T) {
+	tests := map[string]string{
+		"/data/my db.sqlite": "file:///data/my%20db.sqlite?mode=ro",
+		"/data/a?b#c.db":     "file:///data/a%3Fb%23c.db?mode=ro",
+	}
+	for path, want := range tests {
+		if got := readOnlyURI(path); got != want {
+			t.Errorf("readOnlyURI(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSetDBReadOnly(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"snapshot", "scratch"} {
+		db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, name+".db"))
+		db.MustExec(`CREATE TABLE items (id INTEGER PRIMARY KEY, sku TEXT);`)
+		db.Close()
+	}
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.policy.setReadOnly(false)
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+	if res := test_app.SetDBReadOnly(ReadOnlyRequest{DB: "snapshot", ReadOnly: true}); res.Err != nil {
+		t.Fatalf("SetDBReadOnly() error = %v", res.Err)
+	}
+
+	var roErr *PolicyError
+	if res := test_app.RemoveDB("snapshot"); !errors.As(res.Err, &roErr) {
+		t.Errorf("RemoveDB(snapshot) = %v, want it refused", res.Err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "snapshot.db")); err != nil {
+		t.Errorf("the read-only database file was removed: %v", err)
+	}
+	res := test_app.Query(QueryRequest{Query: "INSERT INTO snapshot.items (sku) VALUES ('a')"})
+	if !errors.As(res.Err, &roErr) || roErr.DB != "snapshot" {
+		t.Errorf("insert into snapshot: %v", res.Err)
+	}
+	if res := test_app.Query(QueryRequest{Query: "INSERT INTO scratch.items (sku) VALUES ('a')"}); res.Err != nil {
+		t.Errorf("insert into scratch: %v", res.Err)
+	}
+
+	csvPath := filepath.Join(t.TempDir(), "items.csv")
+	os.WriteFile(csvPath, []byte("sku\nb\n"), 0644)
+	res = test_app.ImportIntoTable(TableImportRequest{Path: csvPath, DB: "snapshot", Table: "items"})
+	if !errors.As(res.Err, &roErr) {
+		t.Errorf("import into snapshot: %v", res.Err)
+	}
+
+	// SQLite itself refuses writes on other connections to the snapshot
+	db, err := test_app.openAttachedDB("snapshot")
+	if err != nil {
+		t.Fatalf("openAttachedDB() error = %v", err)
+	}
+	_, err = db.Exec("INSERT INTO items (sku) VALUES ('c')")
+	db.Close()
+	if err == nil {
+		t.Error("insert through a separate connection succeeded")
+	}
+
+	var flagged bool
+	test_app.db.Get(&flagged, "SELECT read_only FROM main.dbs WHERE name = 'snapshot' AND root = ?;", root)
+	if !flagged {
+		t.Error("read_only not stored in main.dbs")
+	}
+
+	test_app.policy.setReadOnly(true)
+	res = test_app.Query(QueryRequest{Query: "INSERT INTO scratch.items (sku) VALUES ('d')"})
+	if !errors.As(res.Err, &roErr) || roErr.DB != "" {
+		t.Errorf("insert into scratch in read-only mode: %v", res.Err)
+	}
+	if res := test_app.SetCurrentDB("scratch"); res.Err != nil {
+		t.Errorf("SetCurrentDB() in read-only mode: %v", res.Err)
+	}
+	if res := test_app.RemoveDB("scratch"); !errors.As(res.Err, &roErr) {
+		t.Errorf("RemoveDB(scratch) in read-only mode = %v, want it refused", res.Err)
+	}
+	test_app.policy.setReadOnly(false)
+
+	if res := test_app.SetDBReadOnly(ReadOnlyRequest{DB: "snapshot", ReadOnly: false}); res.Err != nil {
+		t.Fatalf("SetDBReadOnly(false) error = %v", res.Err)
+	}
+	if res := test_app.Query(QueryRequest{Query: "INSERT INTO snapshot.items (sku) VALUES ('e')"}); res.Err != nil {
+		t.Errorf("insert into snapshot after clearing the flag: %v", res.Err)
+	}
+}
+
+func TestPolicyStatements(t *testing.T) {
+	prev := test_app.policy.currentConfig()
+	defer test_app.policy.configure(prev)
+
+	refused := map[string]PolicyRule{
+		"ATTACH ':memory:' AS evil":                                            RULE_ATTACH,
+		"/* hidden */ ATTACH ':memory:' AS evil":                               RULE_ATTACH,
+		"SELECT 1; ATTACH DATABASE ':memory:' AS evil":                         RULE_ATTACH,
+		"\n  attach database ':memory:'\n as evil":                             RULE_ATTACH,
+		"-- note\nDETACH DATABASE temp":                                        RULE_ATTACH,
+		"PRAGMA writable_schema = 1":                                           RULE_PRAGMA,
+		"SELECT load_extension('evil')":                                        RULE_FUNCTION,
+		"WITH x AS (SELECT 1) SELECT load_extension('e')":                      RULE_FUNCTION,
+		"UPDATE main.dbs SET read_only = 0":                                    RULE_APP_TABLE,
+		"DELETE FROM export_presets":                                           RULE_APP_TABLE,
+		"DROP TABLE current_db":                                                RULE_APP_TABLE,
+		"ALTER TABLE dbs RENAME TO mine":                                       RULE_APP_TABLE,
+		"CREATE INDEX dbs_path ON dbs(path)":                                   RULE_APP_TABLE,
+		"CREATE TEMP TRIGGER spy AFTER INSERT ON main.dbs BEGIN SELECT 1; END": RULE_APP_TABLE,
+	}
+	for query, rule := range refused {
+		res := test_app.Query(QueryRequest{Query: query})
+		var policyErr *PolicyError
+		if !errors.As(res.Err, &policyErr) || policyErr.Rule != rule {
+			t.Errorf("Query(%q) error = %v, want the %s rule", query, res.Err, rule)
+		}
+	}
+
+	for _, query := range []string{
+		"SELECT 'ATTACHMENT' AS name",
+		"WITH x(n) AS (VALUES (1), (2)) SELECT n FROM x",
+		"VALUES (1), (2)",
+		"PRAGMA writable_schema",
+	} {
+		res := test_app.Query(QueryRequest{Query: query})
+		if res.Err != nil {
+			t.Errorf("Query(%q) error = %v", query, res.Err)
+			continue
+		}
+		if _, ok := res.Results.(map[string]any)["cols"]; !ok {
+			t.Errorf("Query(%q) did not return rows: %v", query, res.Results)
+		}
+	}
+
+	config := DefaultPolicyConfig()
+	config.AllowAttach = true
+	test_app.policy.configure(config)
+	if res := test_app.Query(QueryRequest{Query: "ATTACH ':memory:' AS allowed"}); res.Err != nil {
+		t.Fatalf("ATTACH with AllowAttach: %v", res.Err)
+	}
+	if res := test_app.Query(QueryRequest{Query: "DETACH DATABASE allowed"}); res.Err != nil {
+		t.Errorf("DETACH with AllowAttach: %v", res.Err)
+	}
+}
+
+func TestPolicyPerConnection(t *testing.T) {
+	app := NewApp(&CustomAppConfig{Logger: NewSLogger()})
+	db := app.openAuthorizedDB(":memory:")
+	defer db.Close()
+	ctx := context.Background()
+	internal, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer internal.Close()
+	user, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer user.Close()
+
+	// an internal statement in flight on one connection trusts no other
+	withManagedConn(internal, func(c *managedConn) error {
+		c.trusted = true
+		return nil
+	})
+	var policyErr *PolicyError
+	if _, err := user.ExecContext(ctx, "ATTACH ':memory:' AS evil"); !errors.As(err, &policyErr) || policyErr.Rule != RULE_ATTACH {
+		t.Errorf("ATTACH beside a trusted connection: %v, want the attach rule", err)
+	}
+	if _, err := internal.ExecContext(ctx, "ATTACH ':memory:' AS own"); err != nil {
+		t.Errorf("ATTACH on the trusted connection: %v", err)
+	}
+
+	// each connection explains its own refusal
+	_, userErr := user.ExecContext(ctx, "PRAGMA writable_schema = 1")
+	_, internalErr := internal.ExecContext(ctx, "SELECT load_extension('evil')")
+	if !errors.As(userErr, &policyErr) || policyErr.Rule != RULE_PRAGMA {
+		t.Errorf("denied pragma: %v", userErr)
+	}
+	if !errors.As(internalErr, &policyErr) || policyErr.Rule != RULE_FUNCTION {
+		t.Errorf("denied function: %v", internalErr)
+	}
+}
+
+func TestClassifyQuery(t *testing.T) {
+	stmts, err := test_app.classifyQuery("SELECT 1; CREATE TEMP TABLE c (id INTEGER); INSERT INTO c VALUES (1)")
+	if err != nil {
+		t.Fatalf("classifyQuery() error = %v", err)
+	}
+	// the INSERT cannot compile before the table exists, so it ends the list
+	if len(stmts) != 3 || !stmts[0].Readonly || stmts[1].Readonly || stmts[2].Readonly {
+		t.Errorf("classifyQuery() = %+v", stmts)
+	}
+	if readonlyStatements(stmts) {
+		t.Error("readonlyStatements() = true for a query that writes")
+	}
+}
+
+func TestUploadReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "upload_ro.csv")
+	os.WriteFile(csvPath, []byte("a,b\n1,2\n"), SafePermissions)
+	var failed []any
+	prevEvent := test_app.onEvent
+	test_app.onEvent = func(emitType WailsEmitType, data any) {
+		if emitType == DB_UPLOAD_FAIL {
+			failed = append(failed, data)
+		}
+	}
+	test_app.policy.setReadOnly(true)
+	defer func() {
+		test_app.policy.setReadOnly(false)
+		test_app.onEvent = prevEvent
+	}()
+
+	dialog := test_app.dialog.(*MockDialogService)
+	dialog.QueueFileResult(csvPath, nil)
+	test_app.uploadDB()
+	if len(failed) != 1 {
+		t.Errorf("upload while read-only: failures %v", failed)
+	}
+	if len(dialog.FileResults) != 1 {
+		t.Error("the file dialog opened while read-only")
+	}
+	dialog.FileResults = nil
+	if _, ok := test_app.registry.lookup("upload_ro"); ok {
+		t.Error("a database was stored while read-only")
+	}
+	if _, err := os.Stat(test_app.getNewDBPath("upload_ro")); !os.IsNotExist(err) {
+		t.Error("a database file was created while read-only")
+	}
+}
+
+func TestLoadPolicyConfig(t *testing.T) {
+	if config, err := loadPolicyConfig(""); config != nil || err != nil {
+		t.Errorf("loadPolicyConfig(\"\") = %v, %v", config, err)
+	}
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"readOnly": true, "deniedPragmas": ["journal_mode"]}`), 0644)
+	config, err := loadPolicyConfig(path)
+	if err != nil {
+		t.Fatalf("loadPolicyConfig() error = %v", err)
+	}
+	if !config.ReadOnly || config.AllowAttach || len(config.DeniedPragmas) != 1 || len(config.DeniedFunctions) != 1 {
+		t.Errorf("loadPolicyConfig() = %+v", config)
+	}
+	app := NewApp(&CustomAppConfig{Logger: NewSLogger(), Policy: config})
+	if !app.policy.isReadOnly("shop") {
+		t.Error("the app ignored the configured read-only mode")
+	}
+	os.WriteFile(path, []byte(`{"readOnly": "yes"}`), 0644)
+	if _, err := loadPolicyConfig(path); err == nil {
+		t.Error("loadPolicyConfig() accepted a malformed file")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
 )
 
 func (a *App) handleSelectQueries(query string, editable bool) AppResult {
@@ -13,7 +12,7 @@ func (a *App) handleSelectQueries(query string, editable bool) AppResult {
 	if err != nil {
 		a.logger.Error("failed to run query: %s", slog.Any("error", err.Error()))
 		return a.newResult(
-			fmt.Errorf("failed to run query: %s | %s", query, err.Error()),
+			fmt.Errorf("failed to run query: %s | %w", query, a.policy.explain(err)),
 			map[string]any{
 				"error": BadRequestError,
 			},
@@ -67,23 +66,25 @@ func (a *App) Query(q QueryRequest) AppResult {
 		)
 	}
 	q.Query = cleanQuery(q.Query)
-	if !a.unlocked && containsAttachStatement(q.Query) {
+	stmts, err := a.classifyQuery(q.Query)
+	if err != nil {
+		a.logger.Error("query refused", slog.Any("error", err))
 		return a.newResult(
-			errors.New(BadRequestError),
+			err,
 			map[string]any{
 				"error": BadRequestError,
 			},
 			nil,
 		)
 	}
-	if strings.HasPrefix(strings.ToUpper(q.Query), "SELECT") || strings.HasPrefix(strings.ToUpper(q.Query), "PRAGMA") {
+	if readonlyStatements(stmts) {
 		return a.handleSelectQueries(q.Query, q.Editable)
 	} else {
 		result, err := a.db.Exec(q.Query)
 		if err != nil {
 			a.logger.Error("query failed to execute", slog.Any("error", err))
 			return a.newResult(
-				err,
+				a.policy.explain(err),
 				map[string]any{
 					"error": BadRequestError,
 				},
@@ -117,11 +118,6 @@ func (a *App) QueryAll(table string) AppResult {
 		a.logger.Error(err.Error())
 		return a.newResult(err, nil, nil)
 	}
-	var query string
-	if dbName != "" {
-		query = fmt.Sprintf("SELECT * FROM %s.%s LIMIT 50;", dbName, table)
-	} else {
-		query = fmt.Sprintf("SELECT * FROM %s LIMIT 50;", table)
-	}
+	query := fmt.Sprintf("SELECT * FROM %s LIMIT 50;", quoteQualified(dbName, table))
 	return a.handleSelectQueries(query, true)
 }
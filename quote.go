@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Every name or value spliced into SQL text goes through this file. Values
+// should be bound as parameters where SQLite allows it; these helpers cover
+// the places it does not, such as identifiers, ATTACH targets and scripts.
+
+// wraps an identifier in double quotes, escaping any embedded quotes
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quotes each of names with quoteIdent
+func quoteIdents(names []string) []string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteIdent(name)
+	}
+	return quoted
+}
+
+// quotes name qualified by the schema it lives in; an empty schema leaves
+// the name unqualified
+func quoteQualified(schema string, name string) string {
+	if schema == "" {
+		return quoteIdent(name)
+	}
+	return quoteIdent(schema) + "." + quoteIdent(name)
+}
+
+// renders a scanned SQLite value as an SQL literal
+func sqlLiteral(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return fmt.Sprintf("X'%X'", v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int, int8, int16, int32, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return sqlLiteral(float64(v))
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "1e999"
+		case math.IsInf(v, -1):
+			return "-1e999"
+		case math.IsNaN(v):
+			return "NULL"
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case time.Time:
+		return "'" + v.Format(sqlite3.SQLiteTimestampFormats[0]) + "'"
+	default:
+		return sqlLiteral(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// names that break SQL built by pasting them in unquoted
+var hostileNames = []string{
+	`it's`,
+	`say "hi"`,
+	`two words`,
+	`naïve 表`,
+	`select`,
+	`a.b`,
+	`x"; DROP TABLE t; --`,
+}
+
+func TestQuoteHelpers(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{quoteIdent(`say "hi"`), `"say ""hi"""`},
+		{quoteIdent(""), `""`},
+		{quoteQualified("", "t"), `"t"`},
+		{quoteQualified("my db", "a.b"), `"my db"."a.b"`},
+		{sqlLiteral("it's"), `'it''s'`},
+		{sqlLiteral([]byte{0xca, 0xfe}), `X'CAFE'`},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s, want %s", tt.got, tt.want)
+		}
+	}
+
+	db := sqlx.MustOpen(SQLITE_DRIVER, ":memory:")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	for _, name := range hostileNames {
+		db.MustExec("CREATE TABLE " + quoteIdent(name) + " (" + quoteIdent(name) + " TEXT);")
+		db.MustExec("INSERT INTO " + quoteQualified("main", name) + " VALUES (" + sqlLiteral(name) + ");")
+		var got string
+		if err := db.Get(&got, "SELECT "+quoteIdent(name)+" FROM "+quoteQualified("main", name)+";"); err != nil || got != name {
+			t.Errorf("round trip of %q: got %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestHostileNames(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "O'Brien's drive")
+	dbPath := filepath.Join(root, "it's mine.db")
+	if err := os.MkdirAll(root, SafePermissions); err != nil {
+		t.Fatal(err)
+	}
+	db := sqlx.MustOpen(SQLITE_DRIVER, dbPath)
+	for _, name := range hostileNames {
+		db.MustExec("CREATE TABLE " + quoteIdent(name) + " (id INTEGER PRIMARY KEY, " + quoteIdent(name) + " TEXT);")
+		db.MustExec("INSERT INTO " + quoteIdent(name) + " VALUES (1, 'before');")
+	}
+	db.Close()
+
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+	dbName, err := test_app.getSQLiteDBName(dbPath)
+	if err != nil {
+		t.Fatalf("getSQLiteDBName() error = %v", err)
+	}
+	tables, err := test_app.getTableList(dbName)
+	if err != nil || len(tables) != len(hostileNames) {
+		t.Fatalf("getTableList() = %v, %v", tables, err)
+	}
+
+	for _, name := range hostileNames {
+		cols, err := test_app.getColumns(dbName, name)
+		if err != nil || !slices.Equal(cols, []string{"id", name}) {
+			t.Errorf("getColumns(%q) = %v, %v", name, cols, err)
+		}
+		res := test_app.UpdateDB(UpdateRequest{DB: dbName, Table: name, Row: [][]any{{"id", name}, {1, "before"}}, Column: name, Value: `'); DROP TABLE t; --`})
+		if res.Err != nil {
+			t.Errorf("UpdateDB(%q) error = %v", name, res.Err)
+			continue
+		}
+		data, err := test_app.getTableData(dbName, name)
+		if err != nil || len(data) != 1 || data[0][name] != `'); DROP TABLE t; --` {
+			t.Errorf("getTableData(%q) = %v, %v", name, data, err)
+		}
+	}
+
+	// a read-only attachment goes through a file: URI
+	if res := test_app.SetDBReadOnly(ReadOnlyRequest{DB: dbName, ReadOnly: true}); res.Err != nil {
+		t.Fatalf("SetDBReadOnly() error = %v", res.Err)
+	}
+	if _, err := test_app.getSQLiteDBName(dbPath); err != nil {
+		t.Errorf("read-only attachment not found: %v", err)
+	}
+}
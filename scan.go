@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// the first 16 bytes of every SQLite database file
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// ScanConfig decides which folders below the root are looked in and which of
+// their files are stored as databases.
+type ScanConfig struct {
+	// levels of folders scanned, the root being the first; 0 means no limit
+	MaxDepth int `json:"maxDepth"`
+	// when set, only files matching one of these globs are stored
+	Include []string `json:"include"`
+	// globs of files and folders to skip. Patterns with a slash match the path
+	// relative to the root, the others match the base name.
+	Exclude []string `json:"exclude"`
+	// descend into symlinked folders and store symlinked files
+	FollowSymlinks bool `json:"followSymlinks"`
+	// extensions of the files stored as databases
+	Extensions []string `json:"extensions"`
+	// also store files of other extensions that start with the SQLite header
+	DetectHeader bool `json:"detectHeader"`
+}
+
+func DefaultScanConfig() ScanConfig {
+	return ScanConfig{
+		Exclude:      []string{"node_modules", ".git"},
+		Extensions:   slices.Clone(dbFileTypes[:]),
+		DetectHeader: true,
+	}
+}
+
+// reports whether the entry at rel, a slash separated path relative to the
+// root, is scanned
+func (c ScanConfig) accepts(rel string, isDir bool) bool {
+	if matchGlobs(c.Exclude, rel) {
+		return false
+	}
+	depth := strings.Count(rel, "/") + 1
+	if isDir {
+		// the folder's files sit one level deeper
+		return c.MaxDepth == 0 || depth < c.MaxDepth
+	}
+	if c.MaxDepth != 0 && depth > c.MaxDepth {
+		return false
+	}
+	return len(c.Include) == 0 || matchGlobs(c.Include, rel)
+}
+
+// reports whether the file at path is a database, by its extension or header
+func (c ScanConfig) isDBFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != "" && slices.ContainsFunc(c.Extensions, func(e string) bool {
+		return strings.EqualFold(e, ext)
+	}) {
+		return true
+	}
+	return c.DetectHeader && hasSQLiteHeader(path)
+}
+
+// reports whether rel or one of the folders it lies in matches a pattern
+func matchGlobs(patterns []string, rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pattern := range patterns {
+			target := part
+			if strings.Contains(pattern, "/") {
+				target = prefix
+			}
+			if ok, _ := path.Match(pattern, target); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasSQLiteHeader(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, sqliteHeader)
+}
+
+// the scan settings, read by the folder watcher as they change
+type scanSettings struct {
+	mu     sync.RWMutex
+	config ScanConfig
+}
+
+func (s *scanSettings) configure(config ScanConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+func (s *scanSettings) current() ScanConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// the folder the paths of the scan of dir are relative to: the root when dir
+// lies inside it, dir itself otherwise
+func (a *App) scanRoot(dir string) string {
+	if rel, err := filepath.Rel(a.rootPath, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return a.rootPath
+	}
+	return dir
+}
+
+// reports whether the entry at path is scanned, and whether it is a folder.
+// Symlinks are resolved when followed.
+func (a *App) scanEntry(root string, path string) (isDir bool, ok bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, false
+	}
+	config := a.scan.current()
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !config.FollowSymlinks {
+			return false, false
+		}
+		if info, err = os.Stat(path); err != nil {
+			return false, false
+		}
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false, false
+	}
+	return info.IsDir(), config.accepts(filepath.ToSlash(rel), info.IsDir())
+}
+
+// calls visit with dir and every folder and file below it the scan settings
+// let in. Folders that cannot be read are logged and skipped; the error is
+// returned only when dir itself cannot be.
+func (a *App) scanFolder(dir string, visit func(path string, isDir bool)) error {
+	root := a.scanRoot(dir)
+	// real paths of the folders scanned, so symlinks cannot loop
+	seen := map[string]bool{}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			if seen[real] {
+				return nil
+			}
+			seen[real] = true
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		visit(dir, true)
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			isDir, ok := a.scanEntry(root, path)
+			switch {
+			case !ok:
+			case isDir:
+				if err := walk(path); err != nil {
+					a.logger.Error(fmt.Sprintf("Error accessing path %q: %v", path, err))
+				}
+			default:
+				visit(path, false)
+			}
+		}
+		return nil
+	}
+	return walk(dir)
+}
+
+func (a *App) GetScanConfig() AppResult {
+	return a.newResult(nil, a.scan.current(), nil)
+}
+
+// replaces the scan settings; they apply from the next scan of the root
+func (a *App) SetScanConfig(config ScanConfig) AppResult {
+	a.scan.configure(config)
+	return a.newResult(nil, config, nil)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanConfig(t *testing.T) {
+	config := ScanConfig{
+		MaxDepth: 2,
+		Include:  []string{"*.db", "data/*"},
+		Exclude:  []string{"node_modules", "tmp/*.db"},
+	}
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"a.db", false, true},
+		{"a.sqlite", false, false},
+		{"data/blob", false, true},
+		{"sub", true, true},
+		{"sub/b.db", false, true},
+		{"sub/deep", true, false},
+		{"sub/deep/c.db", false, false},
+		{"node_modules", true, false},
+		{"node_modules/x.db", false, false},
+		{"tmp/scratch.db", false, false},
+	}
+	for _, tt := range tests {
+		if got := config.accepts(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("accepts(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+	if (ScanConfig{}).accepts("a/b/c/d/e.txt", false) != true {
+		t.Error("the zero config does not accept everything")
+	}
+}
+
+func TestIsDBFile(t *testing.T) {
+	dir := t.TempDir()
+	newWatchTestDB(t, filepath.Join(dir, "noext"))
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("SQLite format 3"), SafePermissions)
+	os.WriteFile(filepath.Join(dir, "tiles.MBTILES"), nil, SafePermissions)
+
+	config := DefaultScanConfig()
+	for name, want := range map[string]bool{"noext": true, "notes.txt": false, "tiles.MBTILES": true, "absent.gpkg": true} {
+		if got := config.isDBFile(filepath.Join(dir, name)); got != want {
+			t.Errorf("isDBFile(%s) = %v, want %v", name, got, want)
+		}
+	}
+	config.DetectHeader = false
+	if config.isDBFile(filepath.Join(dir, "noext")) {
+		t.Error("isDBFile(noext) = true without header detection")
+	}
+}
+
+func TestScanFolder(t *testing.T) {
+	root := t.TempDir()
+	elsewhere := t.TempDir()
+	for _, dir := range []string{"sub/deep", "node_modules/pkg", "locked"} {
+		os.MkdirAll(filepath.Join(root, dir), SafePermissions)
+	}
+	for _, file := range []string{"top.db", "sub/second.sqlite3", "sub/deep/third.db", "node_modules/pkg/fixture.db", "locked/hidden.db", "headless"} {
+		newWatchTestDB(t, filepath.Join(root, file))
+	}
+	newWatchTestDB(t, filepath.Join(elsewhere, "linked.db"))
+	os.WriteFile(filepath.Join(root, "readme.txt"), []byte("not a database"), SafePermissions)
+	os.Symlink(elsewhere, filepath.Join(root, "sub", "link"))
+	// a loop back to the root
+	os.Symlink(root, filepath.Join(root, "sub", "loop"))
+	os.Chmod(filepath.Join(root, "locked"), 0)
+	defer os.Chmod(filepath.Join(root, "locked"), SafePermissions)
+	_, err := os.ReadDir(filepath.Join(root, "locked"))
+	lockable := err != nil
+
+	prevRoot, prevScan := test_app.rootPath, test_app.scan.current()
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+		test_app.scan.configure(prevScan)
+	}()
+	scan := func(config ScanConfig) []string {
+		t.Helper()
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.scan.configure(config)
+		if err := test_app.attachDBsFromFolder(root); err != nil {
+			t.Fatalf("attachDBsFromFolder() error = %v", err)
+		}
+		var stored []string
+		test_app.db.Select(&stored, "SELECT name FROM main.dbs WHERE root = ? ORDER BY name;", root)
+		return stored
+	}
+
+	want := []string{"headless", "second", "third", "top"}
+	if !lockable {
+		// running as root, the locked folder is readable anyway
+		want = []string{"headless", "hidden", "second", "third", "top"}
+	}
+	if got := scan(DefaultScanConfig()); !reflect.DeepEqual(got, want) {
+		t.Errorf("default scan stored %v, want %v", got, want)
+	}
+
+	config := DefaultScanConfig()
+	config.FollowSymlinks = true
+	config.MaxDepth = 3
+	config.Exclude = append(config.Exclude, "locked")
+	if got, want := scan(config), []string{"headless", "linked", "second", "third", "top"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scan following symlinks stored %v, want %v", got, want)
+	}
+	if db, ok := test_app.registry.lookup("linked"); !ok || db.Path != filepath.Join(root, "sub", "link", "linked.db") {
+		t.Errorf("linked registered as %+v", db)
+	}
+
+	config = DefaultScanConfig()
+	config.MaxDepth = 2
+	config.Exclude = []string{"locked"}
+	config.Include = []string{"*.db", "*.sqlite3"}
+	config.DetectHeader = false
+	want = []string{"second", "top"}
+	if got := scan(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("shallow scan stored %v, want %v", got, want)
+	}
+}
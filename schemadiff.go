@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sqlitegui/models"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SchemaObject struct {
+	Type    string              `db:"type" json:"type"`
+	Name    string              `db:"name" json:"name"`
+	Table   string              `db:"tbl_name" json:"table"`
+	SQL     string              `db:"sql" json:"sql"`
+	Columns []models.ColumnInfo `db:"-" json:"columns,omitempty"`
+}
+
+// SchemaSnapshot is the schema of a single database, in sqlite_master order.
+// It is also the format written by SaveSchemaSnapshot.
+type SchemaSnapshot struct {
+	Name    string         `json:"name"`
+	Objects []SchemaObject `json:"objects"`
+}
+
+type ColumnChange struct {
+	Name   string             `json:"name"`
+	Before *models.ColumnInfo `json:"before,omitempty"`
+	After  *models.ColumnInfo `json:"after,omitempty"`
+}
+
+type ObjectChange struct {
+	Type           string         `json:"type"`
+	Name           string         `json:"name"`
+	Before         string         `json:"before"`
+	After          string         `json:"after"`
+	AddedColumns   []string       `json:"addedColumns,omitempty"`
+	RemovedColumns []string       `json:"removedColumns,omitempty"`
+	ChangedColumns []ColumnChange `json:"changedColumns,omitempty"`
+}
+
+// SchemaDiff describes what has to change to bring From to To. Migration
+// holds an ordered SQL script that performs the change when run against From.
+type SchemaDiff struct {
+	From      string         `json:"from"`
+	To        string         `json:"to"`
+	Added     []SchemaObject `json:"added"`
+	Removed   []SchemaObject `json:"removed"`
+	Changed   []ObjectChange `json:"changed"`
+	Migration string         `json:"migration"`
+}
+
+func (d SchemaDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (s SchemaSnapshot) get(objType string, name string) (SchemaObject, bool) {
+	for _, obj := range s.Objects {
+		if obj.Type == objType && strings.EqualFold(obj.Name, name) {
+			return obj, true
+		}
+	}
+	return SchemaObject{}, false
+}
+
+// reads every table, index, view and trigger of an attached database
+func loadSchema(q sqlx.Queryer, dbName string) (SchemaSnapshot, error) {
+	snapshot := SchemaSnapshot{Name: dbName}
+	query := fmt.Sprintf(
+		`SELECT type, name, tbl_name, sql FROM %s.sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\_%%' ESCAPE '\' ORDER BY rowid;`,
+		quoteIdent(dbName),
+	)
+	var objects []SchemaObject
+	if err := sqlx.Select(q, &objects, query); err != nil {
+		return snapshot, err
+	}
+	for _, obj := range objects {
+		if dbName == "main" && slices.Contains(SYSTEM_TABLES[:], obj.Table) {
+			continue
+		}
+		if obj.Type == "table" {
+			if err := sqlx.Select(
+				q,
+				&obj.Columns,
+				fmt.Sprintf(`SELECT cid, name, type, "notnull", dflt_value, pk, hidden FROM %s;`, schemaPragma(dbName, "pragma_table_xinfo")),
+				obj.Name,
+			); err != nil {
+				return snapshot, err
+			}
+		}
+		snapshot.Objects = append(snapshot.Objects, obj)
+	}
+	return snapshot, nil
+}
+
+func readSchemaSnapshot(path string) (SchemaSnapshot, error) {
+	var snapshot SchemaSnapshot
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		return snapshot, fmt.Errorf("invalid schema snapshot %s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// returns the part of a CREATE TABLE statement that follows the table name
+func tableBody(createSQL string) string {
+	i := indexTopLevel(createSQL, '(')
+	if i < 0 {
+		return createSQL
+	}
+	return createSQL[i:]
+}
+
+func schemaObjectChanged(before SchemaObject, after SchemaObject) bool {
+	if before.Type == "table" {
+		return normalizeSQL(tableBody(before.SQL)) != normalizeSQL(tableBody(after.SQL))
+	}
+	return normalizeSQL(before.SQL) != normalizeSQL(after.SQL)
+}
+
+func diffColumns(change *ObjectChange, before []models.ColumnInfo, after []models.ColumnInfo) {
+	findColumn := func(cols []models.ColumnInfo, name string) *models.ColumnInfo {
+		for i := range cols {
+			if strings.EqualFold(cols[i].Name, name) {
+				return &cols[i]
+			}
+		}
+		return nil
+	}
+	for i := range after {
+		old := findColumn(before, after[i].Name)
+		if old == nil {
+			change.AddedColumns = append(change.AddedColumns, after[i].Name)
+			continue
+		}
+		oldDefault, newDefault := "", ""
+		if old.DFLT_value != nil {
+			oldDefault = *old.DFLT_value
+		}
+		if after[i].DFLT_value != nil {
+			newDefault = *after[i].DFLT_value
+		}
+		if !strings.EqualFold(old.Type, after[i].Type) ||
+			old.NotNull != after[i].NotNull ||
+			old.PK != after[i].PK ||
+			old.Hidden != after[i].Hidden ||
+			(old.DFLT_value == nil) != (after[i].DFLT_value == nil) ||
+			oldDefault != newDefault {
+			change.ChangedColumns = append(change.ChangedColumns, ColumnChange{
+				Name:   after[i].Name,
+				Before: old,
+				After:  &after[i],
+			})
+		}
+	}
+	for _, col := range before {
+		if findColumn(after, col.Name) == nil {
+			change.RemovedColumns = append(change.RemovedColumns, col.Name)
+		}
+	}
+}
+
+func diffSchemas(from SchemaSnapshot, to SchemaSnapshot) SchemaDiff {
+	diff := SchemaDiff{From: from.Name, To: to.Name}
+	for _, obj := range to.Objects {
+		old, ok := from.get(obj.Type, obj.Name)
+		if !ok {
+			diff.Added = append(diff.Added, obj)
+			continue
+		}
+		if !schemaObjectChanged(old, obj) {
+			continue
+		}
+		change := ObjectChange{Type: obj.Type, Name: obj.Name, Before: old.SQL, After: obj.SQL}
+		if obj.Type == "table" {
+			diffColumns(&change, old.Columns, obj.Columns)
+		}
+		diff.Changed = append(diff.Changed, change)
+	}
+	for _, obj := range from.Objects {
+		if _, ok := to.get(obj.Type, obj.Name); !ok {
+			diff.Removed = append(diff.Removed, obj)
+		}
+	}
+	diff.Migration = buildMigration(from, to, diff)
+	return diff
+}
+
+// returns the column definitions to ADD when after only appends plain columns
+// to before; false means the table has to be rebuilt instead
+func appendedColumnDefs(before SchemaObject, after SchemaObject) ([]string, bool) {
+	oldBody := normalizeSQL(tableBody(before.SQL))
+	newBody := normalizeSQL(tableBody(after.SQL))
+	if !strings.HasSuffix(oldBody, ")") || !strings.HasSuffix(newBody, ")") {
+		return nil, false
+	}
+	prefix := strings.TrimSuffix(oldBody, ")")
+	if !strings.HasPrefix(newBody, prefix+",") {
+		return nil, false
+	}
+	defs := splitTopLevel(strings.TrimSuffix(newBody[len(prefix)+1:], ")"), ',')
+	for _, def := range defs {
+		upper := strings.ToUpper(def)
+		for _, keyword := range []string{"CONSTRAINT ", "CHECK(", "CHECK ", "FOREIGN ", "PRIMARY ", "UNIQUE("} {
+			if strings.HasPrefix(upper, keyword) {
+				return nil, false
+			}
+		}
+		for _, keyword := range []string{"PRIMARY KEY", "UNIQUE", " STORED", "DEFAULT(", "DEFAULT CURRENT_"} {
+			if strings.Contains(upper, keyword) {
+				return nil, false
+			}
+		}
+		if strings.Contains(upper, "NOT NULL") && !strings.Contains(upper, "DEFAULT") {
+			return nil, false
+		}
+	}
+	return defs, true
+}
+
+func rebuildTableStatements(before SchemaObject, after SchemaObject) []string {
+	tmpName := "_new_" + after.Name
+	var common []string
+	for _, col := range after.Columns {
+		if col.Hidden != 0 {
+			continue
+		}
+		for _, old := range before.Columns {
+			if old.Hidden == 0 && strings.EqualFold(old.Name, col.Name) {
+				common = append(common, quoteIdent(col.Name))
+				break
+			}
+		}
+	}
+	stmts := []string{fmt.Sprintf("CREATE TABLE %s %s;", quoteIdent(tmpName), tableBody(after.SQL))}
+	if len(common) > 0 {
+		cols := strings.Join(common, ", ")
+		stmts = append(stmts, fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s;",
+			quoteIdent(tmpName), cols, cols, quoteIdent(before.Name),
+		))
+	}
+	return append(stmts,
+		fmt.Sprintf("DROP TABLE %s;", quoteIdent(before.Name)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", quoteIdent(tmpName), quoteIdent(after.Name)),
+	)
+}
+
+// builds the script that turns from into to. Tables whose change cannot be
+// expressed with ALTER TABLE ADD COLUMN are rebuilt following the steps in
+// https://www.sqlite.org/lang_altertable.html#otheralter. Whenever a table is
+// rebuilt or dropped every view and trigger is recreated, since SQLite
+// refuses to rename tables while dependent objects are broken.
+func buildMigration(from SchemaSnapshot, to SchemaSnapshot, diff SchemaDiff) string {
+	if diff.Empty() {
+		return ""
+	}
+	dropped := make(map[string]bool)
+	for _, obj := range diff.Removed {
+		dropped[obj.Type+":"+strings.ToLower(obj.Name)] = true
+	}
+	changed := make(map[string]bool)
+	for _, change := range diff.Changed {
+		changed[change.Type+":"+strings.ToLower(change.Name)] = true
+	}
+	key := func(obj SchemaObject) string {
+		return obj.Type + ":" + strings.ToLower(obj.Name)
+	}
+
+	alters := make(map[string][]string)
+	rebuilt := make(map[string]bool)
+	structural := false
+	for _, obj := range diff.Removed {
+		if obj.Type == "table" {
+			structural = true
+		}
+	}
+	for _, obj := range to.Objects {
+		if obj.Type != "table" || !changed[key(obj)] {
+			continue
+		}
+		old, _ := from.get(obj.Type, obj.Name)
+		if defs, ok := appendedColumnDefs(old, obj); ok {
+			alters[strings.ToLower(obj.Name)] = defs
+		} else {
+			rebuilt[strings.ToLower(obj.Name)] = true
+			structural = true
+		}
+	}
+
+	stmts := []string{"PRAGMA foreign_keys=OFF;", "BEGIN TRANSACTION;"}
+	for _, objType := range []string{"trigger", "view", "index"} {
+		for _, obj := range from.Objects {
+			if obj.Type != objType {
+				continue
+			}
+			if dropped[key(obj)] || changed[key(obj)] || (structural && objType != "index") {
+				stmts = append(stmts, fmt.Sprintf("DROP %s IF EXISTS %s;", strings.ToUpper(objType), quoteIdent(obj.Name)))
+			}
+		}
+	}
+	for _, obj := range diff.Removed {
+		if obj.Type == "table" {
+			stmts = append(stmts, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quoteIdent(obj.Name)))
+		}
+	}
+	for _, obj := range to.Objects {
+		if obj.Type != "table" {
+			continue
+		}
+		name := strings.ToLower(obj.Name)
+		if _, ok := from.get(obj.Type, obj.Name); !ok {
+			stmts = append(stmts, strings.TrimSuffix(obj.SQL, ";")+";")
+		} else if defs, ok := alters[name]; ok {
+			for _, def := range defs {
+				stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", quoteIdent(obj.Name), def))
+			}
+		} else if rebuilt[name] {
+			old, _ := from.get(obj.Type, obj.Name)
+			stmts = append(stmts, rebuildTableStatements(old, obj)...)
+		}
+	}
+	for _, objType := range []string{"index", "view", "trigger"} {
+		for _, obj := range to.Objects {
+			if obj.Type != objType {
+				continue
+			}
+			_, existed := from.get(obj.Type, obj.Name)
+			if !existed || changed[key(obj)] ||
+				(objType == "index" && rebuilt[strings.ToLower(obj.Table)]) ||
+				(structural && objType != "index") {
+				stmts = append(stmts, strings.TrimSuffix(obj.SQL, ";")+";")
+			}
+		}
+	}
+	stmts = append(stmts, "PRAGMA foreign_key_check;", "COMMIT;", "PRAGMA foreign_keys=ON;")
+	return strings.Join(stmts, "\n")
+}
+
+// compares two attached databases and returns a SchemaDiff along with the
+// migration script that brings dbA to the schema of dbB
+func (a *App) DiffSchemas(dbA string, dbB string) AppResult {
+	if dbA == "" || dbB == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	from, err := loadSchema(a.db, dbA)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	to, err := loadSchema(a.db, dbB)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, diffSchemas(from, to), nil)
+}
+
+// writes the schema of an attached database to path as JSON so it can later
+// be compared with DiffSchemaWithSnapshot
+func (a *App) SaveSchemaSnapshot(dbName string, path string) AppResult {
+	if dbName == "" || path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	snapshot, err := loadSchema(a.db, dbName)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	body, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	if err := os.WriteFile(path, body, FilePermissions); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": path}, nil)
+}
+
+// compares an attached database with a saved snapshot; the migration brings
+// the database to the snapshot's schema
+func (a *App) DiffSchemaWithSnapshot(dbName string, snapshotPath string) AppResult {
+	if dbName == "" || snapshotPath == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	from, err := loadSchema(a.db, dbName)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	to, err := readSchemaSnapshot(snapshotPath)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, diffSchemas(from, to), nil)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	tests := []struct {
+		name        string
+		fromSchema  string
+		toSchema    string
+		wantAdded   int
+		wantRemoved int
+		wantChanged int
+	}{
+		{
+			name:       "Identical schemas",
+			fromSchema: "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);",
+			toSchema:   "CREATE TABLE users(id INTEGER PRIMARY KEY,  name TEXT);",
+		},
+		{
+			name:        "Added column uses ALTER TABLE",
+			fromSchema:  "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);",
+			toSchema:    "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT DEFAULT '');",
+			wantChanged: 1,
+		},
+		{
+			name:        "Removed column requires rebuild",
+			fromSchema:  "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER); CREATE INDEX users_name ON users(name); CREATE VIEW adults AS SELECT * FROM users WHERE age >= 18;",
+			toSchema:    "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT ''); CREATE INDEX users_name ON users(name); CREATE VIEW named AS SELECT name FROM users;",
+			wantAdded:   1,
+			wantRemoved: 1,
+			wantChanged: 1,
+		},
+		{
+			name:        "Tables, indexes and triggers added and removed",
+			fromSchema:  "CREATE TABLE old_logs (msg TEXT); CREATE TABLE items (id INTEGER PRIMARY KEY, qty INTEGER);",
+			toSchema:    "CREATE TABLE items (id INTEGER PRIMARY KEY, qty INTEGER); CREATE INDEX items_qty ON items(qty); CREATE TABLE audit (id INTEGER); CREATE TRIGGER items_audit AFTER INSERT ON items BEGIN INSERT INTO audit VALUES (new.id); END;",
+			wantAdded:   3,
+			wantRemoved: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			db.MustExec(tt.fromSchema)
+
+			target, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer target.Close()
+			target.SetMaxOpenConns(1)
+			target.MustExec(tt.toSchema)
+
+			from, err := loadSchema(db, "main")
+			if err != nil {
+				t.Fatalf("loadSchema(main) error = %v", err)
+			}
+			to, err := loadSchema(target, "main")
+			if err != nil {
+				t.Fatalf("loadSchema(target) error = %v", err)
+			}
+			diff := diffSchemas(from, to)
+			if len(diff.Added) != tt.wantAdded || len(diff.Removed) != tt.wantRemoved || len(diff.Changed) != tt.wantChanged {
+				t.Fatalf("diffSchemas() added/removed/changed = %d/%d/%d, want %d/%d/%d",
+					len(diff.Added), len(diff.Removed), len(diff.Changed),
+					tt.wantAdded, tt.wantRemoved, tt.wantChanged)
+			}
+			if diff.Empty() {
+				if diff.Migration != "" {
+					t.Errorf("expected no migration, got %q", diff.Migration)
+				}
+				return
+			}
+
+			if _, err := db.Exec(diff.Migration); err != nil {
+				t.Fatalf("migration failed: %v\n%s", err, diff.Migration)
+			}
+			migrated, err := loadSchema(db, "main")
+			if err != nil {
+				t.Fatalf("loadSchema(main) error = %v", err)
+			}
+			if after := diffSchemas(migrated, to); !after.Empty() {
+				t.Errorf("schemas still differ after migration: %+v\n%s", after, diff.Migration)
+			}
+		})
+	}
+}
+
+func TestAppendedColumnDefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   int
+		wantOk bool
+	}{
+		{"Plain column", "CREATE TABLE t (a)", "CREATE TABLE t (a, b TEXT)", 1, true},
+		{"Two columns", "CREATE TABLE t (a)", "CREATE TABLE t (a, b TEXT, c INTEGER DEFAULT 0)", 2, true},
+		{"Unique column", "CREATE TABLE t (a)", "CREATE TABLE t (a, b TEXT UNIQUE)", 0, false},
+		{"Not null without default", "CREATE TABLE t (a)", "CREATE TABLE t (a, b TEXT NOT NULL)", 0, false},
+		{"Table constraint", "CREATE TABLE t (a, b)", "CREATE TABLE t (a, b, PRIMARY KEY (a, b))", 0, false},
+		{"Column inserted in middle", "CREATE TABLE t (a, c)", "CREATE TABLE t (a, b, c)", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defs, ok := appendedColumnDefs(SchemaObject{SQL: tt.before}, SchemaObject{SQL: tt.after})
+			if ok != tt.wantOk || len(defs) != tt.want {
+				t.Errorf("appendedColumnDefs() = %v, %v, want %d defs, %v", defs, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSaveSchemaSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if res := test_app.SaveSchemaSnapshot("main", path); res.Err != nil {
+		t.Fatalf("SaveSchemaSnapshot() error = %v", res.Err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0111 != 0 {
+		t.Errorf("snapshot mode = %v, want it not executable", info.Mode().Perm())
+	}
+}
@@ -40,3 +40,148 @@ func (r *AppResult) MarshalJSON() ([]byte, error) {
 	}{Err: r.Error(), Results: r.Results}
 	return json.Marshal(tmp)
 }
+
+// From and To are "db.table" references; rows are matched on Keys, or on the
+// primary key of From when Keys is empty
+type TableDiffRequest struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Keys       []string `json:"keys"`
+	Limit      int      `json:"limit"`
+	ScriptPath string   `json:"scriptPath"`
+	Apply      bool     `json:"apply"`
+}
+
+type MigrationRequest struct {
+	DB       string `json:"db"`
+	Dir      string `json:"dir"`
+	Tracking string `json:"tracking"`
+	Steps    int    `json:"steps"`
+	DryRun   bool   `json:"dryRun"`
+}
+
+func (r MigrationRequest) tracking() string {
+	if r.Tracking == "" {
+		return TRACK_MIGRATIONS_TABLE
+	}
+	return r.Tracking
+}
+
+type DumpRequest struct {
+	DB         string `json:"db"`
+	Path       string `json:"path"`
+	SchemaOnly bool   `json:"schemaOnly"`
+	DataOnly   bool   `json:"dataOnly"`
+	BatchSize  int    `json:"batchSize"`
+}
+
+type ExportDBRequest struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+// Format is ".csv", ".json" or ".parquet"
+type TablesExportRequest struct {
+	Path   string      `json:"path"`
+	Format string      `json:"format"`
+	Preset string      `json:"preset"`
+	CSV    *CSVOptions `json:"csv"`
+}
+
+// Queries become one sheet each; without them every table of DB is exported,
+// or every table of the workspace when DB is empty
+type XLSXExportRequest struct {
+	Path    string      `json:"path"`
+	DB      string      `json:"db"`
+	Queries []XLSXSheet `json:"queries"`
+}
+
+// Table is created from the parquet schema when it does not exist in DB
+type ParquetImportRequest struct {
+	Path  string `json:"path"`
+	DB    string `json:"db"`
+	Table string `json:"table"`
+}
+
+// exports Query, or DB.Table when Query is empty
+type ParquetExportRequest struct {
+	Path        string `json:"path"`
+	Query       string `json:"query"`
+	DB          string `json:"db"`
+	Table       string `json:"table"`
+	Compression string `json:"compression"`
+}
+
+// Empty Delimiter and Encoding are detected. SampleRows is how many rows the
+// column types are inferred from: 0 for the default, negative for all rows.
+// Columns, usually an adjusted preview, overrides the inferred schema.
+type CSVImportRequest struct {
+	Path        string            `json:"path"`
+	DB          string            `json:"db"`
+	Table       string            `json:"table"`
+	Delimiter   string            `json:"delimiter"`
+	Encoding    string            `json:"encoding"`
+	NoHeader    bool              `json:"noHeader"`
+	SampleRows  int               `json:"sampleRows"`
+	PreviewRows int               `json:"previewRows"`
+	BatchSize   int               `json:"batchSize"`
+	Columns     []CSVImportColumn `json:"columns"`
+}
+
+// Mapping maps table columns to source fields; without it columns take the
+// field of the same name. Upserts match rows on Keys, by default the
+// primary key. Every rejected row is also written to RejectPath when set.
+type TableImportRequest struct {
+	Path        string            `json:"path"`
+	Format      string            `json:"format"`
+	DB          string            `json:"db"`
+	Table       string            `json:"table"`
+	Mode        string            `json:"mode"`
+	Keys        []string          `json:"keys"`
+	Mapping     map[string]string `json:"mapping"`
+	RejectPath  string            `json:"rejectPath"`
+	Delimiter   string            `json:"delimiter"`
+	Encoding    string            `json:"encoding"`
+	PreviewRows int               `json:"previewRows"`
+}
+
+// Table names the root table, by default after the file. Files ending in
+// .ndjson or .jsonl are always read one document per line.
+type JSONImportRequest struct {
+	Path    string            `json:"path"`
+	DB      string            `json:"db"`
+	Table   string            `json:"table"`
+	Options JSONImportOptions `json:"options"`
+}
+
+// runs the script at Path against DB, or a new database when DB is empty
+type SQLScriptRequest struct {
+	Path    string           `json:"path"`
+	DB      string           `json:"db"`
+	Options SQLScriptOptions `json:"options"`
+}
+
+// Format streams the rows in that format instead of one JSON result
+type APIQueryRequest struct {
+	Query  string `json:"query"`
+	Format string `json:"format"`
+}
+
+// exports Query, or DB.Table when Query is empty, to Path on the server
+type APIExportRequest struct {
+	Query  string `json:"query"`
+	DB     string `json:"db"`
+	Table  string `json:"table"`
+	Format string `json:"format"`
+	Path   string `json:"path"`
+}
+
+type SchemaDiffRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type ReadOnlyRequest struct {
+	DB       string `json:"db"`
+	ReadOnly bool   `json:"readOnly"`
+}
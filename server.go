@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SCOPE_READ  APIScope = "read"
+	SCOPE_WRITE APIScope = "write"
+
+	defaultServerAddr = "127.0.0.1:7464"
+	// largest request body accepted; data is imported from paths, not uploaded
+	maxAPIRequestBody = 1 << 20
+	// rows returned by the table rows endpoint when no limit is given
+	defaultAPIRowLimit = 1000
+
+	envAPIToken     = "SQLITEGUI_API_TOKEN"
+	envAPIReadToken = "SQLITEGUI_API_READ_TOKEN"
+)
+
+// what a token may do: read scoped tokens only run statements that do not
+// change a database, write scoped tokens may also edit, import and export
+type APIScope string
+
+type apiScopeKey struct{}
+
+// serves the bound App methods as JSON over HTTP on a loopback address
+type apiServer struct {
+	app    *App
+	tokens map[string]APIScope
+}
+
+func newAPIServer(app *App, tokens map[string]APIScope) *apiServer {
+	return &apiServer{app: app, tokens: tokens}
+}
+
+// returns the scope of the request's bearer token, or "" when it has none
+func (s *apiServer) tokenScope(r *http.Request) APIScope {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	var scope APIScope
+	for known, knownScope := range s.tokens {
+		// compare against every token so timing does not reveal a match
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			scope = knownScope
+		}
+	}
+	return scope
+}
+
+func requestScope(r *http.Request) APIScope {
+	scope, _ := r.Context().Value(apiScopeKey{}).(APIScope)
+	return scope
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// checks the token of every request. Requests run concurrently: every
+// connection of the pool attaches the databases its statements name.
+func (s *apiServer) authorize(scope APIScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		granted := s.tokenScope(r)
+		if granted == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sqlitegui"`)
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or unknown token"))
+			return
+		}
+		if scope == SCOPE_WRITE && granted != SCOPE_WRITE {
+			writeAPIError(w, http.StatusForbidden, errors.New("token is read-only"))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxAPIRequestBody)
+		next(w, r.WithContext(context.WithValue(r.Context(), apiScopeKey{}, granted)))
+	}
+}
+
+// writes res as the response, with 400 for the errors of bad input and 500
+// for internal ones
+func writeAPIResult(w http.ResponseWriter, res AppResult) {
+	status := http.StatusOK
+	if res.Err != nil {
+		status = http.StatusBadRequest
+		if body, ok := res.Results.(map[string]any); ok && body["error"] == InternalServerError {
+			status = http.StatusInternalServerError
+		}
+	}
+	writeAPIJSON(w, status, &res)
+}
+
+func resultHandler(handle func(r *http.Request) AppResult) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAPIResult(w, handle(r))
+	}
+}
+
+// decodes the JSON body of r into req, or returns the bad request result
+func (s *apiServer) decode(r *http.Request, req any) (AppResult, bool) {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.app.newResult(fmt.Errorf("%s: %w", BadRequestError, err), map[string]any{"error": BadRequestError}, nil), false
+	}
+	return AppResult{}, true
+}
+
+// routes a POST taking a JSON request to an App method
+func apiCall[T any](s *apiServer, call func(T) AppResult) http.HandlerFunc {
+	return resultHandler(func(r *http.Request) AppResult {
+		var req T
+		if res, ok := s.decode(r, &req); !ok {
+			return res
+		}
+		return call(req)
+	})
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	read := func(pattern string, h http.HandlerFunc) { mux.Handle(pattern, s.authorize(SCOPE_READ, h)) }
+	write := func(pattern string, h http.HandlerFunc) { mux.Handle(pattern, s.authorize(SCOPE_WRITE, h)) }
+
+	read("GET /api/nav", resultHandler(func(r *http.Request) AppResult { return s.app.GetNavData() }))
+	read("GET /api/root", resultHandler(func(r *http.Request) AppResult { return s.app.GetRootPath() }))
+	read("GET /api/databases/{db}/schema", resultHandler(s.schema))
+	read("GET /api/databases/{db}/tables/{table}/rows", s.tableRows)
+	read("POST /api/query", s.query)
+	read("POST /api/diff/schema", apiCall(s, func(req SchemaDiffRequest) AppResult { return s.app.DiffSchemas(req.From, req.To) }))
+	read("POST /api/diff/data", apiCall(s, func(req TableDiffRequest) AppResult {
+		// applying a data diff writes to the target table
+		if req.Apply || req.ScriptPath != "" {
+			return s.app.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+		}
+		return s.app.DiffTableData(req)
+	}))
+
+	write("POST /api/update", apiCall(s, s.app.UpdateDB))
+	write("POST /api/import/csv", apiCall(s, s.app.ImportCSV))
+	write("POST /api/import/table", apiCall(s, s.app.ImportIntoTable))
+	write("POST /api/import/json", apiCall(s, s.app.ImportJSON))
+	write("POST /api/import/parquet", apiCall(s, s.app.ImportParquet))
+	write("POST /api/import/sql", apiCall(s, s.app.RunSQLScript))
+	write("POST /api/export/query", apiCall(s, func(req APIExportRequest) AppResult {
+		return s.app.ExportQueryResult(req.Query, req.Format, req.Path)
+	}))
+	write("POST /api/export/table", apiCall(s, func(req APIExportRequest) AppResult {
+		return s.app.ExportTable(req.DB, req.Table, req.Format, req.Path)
+	}))
+	write("POST /api/export/dump", apiCall(s, s.app.ExportSQLDump))
+	write("POST /api/export/db", apiCall(s, s.app.ExportNewDB))
+	return mux
+}
+
+func (s *apiServer) schema(r *http.Request) AppResult {
+	schema, err := loadSchema(s.app.db, r.PathValue("db"))
+	if err != nil {
+		s.app.logger.Error(err.Error())
+		return s.app.newResult(err, nil, nil)
+	}
+	return s.app.newResult(nil, schema, nil)
+}
+
+// remembers whether anything reached the client, after which errors can no
+// longer change the status
+type sentWriter struct {
+	w    http.ResponseWriter
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
+
+func streamContentType(format string) string {
+	switch format {
+	case FORMAT_NDJSON:
+		return "application/x-ndjson"
+	case FORMAT_JSON:
+		return "application/json"
+	case FORMAT_CSV:
+		return "text/csv; charset=utf-8"
+	case FORMAT_HTML:
+		return "text/html; charset=utf-8"
+	case FORMAT_XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FORMAT_PARQUET:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// streams the rows of query to the client in format, NDJSON by default
+func (s *apiServer) stream(w http.ResponseWriter, query string, format string, table string) {
+	format = normalizeExportFormat(format)
+	if format == "" {
+		format = FORMAT_NDJSON
+	}
+	out := &sentWriter{w: w}
+	rw, err := newRowWriter(out, format, ExportOptions{Table: table})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", streamContentType(format))
+	if _, err = streamRows(s.app.db, query, rw); err == nil {
+		return
+	}
+	s.app.logger.Error(err.Error())
+	if !out.sent {
+		w.Header().Del("Content-Type")
+		writeAPIError(w, http.StatusBadRequest, err)
+	} else if format == FORMAT_NDJSON {
+		// a last line tells line readers the result is incomplete
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+// parses the integer query parameter name of r
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// streams the rows of a table, paged with limit and offset
+func (s *apiServer) tableRows(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultAPIRowLimit)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	table := r.PathValue("table")
+	query := fmt.Sprintf(
+		"SELECT * FROM %s LIMIT %d OFFSET %d;",
+		quoteQualified(r.PathValue("db"), table), limit, offset,
+	)
+	s.stream(w, query, r.URL.Query().Get("format"), table)
+}
+
+// runs a statement. Read-only statements return their rows, as a JSON result
+// or streamed in Format; anything else needs a write scoped token.
+func (s *apiServer) query(w http.ResponseWriter, r *http.Request) {
+	var req APIQueryRequest
+	if res, ok := s.decode(r, &req); !ok {
+		writeAPIResult(w, res)
+		return
+	}
+	query := cleanQuery(req.Query)
+	if !s.app.isReadOnlyQuery(query) {
+		if requestScope(r) != SCOPE_WRITE {
+			writeAPIError(w, http.StatusForbidden, errors.New("token is read-only"))
+			return
+		}
+		writeAPIResult(w, s.app.Query(QueryRequest{Query: req.Query}))
+		return
+	}
+	if req.Format != "" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		s.stream(w, query, req.Format, defaultExportTable)
+		return
+	}
+	writeAPIResult(w, s.app.handleSelectQueries(query, false))
+}
+
+// accepts only addresses on the loopback interface
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address", addr)
+}
+
+func newAPIToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func cliServe(s *cliSession, args []string) error {
+	fs := s.flagSet("serve")
+	addr := fs.String("addr", defaultServerAddr, "loopback address to listen on")
+	token := fs.String("token", os.Getenv(envAPIToken), "read-write token, also read from "+envAPIToken)
+	readToken := fs.String("read-token", os.Getenv(envAPIReadToken), "read-only token, also read from "+envAPIReadToken)
+	if err := s.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if err := checkLoopbackAddr(*addr); err != nil {
+		return err
+	}
+	tokens := map[string]APIScope{}
+	if *readToken != "" {
+		tokens[*readToken] = SCOPE_READ
+	}
+	if *token == "" && *readToken == "" {
+		generated, err := newAPIToken()
+		if err != nil {
+			return err
+		}
+		*token = generated
+		fmt.Fprintf(s.errOut, "read-write token: %s\n", generated)
+	}
+	if *token != "" {
+		tokens[*token] = SCOPE_WRITE
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: newAPIServer(s.app, tokens).handler(), ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(s.errOut, "listening on http://%s\n", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT 1", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"PRAGMA table_info('t')", true},
+		{"PRAGMA user_version = 3", false},
+		{"DELETE FROM t", false},
+		{"SELECT 1; DELETE FROM t", false},
+		{"SELECT ';'", true},
+		{"BEGIN", false},
+		{"SAVEPOINT s", false},
+		{"COMMIT", false},
+		{"PRAGMA foreign_keys = OFF", false},
+		{"PRAGMA query_only(1)", false},
+		{"ATTACH ':memory:' AS x", false},
+		{"PRAGMA foreign_keys", true},
+		{"SELECT * FROM pragma_table_info('t')", true},
+	}
+	for _, tt := range tests {
+		if got := test_app.isReadOnlyQuery(tt.query); got != tt.want {
+			t.Errorf("isReadOnlyQuery(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLoopbackAddr(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"[::1]:8080":     true,
+		"localhost:80":   true,
+		"0.0.0.0:8080":   false,
+		":8080":          false,
+		"10.0.0.2:8080":  false,
+	} {
+		if err := checkLoopbackAddr(addr); (err == nil) != ok {
+			t.Errorf("checkLoopbackAddr(%q) = %v", addr, err)
+		}
+	}
+}
+
+func TestAPIServer(t *testing.T) {
+	root := t.TempDir()
+	db := sqlx.MustOpen(SQLITE_DRIVER, filepath.Join(root, "api.db"))
+	db.MustExec(`CREATE TABLE items (id INTEGER PRIMARY KEY, sku TEXT);
+		INSERT INTO items VALUES (1, 'a'), (2, 'b'), (3, 'c');`)
+	db.Close()
+	prevRoot := test_app.rootPath
+	test_app.rootPath = root
+	defer func() {
+		test_app.detachDBs()
+		test_app.execTrusted("DELETE FROM main.dbs WHERE root = ?;", root)
+		test_app.rootPath = prevRoot
+	}()
+	if err := test_app.attachDBsFromFolder(root); err != nil {
+		t.Fatalf("attachDBsFromFolder() error = %v", err)
+	}
+
+	server := httptest.NewServer(newAPIServer(test_app, map[string]APIScope{
+		"rw": SCOPE_WRITE,
+		"ro": SCOPE_READ,
+	}).handler())
+	defer server.Close()
+	call := func(method string, path string, token string, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	t.Run("Auth", func(t *testing.T) {
+		if res := call("GET", "/api/nav", "", ""); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("no token: status %d", res.StatusCode)
+		}
+		if res := call("GET", "/api/nav", "wrong", ""); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("unknown token: status %d", res.StatusCode)
+		}
+		if res := call("POST", "/api/update", "ro", "{}"); res.StatusCode != http.StatusForbidden {
+			t.Errorf("read token on write route: status %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Nav", func(t *testing.T) {
+		res := call("GET", "/api/nav", "ro", "")
+		var body struct {
+			Results map[string]struct {
+				Tables []string `json:"tables"`
+			} `json:"results"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("status %d: %v", res.StatusCode, err)
+		}
+		if tables := body.Results["api"].Tables; len(tables) != 1 || tables[0] != "items" {
+			t.Errorf("nav tables of api = %v", tables)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		res := call("GET", "/api/databases/api/schema", "ro", "")
+		var body struct {
+			Results SchemaSnapshot `json:"results"`
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		if len(body.Results.Objects) != 1 || len(body.Results.Objects[0].Columns) != 2 {
+			t.Errorf("unexpected schema %+v", body.Results)
+		}
+	})
+
+	t.Run("StreamRows", func(t *testing.T) {
+		res := call("GET", "/api/databases/api/tables/items/rows?limit=2&offset=1", "ro", "")
+		if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("content type %q", ct)
+		}
+		var skus []string
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			var row map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("bad line %q: %v", scanner.Text(), err)
+			}
+			skus = append(skus, row["sku"].(string))
+		}
+		if strings.Join(skus, ",") != "b,c" {
+			t.Errorf("streamed skus %v, want b,c", skus)
+		}
+		if res := call("GET", "/api/databases/api/tables/missing/rows", "ro", ""); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("missing table: status %d", res.StatusCode)
+		}
+	})
+
+	t.Run("QueryScopes", func(t *testing.T) {
+		res := call("POST", "/api/query", "ro", `{"query": "SELECT COUNT(*) AS n FROM api.items"}`)
+		var body struct {
+			Results struct {
+				Rows [][]any `json:"rows"`
+			} `json:"results"`
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		if res.StatusCode != http.StatusOK || len(body.Results.Rows) != 1 || body.Results.Rows[0][0] != 3.0 {
+			t.Errorf("read query: status %d, %+v", res.StatusCode, body)
+		}
+		if res := call("POST", "/api/query", "ro", `{"query": "DELETE FROM api.items"}`); res.StatusCode != http.StatusForbidden {
+			t.Errorf("read token delete: status %d", res.StatusCode)
+		}
+		if res := call("POST", "/api/query", "ro", `{"query": "SELECT 1; DELETE FROM api.items"}`); res.StatusCode != http.StatusForbidden {
+			t.Errorf("read token stacked delete: status %d", res.StatusCode)
+		}
+		// statements that leave state on the pooled connection are not reads
+		for _, query := range []string{"BEGIN", "SAVEPOINT s", "PRAGMA query_only = 1", "PRAGMA foreign_keys = OFF"} {
+			if res := call("POST", "/api/query", "ro", `{"query": "`+query+`"}`); res.StatusCode != http.StatusForbidden {
+				t.Errorf("read token %s: status %d", query, res.StatusCode)
+			}
+		}
+		if res := call("POST", "/api/query", "rw", `{"query": "DELETE FROM api.items WHERE id = 3"}`); res.StatusCode != http.StatusOK {
+			t.Errorf("write token delete: status %d", res.StatusCode)
+		}
+		var count int
+		test_app.db.Get(&count, "SELECT COUNT(*) FROM api.items;")
+		if count != 2 {
+			t.Errorf("got %d rows, want 2", count)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// an open stream does not hold up other clients
+		stream := call("GET", "/api/databases/api/tables/items/rows", "ro", "")
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("POST", server.URL+"/api/query", strings.NewReader(`{"query": "SELECT COUNT(*) FROM api.items"}`))
+				req.Header.Set("Authorization", "Bearer ro")
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					t.Errorf("concurrent query: status %d", res.StatusCode)
+				}
+			}()
+		}
+		wg.Wait()
+		io.Copy(io.Discard, stream.Body)
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// statements between progress events
+	scriptProgressStatements = 500
+	// statement text kept in errors
+	maxScriptErrorText = 200
+	// errors kept in the report when continuing past them
+	maxReportedScriptErrors = 1000
+)
+
+// one statement of a script and the line it starts on
+type sqlStatement struct {
+	Text string
+	Line int
+}
+
+// splits SQL text into statements without reading it all into memory. It
+// understands quotes, comments and the semicolons inside trigger bodies.
+type sqlScanner struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newSQLScanner(r io.Reader) *sqlScanner {
+	return &sqlScanner{r: bufio.NewReaderSize(r, 1<<16), line: 1}
+}
+
+func (s *sqlScanner) readByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil && b == '\n' {
+		s.line++
+	}
+	return b, err
+}
+
+func (s *sqlScanner) peek() byte {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func isSQLWordByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+}
+
+// returns the next statement without its terminating semicolon, or io.EOF
+func (s *sqlScanner) Next() (sqlStatement, error) {
+	var sb strings.Builder
+	var word strings.Builder
+	var words []string // the first words, to recognise CREATE TRIGGER
+	stmt := sqlStatement{}
+	trigger, caseDepth := false, 0
+
+	endWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := strings.ToUpper(word.String())
+		word.Reset()
+		if len(words) < 3 {
+			words = append(words, w)
+			// CREATE [TEMP] TRIGGER
+			if words[0] == "CREATE" && w == "TRIGGER" {
+				trigger = true
+			}
+		}
+		if !trigger {
+			return
+		}
+		switch w {
+		case "CASE":
+			caseDepth++
+		case "END":
+			if caseDepth > 0 {
+				caseDepth--
+			} else {
+				trigger = false
+			}
+		}
+	}
+
+	for {
+		b, err := s.readByte()
+		if err == io.EOF {
+			endWord()
+			stmt.Text = strings.TrimSpace(sb.String())
+			if stmt.Text == "" {
+				return stmt, io.EOF
+			}
+			return stmt, nil
+		}
+		if err != nil {
+			return stmt, err
+		}
+		if !isSQLWordByte(b) {
+			endWord()
+		}
+		switch {
+		case b == '-' && s.peek() == '-':
+			// line comments are dropped
+			for b != '\n' {
+				if b, err = s.readByte(); err != nil {
+					break
+				}
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte('\n')
+			}
+			continue
+		case b == '/' && s.peek() == '*':
+			s.readByte()
+			var prev byte
+			for {
+				c, err := s.readByte()
+				if err != nil || prev == '*' && c == '/' {
+					break
+				}
+				prev = c
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			continue
+		case b == ';' && !trigger:
+			stmt.Text = strings.TrimSpace(sb.String())
+			if stmt.Text == "" {
+				// empty statements are skipped
+				sb.Reset()
+				words = words[:0]
+				continue
+			}
+			return stmt, nil
+		}
+		if sb.Len() == 0 {
+			if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+				continue
+			}
+			stmt.Line = s.line
+		}
+		sb.WriteByte(b)
+		if isSQLWordByte(b) {
+			word.WriteByte(b)
+			continue
+		}
+		if close := closingQuote(b); close != 0 {
+			for {
+				c, err := s.readByte()
+				if err != nil {
+					break
+				}
+				sb.WriteByte(c)
+				if c == close {
+					// doubled quotes escape themselves
+					if close != ']' && s.peek() == close {
+						d, _ := s.readByte()
+						sb.WriteByte(d)
+						continue
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+// a statement that failed, with the line it starts on
+type ScriptError struct {
+	Line      int    `json:"line"`
+	Statement string `json:"statement"`
+	Message   string `json:"error"`
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %s (%s)", e.Line, e.Message, e.Statement)
+}
+
+type ScriptReport struct {
+	DB       string `json:"db"`
+	Executed int64  `json:"executed"`
+	Failed   int64  `json:"failed"`
+	// transaction statements of the script, skipped inside the runner's own
+	Skipped   int64          `json:"skipped"`
+	Errors    []*ScriptError `json:"errors"`
+	Truncated bool           `json:"truncated"`
+}
+
+type ScriptProgress struct {
+	Statements int64 `json:"statements"`
+	Line       int   `json:"line"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+type SQLScriptOptions struct {
+	// run statements one by one instead of in a single transaction
+	NoTransaction bool `json:"noTransaction"`
+	// record failing statements and carry on
+	ContinueOnError bool `json:"continueOnError"`
+}
+
+// reports whether stmt begins, commits or rolls back a transaction, as
+// dumps usually wrap their statements in BEGIN TRANSACTION and COMMIT
+func isTransactionStatement(stmt string) bool {
+	words := strings.Fields(strings.ToUpper(stmt))
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "BEGIN", "COMMIT", "END":
+		return true
+	case "ROLLBACK":
+		// ROLLBACK TO a savepoint stays inside the transaction
+		return !slices.Contains(words, "TO")
+	}
+	return false
+}
+
+// runs every statement read from r against db. Inside a transaction any
+// failure rolls the whole script back unless ContinueOnError is set.
+func runSQLScript(db *sqlx.DB, r io.Reader, opts SQLScriptOptions, progress func(ScriptProgress)) (ScriptReport, error) {
+	report := ScriptReport{Errors: []*ScriptError{}}
+	counter, _ := r.(*countingReader)
+	var exec sqlx.Execer = db
+	var tx *sqlx.Tx
+	if !opts.NoTransaction {
+		var err error
+		if tx, err = db.Beginx(); err != nil {
+			return report, err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+	scanner := newSQLScanner(r)
+	notify := func() {
+		if progress == nil {
+			return
+		}
+		p := ScriptProgress{Statements: report.Executed + report.Failed, Line: scanner.line}
+		if counter != nil {
+			p.Bytes = counter.n
+		}
+		progress(p)
+	}
+	for {
+		stmt, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		if tx != nil && isTransactionStatement(stmt.Text) {
+			report.Skipped++
+			continue
+		}
+		if _, err := exec.Exec(stmt.Text); err != nil {
+			text := stmt.Text
+			if len(text) > maxScriptErrorText {
+				text = text[:maxScriptErrorText] + "..."
+			}
+			scriptErr := &ScriptError{Line: stmt.Line, Statement: text, Message: err.Error()}
+			report.Failed++
+			if !opts.ContinueOnError {
+				report.Errors = append(report.Errors, scriptErr)
+				return report, scriptErr
+			}
+			if len(report.Errors) < maxReportedScriptErrors {
+				report.Errors = append(report.Errors, scriptErr)
+			} else {
+				report.Truncated = true
+			}
+		} else {
+			report.Executed++
+		}
+		if (report.Executed+report.Failed)%scriptProgressStatements == 0 {
+			notify()
+		}
+	}
+	notify()
+	if tx != nil {
+		return report, tx.Commit()
+	}
+	return report, nil
+}
+
+// streams the script at path into db, emitting SCRIPT_PROGRESS
+func (a *App) runSQLScriptFile(db *sqlx.DB, path string, opts SQLScriptOptions) (ScriptReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ScriptReport{}, err
+	}
+	defer file.Close()
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return runSQLScript(db, &countingReader{r: file}, opts, func(p ScriptProgress) {
+		p.TotalBytes = size
+		a.emitData(SCRIPT_PROGRESS, p)
+	})
+}
+
+// runs the script at path in a transaction against a new database at dbPath
+// and stores it under dbName
+func (a *App) uploadSQLScript(path string, dbName string, dbPath string) error {
+	db, err := sqlx.Open(SQLITE_DRIVER, dbPath)
+	if err != nil {
+		return err
+	}
+	_, err = a.runSQLScriptFile(db, path, SQLScriptOptions{})
+	db.Close()
+	if err != nil {
+		os.Remove(dbPath)
+		return err
+	}
+	return a.storeDB(dbName, dbPath, true)
+}
+
+// runs a .sql script against an attached database, or a new database named
+// after the script when req.DB is empty
+func (a *App) RunSQLScript(req SQLScriptRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	var db *sqlx.DB
+	var dbPath string
+	var err error
+	dbName := req.DB
+	if req.DB == "" {
+		db, dbName, dbPath, err = a.newImportDB(req.Path)
+	} else {
+		db, err = a.openWritableDB(req.DB)
+	}
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	report, err := a.runSQLScriptFile(db, req.Path, req.Options)
+	report.DB = dbName
+	db.Close()
+	if err == nil && req.DB == "" {
+		err = a.storeDB(dbName, dbPath, true)
+	}
+	if err != nil {
+		if req.DB == "" {
+			os.Remove(dbPath)
+		}
+		a.logger.Error(err.Error())
+		return a.newResult(err, report, nil)
+	}
+	return a.newResult(nil, report, nil)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSQLScanner(t *testing.T) {
+	script := `-- dump header
+BEGIN TRANSACTION;
+CREATE TABLE t (a TEXT, "b;c" INTEGER); /* inline ; comment */
+INSERT INTO t VALUES ('x;y', 1), ('it''s; -- not a comment', 2);;
+
+CREATE TRIGGER trg AFTER INSERT ON t BEGIN
+  UPDATE t SET a = CASE WHEN a = 'end' THEN 'x' ELSE a END;
+  SELECT 1;
+END;
+CREATE TEMP TRIGGER [end] AFTER DELETE ON t BEGIN SELECT 2; END;
+COMMIT;
+SELECT 'no terminator'`
+	want := []sqlStatement{
+		{"BEGIN TRANSACTION", 2},
+		{`CREATE TABLE t (a TEXT, "b;c" INTEGER)`, 3},
+		{"INSERT INTO t VALUES ('x;y', 1), ('it''s; -- not a comment', 2)", 4},
+		{"CREATE TRIGGER trg AFTER INSERT ON t BEGIN\n  UPDATE t SET a = CASE WHEN a = 'end' THEN 'x' ELSE a END;\n  SELECT 1;\nEND", 6},
+		{"CREATE TEMP TRIGGER [end] AFTER DELETE ON t BEGIN SELECT 2; END", 10},
+		{"COMMIT", 11},
+		{"SELECT 'no terminator'", 12},
+	}
+	scanner := newSQLScanner(strings.NewReader(script))
+	for i, w := range want {
+		got, err := scanner.Next()
+		if err != nil {
+			t.Fatalf("statement %d: Next() error = %v", i, err)
+		}
+		if got != w {
+			t.Errorf("statement %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if _, err := scanner.Next(); err == nil {
+		t.Error("Next() returned a statement past the end of the script")
+	}
+}
+
+func TestRunSQLScript(t *testing.T) {
+	const script = "BEGIN;\nCREATE TABLE t (id INTEGER PRIMARY KEY);\nINSERT INTO t VALUES (1);\n\nINSERT INTO t VALUES (1);\nINSERT INTO t VALUES (2);\nCOMMIT;\n"
+	tests := []struct {
+		name     string
+		opts     SQLScriptOptions
+		wantErr  bool
+		rows     int
+		executed int64
+		failed   int64
+	}{
+		{"Rolls back on error", SQLScriptOptions{}, true, -1, 2, 1},
+		{"Continues past errors", SQLScriptOptions{ContinueOnError: true}, false, 2, 3, 1},
+		{"Without a transaction", SQLScriptOptions{NoTransaction: true}, true, 1, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+			if err != nil {
+				t.Fatalf("failed to open in-memory database: %v", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			var progress []int64
+			report, err := runSQLScript(db, strings.NewReader(script), tt.opts, func(p ScriptProgress) {
+				progress = append(progress, p.Statements)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runSQLScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var scriptErr *ScriptError
+			if tt.wantErr && (!errors.As(err, &scriptErr) || scriptErr.Line != 5) {
+				t.Errorf("runSQLScript() error = %v, want a failure on line 5", err)
+			}
+			if report.Executed != tt.executed || report.Failed != tt.failed || len(report.Errors) != 1 || report.Errors[0].Line != 5 {
+				t.Errorf("runSQLScript() report = %+v", report)
+			}
+			rows := -1
+			db.Get(&rows, "SELECT COUNT(*) FROM t;")
+			if rows != tt.rows {
+				t.Errorf("table has %d rows, want %d", rows, tt.rows)
+			}
+			if !tt.wantErr && len(progress) == 0 {
+				t.Error("runSQLScript() reported no progress")
+			}
+		})
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	IMPORT_APPEND  = "append"
+	IMPORT_REPLACE = "replace"
+	IMPORT_UPSERT  = "upsert"
+
+	// rejects kept in the report; the reject file receives all of them
+	maxReportedRejects = 1000
+	// rows between progress events
+	importProgressRows = 1000
+)
+
+// yields the records of a data file as field name to value maps
+type recordSource interface {
+	// field names seen so far, in file order
+	Fields() []string
+	// returns io.EOF after the last record
+	Next() (map[string]any, error)
+	Close() error
+}
+
+type csvRecordSource struct {
+	src    *csvSource
+	fields []string
+}
+
+func (s *csvRecordSource) Fields() []string { return s.fields }
+
+func (s *csvRecordSource) Next() (map[string]any, error) {
+	record, err := s.src.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make(map[string]any, len(s.fields))
+	for i, field := range s.fields {
+		if i < len(record) && record[i] != "" {
+			row[field] = record[i]
+		}
+	}
+	return row, nil
+}
+
+func (s *csvRecordSource) Close() error { return s.src.Close() }
+
+// reads either a JSON array of objects or newline-delimited objects
+type jsonRecordSource struct {
+	file    *os.File
+	dec     *json.Decoder
+	inArray bool
+	fields  []string
+	seen    map[string]bool
+}
+
+func (s *jsonRecordSource) Fields() []string { return s.fields }
+
+func (s *jsonRecordSource) Next() (map[string]any, error) {
+	if s.inArray && !s.dec.More() {
+		return nil, io.EOF
+	}
+	var row map[string]any
+	if err := s.dec.Decode(&row); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("malformed json record: %w", err)
+	}
+	if row == nil {
+		return nil, errors.New("malformed json record: expected an object")
+	}
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		if !s.seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	// map order is random, so new keys are added sorted
+	slices.Sort(keys)
+	for _, k := range keys {
+		s.seen[k] = true
+		s.fields = append(s.fields, k)
+	}
+	return row, nil
+}
+
+func (s *jsonRecordSource) Close() error { return s.file.Close() }
+
+func openJSONRecords(path string) (*jsonRecordSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	s := &jsonRecordSource{file: file, seen: make(map[string]bool)}
+	// a leading '[' means an array of records, anything else is NDJSON
+	for {
+		b, err := buffered.Peek(1)
+		if err != nil {
+			file.Close()
+			if err == io.EOF {
+				return nil, errors.New("json file is empty")
+			}
+			return nil, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			s.inArray = b[0] == '['
+			break
+		}
+		buffered.Discard(1)
+	}
+	s.dec = json.NewDecoder(buffered)
+	s.dec.UseNumber()
+	if s.inArray {
+		if _, err := s.dec.Token(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// opens path as CSV, JSON or NDJSON; an empty format is taken from the extension
+func openRecordSource(path string, format string, delimiter string, encoding string) (recordSource, error) {
+	if format == "" {
+		_, format = parseFile(path)
+	}
+	switch normalizeExportFormat(format) {
+	case FORMAT_CSV, FORMAT_TSV:
+		if delimiter == "" && normalizeExportFormat(format) == FORMAT_TSV {
+			delimiter = "\t"
+		}
+		src, err := openCSVSource(path, encoding, delimiter, false)
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]string, 0, len(src.header))
+		for _, col := range csvColumns(src.header, 0) {
+			fields = append(fields, col.Name)
+		}
+		return &csvRecordSource{src: src, fields: fields}, nil
+	case FORMAT_JSON, FORMAT_NDJSON:
+		return openJSONRecords(path)
+	}
+	return nil, fmt.Errorf("unsupported import format %q", format)
+}
+
+// converts a decoded record value into something the driver can bind
+func importValue(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool:
+		return v
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return f
+		}
+		return string(v)
+	default:
+		// nested objects and arrays are stored as JSON text
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
+
+// a record the target table refused
+type ImportReject struct {
+	Record int64          `json:"record"`
+	Error  string         `json:"error"`
+	Values map[string]any `json:"values"`
+}
+
+type TableImportReport struct {
+	DB       string         `json:"db"`
+	Table    string         `json:"table"`
+	Mode     string         `json:"mode"`
+	Deleted  int64          `json:"deleted"`
+	Imported int64          `json:"imported"`
+	Rejected int64          `json:"rejected"`
+	Rejects  []ImportReject `json:"rejects"`
+	// true when more rejects happened than the report holds
+	Truncated bool `json:"truncated"`
+}
+
+// source fields and target columns with the mapping an import would use
+// when none is given
+type TableImportPreview struct {
+	Fields  []string          `json:"fields"`
+	Columns []string          `json:"columns"`
+	Keys    []string          `json:"keys"`
+	Mapping map[string]string `json:"mapping"`
+	Rows    []map[string]any  `json:"rows"`
+}
+
+// maps each target column to the source field of the same name, ignoring case
+func defaultImportMapping(fields []string, columns []string) map[string]string {
+	mapping := make(map[string]string)
+	for _, col := range columns {
+		for _, field := range fields {
+			if strings.EqualFold(strings.TrimSpace(field), col) {
+				mapping[col] = field
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+func previewTableImport(db *sqlx.DB, req TableImportRequest) (TableImportPreview, error) {
+	var preview TableImportPreview
+	cols, err := tableColumns(db, tableRef{DB: "main", Table: req.Table})
+	if err != nil {
+		return preview, err
+	}
+	keys, err := primaryKeyColumns(db, tableRef{DB: "main", Table: req.Table})
+	if err != nil {
+		return preview, err
+	}
+	src, err := openRecordSource(req.Path, req.Format, req.Delimiter, req.Encoding)
+	if err != nil {
+		return preview, err
+	}
+	defer src.Close()
+	previewRows := req.PreviewRows
+	if previewRows <= 0 {
+		previewRows = defaultCSVPreviewRows
+	}
+	preview.Rows = []map[string]any{}
+	for len(preview.Rows) < previewRows {
+		row, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return preview, err
+		}
+		for k, v := range row {
+			row[k] = importValue(v)
+		}
+		preview.Rows = append(preview.Rows, row)
+	}
+	preview.Fields = src.Fields()
+	preview.Columns = cols
+	preview.Keys = keys
+	preview.Mapping = defaultImportMapping(preview.Fields, cols)
+	return preview, nil
+}
+
+// builds the insert statement for mode; upserts update every mapped column
+// that is not a key
+func importInsertSQL(table string, mode string, cols []string, keys []string) string {
+	quoted := quoteIdents(cols)
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(table), strings.Join(quoted, ", "), generateINSERTPlaceholders(len(cols)))
+	if mode != IMPORT_UPSERT {
+		return insert + ";"
+	}
+	quotedKeys := quoteIdents(keys)
+	var sets []string
+	for i, col := range cols {
+		if !slices.Contains(keys, col) {
+			sets = append(sets, fmt.Sprintf("%s = excluded.%s", quoted[i], quoted[i]))
+		}
+	}
+	if len(sets) == 0 {
+		return fmt.Sprintf("%s ON CONFLICT (%s) DO NOTHING;", insert, strings.Join(quotedKeys, ", "))
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s;", insert, strings.Join(quotedKeys, ", "), strings.Join(sets, ", "))
+}
+
+// writes every reject as a CSV row of record number, error and the source
+// values as JSON
+type rejectWriter struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func newRejectWriter(path string) (*rejectWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"record", "error", "values"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rejectWriter{file: file, w: w}, nil
+}
+
+func (r *rejectWriter) Write(reject ImportReject) error {
+	values, err := json.Marshal(reject.Values)
+	if err != nil {
+		return err
+	}
+	return r.w.Write([]string{strconv.FormatInt(reject.Record, 10), reject.Error, string(values)})
+}
+
+func (r *rejectWriter) Close() error {
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// imports the records of req.Path into an existing table of db in one
+// transaction. Rows the table refuses are reported instead of aborting.
+func importIntoTable(db *sqlx.DB, req TableImportRequest, progress func(rows int64)) (TableImportReport, error) {
+	report := TableImportReport{DB: req.DB, Table: req.Table, Mode: req.Mode, Rejects: []ImportReject{}}
+	if report.Mode == "" {
+		report.Mode = IMPORT_APPEND
+	}
+	if !slices.Contains([]string{IMPORT_APPEND, IMPORT_REPLACE, IMPORT_UPSERT}, report.Mode) {
+		return report, fmt.Errorf("unknown import mode %q", req.Mode)
+	}
+	table := tableRef{DB: "main", Table: req.Table}
+	tableCols, err := tableColumns(db, table)
+	if err != nil {
+		return report, err
+	}
+	src, err := openRecordSource(req.Path, req.Format, req.Delimiter, req.Encoding)
+	if err != nil {
+		return report, err
+	}
+	defer src.Close()
+
+	mapping := req.Mapping
+	if len(mapping) == 0 {
+		// CSV fields are known up front, JSON ones only once records are read
+		first, err := src.Next()
+		if err != nil && err != io.EOF {
+			return report, err
+		}
+		mapping = defaultImportMapping(src.Fields(), tableCols)
+		if first != nil {
+			src = &peekedSource{recordSource: src, first: first}
+		}
+	}
+	var cols, fields []string
+	for _, col := range tableCols {
+		if field, ok := mapping[col]; ok && field != "" {
+			cols = append(cols, col)
+			fields = append(fields, field)
+		}
+	}
+	for col := range mapping {
+		if !slices.Contains(tableCols, col) {
+			return report, fmt.Errorf("table %s has no column %s", req.Table, col)
+		}
+	}
+	if len(cols) == 0 {
+		return report, errors.New("no source fields are mapped to table columns")
+	}
+
+	keys := req.Keys
+	if report.Mode == IMPORT_UPSERT {
+		if len(keys) == 0 {
+			if keys, err = primaryKeyColumns(db, table); err != nil {
+				return report, err
+			}
+		}
+		if len(keys) == 0 {
+			return report, fmt.Errorf("table %s has no primary key; choose the upsert key columns", req.Table)
+		}
+		for _, key := range keys {
+			if !slices.Contains(cols, key) {
+				return report, fmt.Errorf("upsert key %s is not mapped", key)
+			}
+		}
+	}
+
+	var rejects *rejectWriter
+	if req.RejectPath != "" {
+		if rejects, err = newRejectWriter(req.RejectPath); err != nil {
+			return report, err
+		}
+		defer func() {
+			if rejects != nil {
+				rejects.Close()
+			}
+		}()
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+	if report.Mode == IMPORT_REPLACE {
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s;", quoteIdent(req.Table)))
+		if err != nil {
+			return report, err
+		}
+		report.Deleted, _ = res.RowsAffected()
+	}
+	stmt, err := tx.Preparex(importInsertSQL(req.Table, report.Mode, cols, keys))
+	if err != nil {
+		return report, err
+	}
+	defer stmt.Close()
+
+	args := make([]any, len(fields))
+	var record int64
+	for {
+		row, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("record %d: %w", record+1, err)
+		}
+		record++
+		for i, field := range fields {
+			args[i] = importValue(row[field])
+		}
+		// a failed statement only undoes itself, so the transaction carries on
+		if _, err := stmt.Exec(args...); err != nil {
+			reject := ImportReject{Record: record, Error: err.Error(), Values: row}
+			report.Rejected++
+			if len(report.Rejects) < maxReportedRejects {
+				report.Rejects = append(report.Rejects, reject)
+			} else {
+				report.Truncated = true
+			}
+			if rejects != nil {
+				if err := rejects.Write(reject); err != nil {
+					return report, err
+				}
+			}
+		} else {
+			report.Imported++
+		}
+		if progress != nil && record%importProgressRows == 0 {
+			progress(record)
+		}
+	}
+	if progress != nil {
+		progress(record)
+	}
+	if rejects != nil {
+		if err := rejects.Close(); err != nil {
+			return report, err
+		}
+		rejects = nil
+	}
+	return report, tx.Commit()
+}
+
+// replays a record that was read ahead before the rest of the source
+type peekedSource struct {
+	recordSource
+	first map[string]any
+}
+
+func (s *peekedSource) Next() (map[string]any, error) {
+	if s.first != nil {
+		row := s.first
+		s.first = nil
+		return row, nil
+	}
+	return s.recordSource.Next()
+}
+
+// returns the source fields, target columns and suggested mapping for
+// importing a file into an existing table
+func (a *App) PreviewTableImport(req TableImportRequest) AppResult {
+	if req.Path == "" || req.DB == "" || req.Table == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	db, err := a.openAttachedDB(req.DB)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	preview, err := previewTableImport(db, req)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, preview, nil)
+}
+
+// imports a CSV, JSON or NDJSON file into an existing table of an attached
+// database, appending, replacing its rows or upserting on key columns
+func (a *App) ImportIntoTable(req TableImportRequest) AppResult {
+	if req.Path == "" || req.DB == "" || req.Table == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	db, err := a.openWritableDB(req.DB)
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	defer db.Close()
+	report, err := importIntoTable(db, req, func(rows int64) {
+		a.emitData(IMPORT_PROGRESS, ImportProgress{Table: req.Table, Rows: rows})
+	})
+	if err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, report, nil)
+	}
+	return a.newResult(nil, report, nil)
+}
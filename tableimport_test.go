@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestImportIntoTable(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sqlx.Open(SQLITE_DRIVER, filepath.Join(dir, "report.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	files := map[string]string{
+		"march.csv":    "ID,Region,Total,extra\n1,north,10,x\n2,south,20,y\n2,dupe,0,z\n3,,5,w\n",
+		"april.ndjson": "{\"id\": 2, \"region\": \"south\", \"total\": 25.5}\n{\"id\": 4, \"region\": \"east\", \"total\": 7, \"tags\": [1]}\n",
+		"may.json":     "[{\"code\": 9, \"area\": \"west\", \"amount\": 1}]",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents := func() string {
+		var rows []string
+		if err := db.Select(&rows, "SELECT id || ':' || region || ':' || total FROM sales ORDER BY id;"); err != nil {
+			t.Fatalf("select error = %v", err)
+		}
+		return strings.Join(rows, " ")
+	}
+
+	tests := []struct {
+		name     string
+		req      TableImportRequest
+		want     string
+		imported int64
+		rejected int64
+		wantErr  bool
+	}{
+		{"Append rejects duplicate keys and NULLs", TableImportRequest{Path: "march.csv"}, "1:north:10.0 2:south:20.0", 2, 2, false},
+		{"Upsert on primary key", TableImportRequest{Path: "april.ndjson", Mode: IMPORT_UPSERT}, "1:north:10.0 2:south:25.5 4:east:7.0", 2, 0, false},
+		{"Replace with a mapping", TableImportRequest{Path: "may.json", Mode: IMPORT_REPLACE, Mapping: map[string]string{"id": "code", "region": "area", "total": "amount"}}, "9:west:1.0", 1, 0, false},
+		{"Unknown mode", TableImportRequest{Path: "may.json", Mode: "merge"}, "9:west:1.0", 0, 0, true},
+		{"Unknown column", TableImportRequest{Path: "may.json", Mapping: map[string]string{"missing": "code"}}, "9:west:1.0", 0, 0, true},
+		{"Upsert key not mapped", TableImportRequest{Path: "april.ndjson", Mode: IMPORT_UPSERT, Keys: []string{"region"}, Mapping: map[string]string{"id": "id"}}, "9:west:1.0", 0, 0, true},
+	}
+	db.MustExec("CREATE TABLE sales (id INTEGER PRIMARY KEY, region TEXT NOT NULL, total REAL);")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Path = filepath.Join(dir, tt.req.Path)
+			tt.req.Table = "sales"
+			tt.req.RejectPath = filepath.Join(dir, "rejects.csv")
+			report, err := importIntoTable(db, tt.req, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("importIntoTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := contents(); got != tt.want {
+				t.Errorf("table holds %q, want %q", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if report.Imported != tt.imported || report.Rejected != tt.rejected || len(report.Rejects) != int(tt.rejected) {
+				t.Errorf("importIntoTable() report = %+v", report)
+			}
+			body, _ := os.ReadFile(tt.req.RejectPath)
+			if lines := strings.Count(string(body), "\n"); lines != int(tt.rejected)+1 {
+				t.Errorf("reject file has %d lines, want %d", lines, tt.rejected+1)
+			}
+		})
+	}
+}
+
+func TestPreviewTableImport(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, other TEXT);")
+	path := filepath.Join(t.TempDir(), "t.json")
+	if err := os.WriteFile(path, []byte(`[{"Name": "a", "id": 1}, {"id": 2, "zeta": {"x": 1}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	preview, err := previewTableImport(db, TableImportRequest{Path: path, Table: "t"})
+	if err != nil {
+		t.Fatalf("previewTableImport() error = %v", err)
+	}
+	if !slices.Equal(preview.Fields, []string{"Name", "id", "zeta"}) || !slices.Equal(preview.Keys, []string{"id"}) {
+		t.Errorf("previewTableImport() fields = %q, keys = %q", preview.Fields, preview.Keys)
+	}
+	if len(preview.Mapping) != 2 || preview.Mapping["name"] != "Name" || preview.Mapping["id"] != "id" {
+		t.Errorf("previewTableImport() mapping = %v", preview.Mapping)
+	}
+	if len(preview.Rows) != 2 || preview.Rows[1]["zeta"] != `{"x":1}` || preview.Rows[0]["id"] != int64(1) {
+		t.Errorf("previewTableImport() rows = %v", preview.Rows)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	_ "embed"
-	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -19,6 +18,7 @@ const (
 	SCREEN_HEIGHT = 1080
 
 	SafePermissions     = 0755
+	FilePermissions     = 0644
 	InternalServerError = "internal server error"
 	BadRequestError     = "bad request"
 
@@ -36,6 +36,10 @@ const (
 	OPEN_FOLDER_FAIL    WailsEmitType = "openFolderFailed"
 	IMPORT_DB_SUCCESS   WailsEmitType = "importDBSucceeded"
 	IMPORT_DB_FAIL      WailsEmitType = "importDBFailed"
+	EXPORT_PROGRESS     WailsEmitType = "exportProgress"
+	IMPORT_PROGRESS     WailsEmitType = "importProgress"
+	SCRIPT_PROGRESS     WailsEmitType = "scriptProgress"
+	NAV_REFRESH         WailsEmitType = "navRefresh"
 )
 
 var (
@@ -47,8 +51,8 @@ var (
 
 	pkRegex = regexp.MustCompile(`(?i)SELECT\s+.*?\s+FROM\s+(\w+)`)
 
-	dbFileTypes   = [2]string{".db", ".sqlite"}
-	SYSTEM_TABLES = [2]string{"dbs", "current_db"}
+	dbFileTypes   = [6]string{".db", ".sqlite", ".sqlite3", ".db3", ".gpkg", ".mbtiles"}
+	SYSTEM_TABLES = [3]string{"dbs", "current_db", "export_presets"}
 )
 
 type TargetOS string
@@ -90,17 +94,6 @@ func cleanQuery(query string) string {
 	return strings.Join(cleanedStatements, "; ")
 }
 
-func containsAttachStatement(query string) bool {
-	statements := strings.SplitSeq(strings.TrimSpace(query), ";")
-	for statement := range statements {
-		trimmedStatement := strings.ToUpper(strings.TrimSpace(statement))
-		if strings.HasPrefix(trimmedStatement, "ATTACH") || strings.HasPrefix(trimmedStatement, "DETACH") {
-			return true
-		}
-	}
-	return false
-}
-
 // Returns safe DB name and file ext of the file
 func parseFile(file string) (string, string) {
 	if file == "" {
@@ -142,7 +135,7 @@ func sqlSanitize(tblName string) string {
 		tblName = strings.Trim(tblName, "_")
 	}
 
-	return fmt.Sprintf(`"%s"`, strings.ToLower(tblName))
+	return quoteIdent(strings.ToLower(tblName))
 }
 
 func cleanDBName(file string) string {
@@ -161,3 +154,88 @@ func cleanDBName(file string) string {
 	// 5. Return the cleaned name (lowercase and trimmed)
 	return strings.ToLower(strings.TrimSpace(trimmedName))
 }
+
+// returns the closing quote for a quote-opening byte, or 0 if b does not open a quoted section
+func closingQuote(b byte) byte {
+	switch b {
+	case '\'', '"', '`':
+		return b
+	case '[':
+		return ']'
+	}
+	return 0
+}
+
+// collapses whitespace outside of quoted sections and removes it around
+// parentheses and commas so equivalent definitions compare equal
+func normalizeSQL(sqlText string) string {
+	var sb strings.Builder
+	var quote, prev byte
+	pendingSpace := false
+	for i := 0; i < len(sqlText); i++ {
+		c := sqlText[i]
+		if quote != 0 {
+			sb.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+			prev = c
+			continue
+		}
+		if unicode.IsSpace(rune(c)) {
+			pendingSpace = sb.Len() > 0
+			continue
+		}
+		if pendingSpace {
+			if !strings.ContainsRune("(,", rune(prev)) && !strings.ContainsRune("(),", rune(c)) {
+				sb.WriteByte(' ')
+			}
+			pendingSpace = false
+		}
+		quote = closingQuote(c)
+		sb.WriteByte(c)
+		prev = c
+	}
+	return sb.String()
+}
+
+// returns the index of the first occurrence of sep that is outside quotes
+// and at parenthesis depth zero, or -1
+func indexTopLevel(sqlText string, sep byte) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(sqlText); i++ {
+		c := sqlText[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == sep && depth == 0 {
+			return i
+		}
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			quote = closingQuote(c)
+		}
+	}
+	return -1
+}
+
+// splits sqlText on sep wherever it appears outside quotes and parentheses
+func splitTopLevel(sqlText string, sep byte) []string {
+	var parts []string
+	for {
+		i := indexTopLevel(sqlText, sep)
+		if i < 0 {
+			return append(parts, sqlText)
+		}
+		parts = append(parts, sqlText[:i])
+		sqlText = sqlText[i+1:]
+	}
+}
@@ -5,62 +5,6 @@ import (
 	"testing"
 )
 
-// NOTE: I am assuming the return signature for containsAttachStatement is (string, bool)
-// as per the previous context, but the test struct only provides 'want' (a bool).
-// The test below has been adjusted to only check the bool result (got2).
-
-// NOTE: This test assumes the loop is fixed to `for _, statement := range statements`
-// and that `strings.SplitSeq` is a typo for `strings.Split`.
-
-func Test_containsAttachStatement(t *testing.T) {
-	tests := []struct {
-		name  string
-		query string
-		want  bool
-	}{
-		{
-			name:  "No illegal statement",
-			query: "SELECT * FROM users; INSERT INTO logs (message) VALUES ('New user');",
-			want:  false,
-		},
-		{
-			name:  "Attach statement present",
-			query: "SELECT id FROM data; ATTACH DATABASE 'test.db' AS test;",
-			want:  true,
-		},
-		{
-			name:  "Detach statement present, case insensitive",
-			query: "DetAcH DATABASE test; SELECT 1;",
-			want:  true,
-		},
-		{
-			name:  "Illegal command on multi-line",
-			query: "\n  ATTACH DATABASE 'db'\n; SELECT * FROM T",
-			want:  true,
-		},
-		{
-			name:  "Prefix match only, NOT just ATTACH anywhere",
-			query: "SELECT 'ATTACHMENT' AS name;",
-			want:  false,
-		},
-		{
-			name:  "Empty query",
-			query: "",
-			want:  false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// **FIXME:** If the actual function has the loop bug (range over indices),
-			// this test will fail until the function is corrected.
-			got := containsAttachStatement(tt.query)
-			if got != tt.want {
-				t.Errorf("containsAttachStatement() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 // NOTE: Assuming cleanQuery removes leading/trailing whitespace and potentially comments/newlines.
 func Test_cleanQuery(t *testing.T) {
 	tests := []struct {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// what happened to an entry of a watched folder
+type watchOp int
+
+const (
+	// a file was written and closed or moved in, or a folder was created
+	WATCH_CREATE watchOp = iota
+	// an entry was deleted or moved out
+	WATCH_REMOVE
+)
+
+type folderEvent struct {
+	Path  string
+	Op    watchOp
+	IsDir bool
+}
+
+// the watcher dropped events; whoever relies on them should rescan
+var errWatchOverflow = errors.New("folder watcher dropped events")
+
+// reports changes to the entries of the folders added to it, not to those of
+// their subfolders. Events is closed once the watcher is.
+type folderWatcher interface {
+	Add(dir string) error
+	Events() <-chan folderEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// the state of an entry seen by a pollWatcher
+type polledEntry struct {
+	isDir    bool
+	size     int64
+	modTime  time.Time
+	info     os.FileInfo
+	reported bool
+}
+
+// a folderWatcher that lists its folders every interval, for platforms
+// without a native one. A new file, or one renamed over another, is
+// reported once it stops changing between two listings.
+type pollWatcher struct {
+	mu        sync.Mutex
+	dirs      map[string]map[string]*polledEntry
+	events    chan folderEvent
+	errors    chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		dirs:   map[string]map[string]*polledEntry{},
+		events: make(chan folderEvent, 64),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	go w.run(interval)
+	return w
+}
+
+func (w *pollWatcher) Add(dir string) error {
+	entries, err := listFolder(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// entries present from the start are not news
+		entry.reported = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs[dir] = entries
+	return nil
+}
+
+func (w *pollWatcher) Events() <-chan folderEvent {
+	return w.events
+}
+
+func (w *pollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *pollWatcher) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return nil
+}
+
+func (w *pollWatcher) run(interval time.Duration) {
+	defer close(w.events)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		for _, event := range w.poll() {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// lists every folder again and returns what changed since the last listing
+func (w *pollWatcher) poll() []folderEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []folderEvent
+	for dir, known := range w.dirs {
+		current, err := listFolder(dir)
+		if err != nil {
+			// the folder's parent reports it gone
+			delete(w.dirs, dir)
+			continue
+		}
+		for name, entry := range known {
+			if _, ok := current[name]; !ok && entry.reported {
+				events = append(events, folderEvent{Path: filepath.Join(dir, name), Op: WATCH_REMOVE, IsDir: entry.isDir})
+			}
+		}
+		for name, entry := range current {
+			prev, ok := known[name]
+			switch {
+			case ok && prev.reported && (entry.isDir || os.SameFile(prev.info, entry.info)):
+				entry.reported = true
+			case entry.isDir || (ok && prev.size == entry.size && prev.modTime.Equal(entry.modTime)):
+				entry.reported = true
+				events = append(events, folderEvent{Path: filepath.Join(dir, name), Op: WATCH_CREATE, IsDir: entry.isDir})
+			}
+		}
+		w.dirs[dir] = current
+	}
+	return events
+}
+
+func listFolder(dir string) (map[string]*polledEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*polledEntry, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries[dirEntry.Name()] = &polledEntry{isDir: info.IsDir(), size: info.Size(), modTime: info.ModTime(), info: info}
+	}
+	return entries, nil
+}
@@ -0,0 +1,135 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_ONLYDIR
+
+// watches folders with inotify
+type inotifyWatcher struct {
+	file   *os.File
+	mu     sync.Mutex
+	dirs   map[int32]string
+	events chan folderEvent
+	errors chan error
+}
+
+func newFolderWatcher() (folderWatcher, error) {
+	// non-blocking, so reads go through the runtime poller and Close ends them
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   map[int32]string{},
+		events: make(chan folderEvent, 64),
+		errors: make(chan error, 1),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dir string) error {
+	conn, err := w.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var wd int
+	var addErr error
+	if err := conn.Control(func(fd uintptr) {
+		wd, addErr = syscall.InotifyAddWatch(int(fd), dir, inotifyMask)
+	}); err != nil {
+		return err
+	}
+	if addErr != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: addErr}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs[int32(wd)] = dir
+	return nil
+}
+
+func (w *inotifyWatcher) Events() <-chan folderEvent {
+	return w.events
+}
+
+func (w *inotifyWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) sendError(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.sendError(err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			name := strings.TrimRight(string(buf[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+nameLen]), "\x00")
+			offset += syscall.SizeofInotifyEvent + nameLen
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.sendError(errWatchOverflow)
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[wd]
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+			}
+			w.mu.Unlock()
+			if !ok || name == "" {
+				continue
+			}
+			event := folderEvent{Path: filepath.Join(dir, name), IsDir: mask&syscall.IN_ISDIR != 0}
+			switch {
+			case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0, event.IsDir && mask&syscall.IN_CREATE != 0:
+				event.Op = WATCH_CREATE
+			case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+				event.Op = WATCH_REMOVE
+			case isSymlink(event.Path):
+				// symlinks are never written, so their creation is the news
+				event.Op = WATCH_CREATE
+			default:
+				// files are reported once written and closed
+				continue
+			}
+			w.events <- event
+		}
+	}
+}
+
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
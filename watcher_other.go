@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+func newFolderWatcher() (folderWatcher, error) {
+	return newPollWatcher(2 * time.Second), nil
+}
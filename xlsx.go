@@ -0,0 +1,693 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// xlsx workbooks are read and written directly as the zip of SpreadsheetML
+// parts they are; only the parts needed for plain tabular data are handled.
+
+const (
+	xlsxMainNS     = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNS      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPkgRelNS   = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsxSheetType  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet"
+	xlsxStylesType = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+
+	xlsxMaxSheetName = 31
+	// Excel keeps 15 significant digits, larger integers are written as text
+	xlsxMaxExactInt = 999999999999999
+)
+
+var (
+	// serial day 0 of the 1900 and 1904 date systems
+	xlsxEpoch1900 = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	xlsxEpoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	xlsxSheetNameReplacer = strings.NewReplacer("[", "(", "]", ")", ":", "_", "*", "_", "?", "_", "/", "_", "\\", "_")
+)
+
+type xlsxRichText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (r xlsxRichText) String() string {
+	if len(r.Runs) == 0 {
+		return r.T
+	}
+	var sb strings.Builder
+	sb.WriteString(r.T)
+	for _, run := range r.Runs {
+		sb.WriteString(run.T)
+	}
+	return sb.String()
+}
+
+type xlsxCell struct {
+	Ref    string       `xml:"r,attr"`
+	Type   string       `xml:"t,attr"`
+	Style  int          `xml:"s,attr"`
+	Value  string       `xml:"v"`
+	Inline xlsxRichText `xml:"is"`
+}
+
+type xlsxRow struct {
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxReader struct {
+	zr         *zip.Reader
+	shared     []string
+	dateStyles map[int]bool
+	epoch      time.Time
+}
+
+func (x *xlsxReader) decodePart(name string, v any) error {
+	f, err := x.zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return xml.NewDecoder(f).Decode(v)
+}
+
+// reports whether a number format displays a date or time
+func isDateFormat(id int, code string) bool {
+	if (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58) {
+		return true
+	}
+	if code == "" {
+		return false
+	}
+	// literal text and [colour]/[condition] sections never make a format a date
+	var sb strings.Builder
+	inQuote, inBracket := false, false
+	for _, r := range code {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case !inBracket:
+			sb.WriteRune(r)
+		}
+	}
+	return strings.ContainsAny(strings.ToLower(sb.String()), "dmyhs")
+}
+
+func (x *xlsxReader) loadStyles() error {
+	var styles xlsxStyles
+	if err := x.decodePart("xl/styles.xml", &styles); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	codes := make(map[int]string)
+	for _, f := range styles.NumFmts {
+		codes[f.ID] = f.Code
+	}
+	for i, xf := range styles.CellXfs {
+		if isDateFormat(xf.NumFmtID, codes[xf.NumFmtID]) {
+			x.dateStyles[i] = true
+		}
+	}
+	return nil
+}
+
+func (x *xlsxReader) loadSharedStrings() error {
+	var sst struct {
+		Items []xlsxRichText `xml:"si"`
+	}
+	if err := x.decodePart("xl/sharedStrings.xml", &sst); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	x.shared = make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		x.shared[i] = item.String()
+	}
+	return nil
+}
+
+// converts an Excel serial date to the text SQLite's date functions accept
+func (x *xlsxReader) serialToDate(serial float64) string {
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	t := x.epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.DateTime)
+}
+
+func (x *xlsxReader) cellValue(c xlsxCell) (any, error) {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(c.Value)
+		if err != nil || i < 0 || i >= len(x.shared) {
+			return nil, fmt.Errorf("cell %s: invalid shared string %q", c.Ref, c.Value)
+		}
+		return x.shared[i], nil
+	case "inlineStr":
+		return c.Inline.String(), nil
+	case "b":
+		return c.Value == "1", nil
+	case "str", "e":
+		return c.Value, nil
+	}
+	if c.Value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(c.Value, 64)
+	if err != nil {
+		return c.Value, nil
+	}
+	if x.dateStyles[c.Style] {
+		return x.serialToDate(f), nil
+	}
+	if f == math.Trunc(f) && math.Abs(f) <= xlsxMaxExactInt {
+		return int64(f), nil
+	}
+	return f, nil
+}
+
+// the last column a worksheet can have, XFD
+const xlsxMaxColumn = 16383
+
+// returns the zero based column of a cell reference such as "AB12", or -1
+// when it names no column of a worksheet
+func xlsxColumnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		if col-1 > xlsxMaxColumn {
+			return -1
+		}
+	}
+	return col - 1
+}
+
+func xlsxColumnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// streams the rows of one worksheet; blank rows are dropped
+func (x *xlsxReader) readSheet(name string) ([][]any, error) {
+	f, err := x.zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := xml.NewDecoder(f)
+	var rows [][]any
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var row xlsxRow
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return nil, err
+		}
+		var values []any
+		blank := true
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = xlsxColumnIndex(c.Ref)
+			}
+			if col < 0 || col > xlsxMaxColumn {
+				return nil, fmt.Errorf("invalid cell reference %q", c.Ref)
+			}
+			v, err := x.cellValue(c)
+			if err != nil {
+				return nil, err
+			}
+			for len(values) <= col {
+				values = append(values, nil)
+			}
+			values[col] = v
+			if v != nil && v != "" {
+				blank = false
+			}
+		}
+		if !blank {
+			rows = append(rows, values)
+		}
+	}
+}
+
+// turns the rows of a sheet into a Dataframe, using the first row as header.
+// Also returns the column names in sheet order.
+func sheetToDataframe(rows [][]any) (Dataframe, []string) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+	header := make([]string, width)
+	seen := make(map[string]bool)
+	for i := range header {
+		name := ""
+		if i < len(rows[0]) && rows[0][i] != nil {
+			name = strings.Trim(sqlSanitize(fmt.Sprint(rows[0][i])), `"`)
+		}
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		unique := name
+		for n := 2; seen[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[unique] = true
+		// sqlSanitize quotes names, Dataframe columns are kept that way
+		header[i] = `"` + unique + `"`
+	}
+	df := make(Dataframe, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		series := make(Series, width)
+		for i, col := range header {
+			if i < len(row) {
+				series[col] = row[i]
+			} else {
+				series[col] = nil
+			}
+		}
+		df = append(df, series)
+	}
+	return df, header
+}
+
+// reads every sheet of an xlsx workbook into a Dataframe named after the
+// sheet, along with its columns in sheet order. Sheets without data rows are
+// skipped.
+func readXLSX(r io.ReaderAt, size int64) ([]string, []*Dataframe, [][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not an xlsx workbook: %w", err)
+	}
+	x := &xlsxReader{zr: zr, dateStyles: make(map[int]bool), epoch: xlsxEpoch1900}
+	var workbook xlsxWorkbook
+	if err := x.decodePart("xl/workbook.xml", &workbook); err != nil {
+		return nil, nil, nil, fmt.Errorf("not an xlsx workbook: %w", err)
+	}
+	if workbook.Properties.Date1904 {
+		x.epoch = xlsxEpoch1904
+	}
+	var rels xlsxRelationships
+	if err := x.decodePart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, nil, nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	if err := x.loadSharedStrings(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := x.loadStyles(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var names []string
+	var dfs []*Dataframe
+	var columns [][]string
+	for _, sheet := range workbook.Sheets {
+		target, ok := targets[sheet.ID]
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("sheet %s has no worksheet part", sheet.Name)
+		}
+		rows, err := x.readSheet(target)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("reading sheet %s: %w", sheet.Name, err)
+		}
+		df, header := sheetToDataframe(rows)
+		if len(df) == 0 {
+			continue
+		}
+		name := sqlSanitize(sheet.Name)
+		for i := 2; slices.Contains(names, name); i++ {
+			name = fmt.Sprintf(`%s_%d"`, strings.TrimSuffix(sqlSanitize(sheet.Name), `"`), i)
+		}
+		names = append(names, name)
+		dfs = append(dfs, &df)
+		columns = append(columns, header)
+	}
+	if len(dfs) == 0 {
+		return nil, nil, nil, errors.New("workbook has no sheets with data")
+	}
+	return names, dfs, columns, nil
+}
+
+// writes an xlsx workbook one sheet at a time; each sheet has to be closed
+// before the next one is added
+type xlsxWriter struct {
+	zw     *zip.Writer
+	sheets []string
+}
+
+func newXLSXWriter(w io.Writer) *xlsxWriter {
+	return &xlsxWriter{zw: zip.NewWriter(w)}
+}
+
+// makes name valid and unique as an Excel sheet name
+func (x *xlsxWriter) sheetName(name string) string {
+	name = strings.Trim(xlsxSheetNameReplacer.Replace(name), "'")
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", len(x.sheets)+1)
+	}
+	truncate := func(s string, n int) string {
+		for utf8.RuneCountInString(s) > n {
+			_, size := utf8.DecodeLastRuneInString(s)
+			s = s[:len(s)-size]
+		}
+		return s
+	}
+	candidate := truncate(name, xlsxMaxSheetName)
+	for i := 2; ; i++ {
+		taken := false
+		for _, s := range x.sheets {
+			if strings.EqualFold(s, candidate) {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate = truncate(name, xlsxMaxSheetName-len(suffix)) + suffix
+	}
+}
+
+func (x *xlsxWriter) AddSheet(name string) (*xlsxSheetWriter, error) {
+	x.sheets = append(x.sheets, x.sheetName(name))
+	w, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxSheetWriter{w: bufio.NewWriter(w)}, nil
+}
+
+func (x *xlsxWriter) writePart(name string, body string) error {
+	w, err := x.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header+body)
+	return err
+}
+
+// writes the workbook parts that list the sheets and closes the zip
+func (x *xlsxWriter) Close() error {
+	if len(x.sheets) == 0 {
+		sheet, err := x.AddSheet("")
+		if err != nil {
+			return err
+		}
+		if err := sheet.WriteHeader(nil); err != nil {
+			return err
+		}
+		if err := sheet.Close(); err != nil {
+			return err
+		}
+	}
+	var workbook, rels, types strings.Builder
+	for i, name := range x.sheets {
+		workbook.WriteString(`<sheet name="`)
+		xml.EscapeText(&workbook, []byte(name))
+		fmt.Fprintf(&workbook, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s" Target="worksheets/sheet%d.xml"/>`, i+1, xlsxSheetType, i+1)
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="` + xlsxPkgRelNS + `">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="` + xlsxMainNS + `" xmlns:r="` + xlsxRelNS + `"><sheets>` + workbook.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="` + xlsxPkgRelNS + `">` + rels.String() +
+			fmt.Sprintf(`<Relationship Id="rId%d" Type="%s" Target="styles.xml"/>`, len(x.sheets)+1, xlsxStylesType) +
+			`</Relationships>`},
+		// style 1 shows dates as yyyy-mm-dd hh:mm
+		{"xl/styles.xml", `<styleSheet xmlns="` + xlsxMainNS + `">` +
+			`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+			`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>` +
+			`</styleSheet>`},
+	}
+	for _, part := range parts {
+		if err := x.writePart(part.name, part.body); err != nil {
+			return err
+		}
+	}
+	return x.zw.Close()
+}
+
+// rowWriter for one worksheet. With book set, Close also finishes the
+// workbook, which is how single query results are exported.
+type xlsxSheetWriter struct {
+	w    *bufio.Writer
+	row  int
+	book *xlsxWriter
+}
+
+func (s *xlsxSheetWriter) writeString(ref string, text string) {
+	fmt.Fprintf(s.w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+	xml.EscapeText(s.w, []byte(text))
+	s.w.WriteString(`</t></is></c>`)
+}
+
+func (s *xlsxSheetWriter) writeCell(col int, value any) {
+	ref := xlsxColumnName(col) + strconv.Itoa(s.row)
+	switch v := value.(type) {
+	case nil:
+	case int64:
+		if v > xlsxMaxExactInt || v < -xlsxMaxExactInt {
+			s.writeString(ref, strconv.FormatInt(v, 10))
+			return
+		}
+		fmt.Fprintf(s.w, `<c r="%s"><v>%d</v></c>`, ref, v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s.writeString(ref, strconv.FormatFloat(v, 'g', -1, 64))
+			return
+		}
+		fmt.Fprintf(s.w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		b := 0
+		if v {
+			b = 1
+		}
+		fmt.Fprintf(s.w, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+	case time.Time:
+		serial := v.Sub(xlsxEpoch1900).Hours() / 24
+		fmt.Fprintf(s.w, `<c r="%s" s="1"><v>%s</v></c>`, ref, strconv.FormatFloat(serial, 'f', -1, 64))
+	case []byte:
+		s.writeString(ref, hex.EncodeToString(v))
+	case string:
+		s.writeString(ref, v)
+	default:
+		s.writeString(ref, fmt.Sprint(v))
+	}
+}
+
+func (s *xlsxSheetWriter) WriteHeader(cols []string) error {
+	s.w.WriteString(xml.Header)
+	s.w.WriteString(`<worksheet xmlns="` + xlsxMainNS + `"><sheetData>`)
+	if len(cols) == 0 {
+		return nil
+	}
+	values := make([]any, len(cols))
+	for i, col := range cols {
+		values[i] = col
+	}
+	return s.WriteRow(values)
+}
+
+func (s *xlsxSheetWriter) WriteRow(values []any) error {
+	s.row++
+	fmt.Fprintf(s.w, `<row r="%d">`, s.row)
+	for i, v := range values {
+		s.writeCell(i, v)
+	}
+	_, err := s.w.WriteString(`</row>`)
+	return err
+}
+
+func (s *xlsxSheetWriter) Close() error {
+	s.w.WriteString(`</sheetData></worksheet>`)
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if s.book != nil {
+		return s.book.Close()
+	}
+	return nil
+}
+
+// a named query that becomes one sheet of an exported workbook
+type XLSXSheet struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// writes each query as a sheet of a new workbook at filePath
+func (a *App) exportXLSX(filePath string, sheets []XLSXSheet) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	book := newXLSXWriter(file)
+	for i, sheet := range sheets {
+		w, err := book.AddSheet(sheet.Name)
+		if err != nil {
+			return err
+		}
+		n, err := streamRows(a.db, sheet.Query, w)
+		if err != nil {
+			return fmt.Errorf("exporting sheet %s: %w", sheet.Name, err)
+		}
+		a.emitData(EXPORT_PROGRESS, ExportProgress{Table: sheet.Name, Rows: n, Done: i + 1, Total: len(sheets), Format: FORMAT_XLSX})
+	}
+	if err := book.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// one sheet per table of dbName, or per table of every stored database
+// named <db>.<table> when dbName is empty
+func (a *App) tableSheets(dbName string) ([]XLSXSheet, error) {
+	dbs := []string{dbName}
+	if dbName == "" {
+		var err error
+		if dbs, err = a.getSQLiteDBNames(); err != nil {
+			return nil, err
+		}
+	}
+	var sheets []XLSXSheet
+	for _, db := range dbs {
+		tables, err := a.getTableList(db)
+		if err != nil {
+			return nil, err
+		}
+		for _, tbl := range tables {
+			name := tbl
+			if dbName == "" {
+				name = db + "." + tbl
+			}
+			sheets = append(sheets, XLSXSheet{
+				Name:  name,
+				Query: fmt.Sprintf("SELECT * FROM %s;", quoteQualified(db, tbl)),
+			})
+		}
+	}
+	return sheets, nil
+}
+
+// exports req.Queries as one sheet each, or otherwise every table of req.DB
+// (or of the whole workspace) as one sheet per table
+func (a *App) ExportXLSX(req XLSXExportRequest) AppResult {
+	if req.Path == "" {
+		return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+	}
+	sheets := req.Queries
+	for i := range sheets {
+		sheets[i].Query = cleanQuery(sheets[i].Query)
+		if !a.isExportableQuery(sheets[i].Query) {
+			return a.newResult(errors.New(BadRequestError), map[string]any{"error": BadRequestError}, nil)
+		}
+	}
+	if len(sheets) == 0 {
+		var err error
+		if sheets, err = a.tableSheets(req.DB); err != nil {
+			a.logger.Error(err.Error())
+			return a.newResult(err, nil, nil)
+		}
+	}
+	if err := a.exportXLSX(req.Path, sheets); err != nil {
+		a.logger.Error(err.Error())
+		return a.newResult(err, nil, nil)
+	}
+	return a.newResult(nil, map[string]any{"path": req.Path, "sheets": len(sheets)}, nil)
+}
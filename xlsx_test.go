@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestXLSXColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		name  string
+	}{
+		{0, "A"}, {25, "Z"}, {26, "AA"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"}, {16383, "XFD"},
+	}
+	for _, tt := range tests {
+		if got := xlsxColumnName(tt.index); got != tt.name {
+			t.Errorf("xlsxColumnName(%d) = %s, want %s", tt.index, got, tt.name)
+		}
+		if got := xlsxColumnIndex(tt.name + "12"); got != tt.index {
+			t.Errorf("xlsxColumnIndex(%s12) = %d, want %d", tt.name, got, tt.index)
+		}
+	}
+	for _, ref := range []string{"XFE1", "ZZZZZZZZZ1", "ZZZZZZZZZZZZZZZZZZZZ1"} {
+		if got := xlsxColumnIndex(ref); got != -1 {
+			t.Errorf("xlsxColumnIndex(%s) = %d, want -1", ref, got)
+		}
+	}
+}
+
+func TestXLSXColumnOutOfRange(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("xl/worksheets/sheet1.xml")
+	w.Write([]byte(`<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>ok</t></is></c><c r="ZZZZZZZZZ1"><v>1</v></c></row></sheetData></worksheet>`))
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &xlsxReader{zr: zr, dateStyles: make(map[int]bool), epoch: xlsxEpoch1900}
+	if _, err := x.readSheet("xl/worksheets/sheet1.xml"); err == nil || !strings.Contains(err.Error(), "ZZZZZZZZZ1") {
+		t.Errorf("readSheet() error = %v, want the cell reference rejected", err)
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	tests := []struct {
+		id   int
+		code string
+		want bool
+	}{
+		{14, "", true},
+		{0, "General", false},
+		{164, "yyyy-mm-dd", true},
+		{165, "#,##0.00", false},
+		{166, `[Red]0.00" days"`, false},
+		{167, "[$-409]h:mm AM/PM", true},
+	}
+	for _, tt := range tests {
+		if got := isDateFormat(tt.id, tt.code); got != tt.want {
+			t.Errorf("isDateFormat(%d, %q) = %v, want %v", tt.id, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	db, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec(`
+	CREATE TABLE orders (id INTEGER, customer TEXT, total REAL, placed DATETIME, note TEXT);
+	INSERT INTO orders VALUES
+		(1, 'Ann & <Co>', 10.5, '2024-03-01 10:30:00', NULL),
+		(2, 'Bob', 3, '2024-03-02', 'line
+break');
+	`)
+
+	var buf bytes.Buffer
+	book := newXLSXWriter(&buf)
+	for _, name := range []string{"Orders: 2024/Q1", "orders: 2024/q1"} {
+		sheet, err := book.AddSheet(name)
+		if err != nil {
+			t.Fatalf("AddSheet() error = %v", err)
+		}
+		if _, err := streamRows(db, "SELECT * FROM orders;", sheet); err != nil {
+			t.Fatalf("streamRows() error = %v", err)
+		}
+	}
+	if err := book.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	names, dfs, columns, err := readXLSX(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("readXLSX() error = %v", err)
+	}
+	if len(names) != 2 || names[0] == names[1] {
+		t.Fatalf("readXLSX() tables = %v, want two distinct tables", names)
+	}
+	df := *dfs[0]
+	if len(df) != 2 {
+		t.Fatalf("readXLSX() rows = %d, want 2", len(df))
+	}
+	want := []Series{
+		{`"id"`: int64(1), `"customer"`: "Ann & <Co>", `"total"`: 10.5, `"placed"`: "2024-03-01 10:30:00", `"note"`: nil},
+		{`"id"`: int64(2), `"customer"`: "Bob", `"total"`: int64(3), `"placed"`: "2024-03-02", `"note"`: "line\nbreak"},
+	}
+	for i, row := range want {
+		for col, v := range row {
+			if df[i][col] != v {
+				t.Errorf("row %d column %s = %#v, want %#v", i, col, df[i][col], v)
+			}
+		}
+	}
+
+	dst, err := sqlx.Open(SQLITE_DRIVER, ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %v", err)
+	}
+	defer dst.Close()
+	dst.SetMaxOpenConns(1)
+	if err := convertToSQLite(dfs[0], dst, names[0], columns[0]...); err != nil {
+		t.Fatalf("convertToSQLite() error = %v", err)
+	}
+	var order string
+	if err := dst.Get(&order, "SELECT group_concat(name, ',') FROM pragma_table_info(?);", strings.Trim(names[0], `"`)); err != nil || order != "id,customer,total,placed,note" {
+		t.Errorf("converted columns = %q, %v, want sheet order", order, err)
+	}
+}